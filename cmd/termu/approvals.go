@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/niradler/termu/internal/config"
+	"github.com/niradler/termu/internal/security"
+	"github.com/spf13/cobra"
+)
+
+var approvalsCmd = &cobra.Command{
+	Use:   "approvals",
+	Short: "List and revoke project command approvals",
+	Long: `Commands approved "always for this project" are saved in a file under
+~/.termu/approvals named after the working directory, one pattern per line,
+outside the project so the agent cannot approve its own commands. A pattern
+is an exact command or a prefix followed by " *", such as "git push *".
+Approved commands skip the prompt; blocked patterns, folder restrictions and
+command policies still apply.`,
+}
+
+var approvalsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List project approvals",
+	Args:  cobra.NoArgs,
+	RunE:  listApprovals,
+}
+
+var approvalsRevokeCmd = &cobra.Command{
+	Use:   "revoke <pattern|n>",
+	Short: "Revoke a project approval by pattern or list number",
+	Args:  cobra.ExactArgs(1),
+	RunE:  revokeApproval,
+}
+
+func init() {
+	approvalsCmd.AddCommand(approvalsListCmd)
+	approvalsCmd.AddCommand(approvalsRevokeCmd)
+}
+
+func approvalValidator() (*security.Validator, error) {
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	validator := security.New(cfg)
+	if err := validator.ProjectApprovalsError(); err != nil {
+		return nil, err
+	}
+	return validator, nil
+}
+
+func listApprovals(cmd *cobra.Command, args []string) error {
+	validator, err := approvalValidator()
+	if err != nil {
+		return err
+	}
+
+	approvals := validator.Approvals()
+	if len(approvals) == 0 {
+		fmt.Println("No project approvals for this directory.")
+		return nil
+	}
+	for i, approval := range approvals {
+		fmt.Printf("%d. %s\n", i+1, approval.Pattern)
+	}
+	return nil
+}
+
+func revokeApproval(cmd *cobra.Command, args []string) error {
+	validator, err := approvalValidator()
+	if err != nil {
+		return err
+	}
+
+	pattern := args[0]
+	if n, err := strconv.Atoi(pattern); err == nil {
+		approvals := validator.Approvals()
+		if n < 1 || n > len(approvals) {
+			return fmt.Errorf("no project approval %d", n)
+		}
+		pattern = approvals[n-1].Pattern
+	}
+
+	found, err := validator.Revoke(pattern, security.ApproveProject)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("no project approval %q", pattern)
+	}
+	fmt.Printf("Revoked %s\n", pattern)
+	return nil
+}
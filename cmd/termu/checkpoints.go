@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/niradler/termu/internal/checkpoint"
+	"github.com/spf13/cobra"
+)
+
+var checkpointSession string
+
+var checkpointsCmd = &cobra.Command{
+	Use:   "checkpoints",
+	Short: "List and restore file checkpoints",
+	Long: `Before the agent changes a file, termu saves its previous content in a
+checkpoint for the current turn. Checkpoints are kept per session under
+~/.termu/sessions/ and work whether or not the directory is a git repository.
+
+Commands act on the most recently changed session unless --session is given.`,
+}
+
+var checkpointsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List checkpoints and the files they saved",
+	Args:  cobra.NoArgs,
+	RunE:  listCheckpoints,
+}
+
+var checkpointsRestoreCmd = &cobra.Command{
+	Use:   "restore <n>",
+	Short: "Restore files to their state before checkpoint n",
+	Long: `Restore every file changed in checkpoint n or later to its content before
+checkpoint n. Files the agent created are removed. The restored checkpoints
+are dropped.`,
+	Args: cobra.ExactArgs(1),
+	RunE: restoreCheckpoint,
+}
+
+func init() {
+	checkpointsCmd.PersistentFlags().StringVar(&checkpointSession, "session", "", "session ID or unique ID prefix (default: most recent)")
+	checkpointsCmd.AddCommand(checkpointsListCmd)
+	checkpointsCmd.AddCommand(checkpointsRestoreCmd)
+}
+
+// openCheckpoints opens the checkpoint store selected by --session.
+func openCheckpoints() (*checkpoint.Store, string, error) {
+	store, err := sessionStore()
+	if err != nil {
+		return nil, "", err
+	}
+
+	ids, err := checkpoint.Sets(store.CheckpointRoot())
+	if err != nil {
+		return nil, "", err
+	}
+	if len(ids) == 0 {
+		return nil, "", fmt.Errorf("no checkpoints for this directory")
+	}
+
+	id := ids[0]
+	if checkpointSession != "" {
+		id = ""
+		for _, candidate := range ids {
+			if candidate == checkpointSession {
+				id = candidate
+				break
+			}
+			if strings.HasPrefix(candidate, checkpointSession) {
+				if id != "" {
+					return nil, "", fmt.Errorf("session ID %q is ambiguous", checkpointSession)
+				}
+				id = candidate
+			}
+		}
+		if id == "" {
+			return nil, "", fmt.Errorf("no checkpoints for session %s", checkpointSession)
+		}
+	}
+
+	checkpoints, err := checkpoint.Open(store.CheckpointDir(id))
+	return checkpoints, id, err
+}
+
+func listCheckpoints(cmd *cobra.Command, args []string) error {
+	checkpoints, id, err := openCheckpoints()
+	if err != nil {
+		return err
+	}
+
+	list := checkpoints.List()
+	if len(list) == 0 {
+		fmt.Printf("No checkpoints left for session %s.\n", id)
+		return nil
+	}
+
+	workdir, _ := os.Getwd()
+	fmt.Printf("Session %s\n\n", id)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "N\tCREATED\tFILES\tPROMPT")
+	for _, cp := range list {
+		fmt.Fprintf(w, "%d\t%s\t%d\t%s\n",
+			cp.ID,
+			cp.CreatedAt.Local().Format("2006-01-02 15:04"),
+			len(cp.Files),
+			cp.Label,
+		)
+		for _, snap := range cp.Files {
+			status := "modified"
+			if !snap.Existed {
+				status = "created"
+			}
+			fmt.Fprintf(w, "\t\t\t  %s (%s)\n", displayPath(workdir, snap.Path), status)
+		}
+	}
+	return w.Flush()
+}
+
+func restoreCheckpoint(cmd *cobra.Command, args []string) error {
+	n, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid checkpoint %q", args[0])
+	}
+
+	checkpoints, id, err := openCheckpoints()
+	if err != nil {
+		return err
+	}
+
+	restored, err := checkpoints.Restore(n)
+	if err != nil {
+		return err
+	}
+
+	workdir, _ := os.Getwd()
+	fmt.Printf("Restored %d file(s) to before checkpoint %d of session %s:\n", len(restored), n, id)
+	for _, path := range restored {
+		fmt.Printf("  %s\n", displayPath(workdir, path))
+	}
+	return nil
+}
+
+func displayPath(workdir, path string) string {
+	if rel, err := filepath.Rel(workdir, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return path
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/niradler/termu/internal/audit"
+	"github.com/niradler/termu/internal/config"
+	"github.com/spf13/cobra"
+)
+
+var (
+	logsLines   int
+	logsFollow  bool
+	logsTypes   []string
+	logsSession string
+	logsLevel   string
+	logsSince   time.Duration
+	logsGrep    string
+	logsJSON    bool
+)
+
+var logsCmd = &cobra.Command{
+	Use:   "logs",
+	Short: "Show and filter the audit log",
+	Long: `termu records prompts, model calls, tool calls, validation and approval
+decisions and file changes as JSON lines in the file set by logging.file
+(~/.termu/logs/termu.log by default), rotated once it grows past
+logging.max_size_mb. With logging.level: debug tool output is recorded too.
+
+Event types: ` + strings.Join([]string{audit.EventPrompt, audit.EventModelCall, audit.EventToolCall, audit.EventValidation, audit.EventApproval, audit.EventFileChange}, ", ") + `.`,
+	Args: cobra.NoArgs,
+	RunE: showLogs,
+}
+
+func init() {
+	logsCmd.Flags().IntVarP(&logsLines, "lines", "n", 50, "number of events to show (0 for all)")
+	logsCmd.Flags().BoolVarP(&logsFollow, "follow", "f", false, "keep printing new events as they are logged")
+	logsCmd.Flags().StringSliceVarP(&logsTypes, "type", "t", nil, "only show events of these types")
+	logsCmd.Flags().StringVar(&logsSession, "session", "", "only show events of a session ID or ID prefix")
+	logsCmd.Flags().StringVar(&logsLevel, "level", "", "only show events at or above this level (debug, info, warn, error)")
+	logsCmd.Flags().DurationVar(&logsSince, "since", 0, "only show events from this long ago, e.g. 1h")
+	logsCmd.Flags().StringVar(&logsGrep, "grep", "", "only show events containing this text")
+	logsCmd.Flags().BoolVar(&logsJSON, "json", false, "print events as JSON lines")
+}
+
+func showLogs(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	filter := audit.Filter{
+		Types:   logsTypes,
+		Session: logsSession,
+		Level:   logsLevel,
+		Text:    logsGrep,
+	}
+	if logsSince > 0 {
+		filter.Since = time.Now().Add(-logsSince)
+	}
+
+	path := audit.Path(cfg.Logging)
+	events, err := audit.Read(path, filter, logsLines)
+	if err != nil {
+		return fmt.Errorf("failed to read audit log: %w", err)
+	}
+	if len(events) == 0 && !logsFollow {
+		fmt.Printf("No matching events in %s\n", path)
+		return nil
+	}
+	for _, event := range events {
+		printEvent(event)
+	}
+
+	if !logsFollow {
+		return nil
+	}
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt)
+	defer stop()
+	return audit.Follow(ctx, path, filter, printEvent)
+}
+
+func printEvent(event audit.Event) {
+	if logsJSON {
+		line, _ := json.Marshal(event)
+		fmt.Println(string(line))
+		return
+	}
+
+	fmt.Printf("%s %-5s %-20s %-11s %s\n",
+		event.Time.Local().Format("2006-01-02 15:04:05"),
+		event.Level, event.Session, event.Type, event.Summary())
+}
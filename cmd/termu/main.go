@@ -2,21 +2,33 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/niradler/termu/internal/config"
+	"github.com/niradler/termu/internal/session"
+	"github.com/niradler/termu/internal/shell"
 	"github.com/niradler/termu/internal/tools"
 	"github.com/niradler/termu/internal/tui"
 	"github.com/spf13/cobra"
 )
 
 var (
-	configFile  string
-	sandboxMode bool
+	configFile     string
+	sandboxMode    bool
+	scratchMode    bool
+	runAutoApprove bool
+	runNoExec      bool
+	resumeID       string
+	continueLast   bool
+	modelProfile   string
 )
 
+// resumeLatest is the --resume value used when no session ID is given.
+const resumeLatest = "latest"
+
 var rootCmd = &cobra.Command{
 	Use:     "termu [command]",
 	Short:   "termu - Your Terminal Sidekick",
@@ -28,16 +40,25 @@ var rootCmd = &cobra.Command{
 var chatCmd = &cobra.Command{
 	Use:   "chat",
 	Short: "Start interactive chat session",
-	Long:  `Start a chat session with termu in your current directory`,
-	RunE:  runChat,
+	Long: `Start a chat session with termu in your current directory.
+
+Sessions are saved under ~/.termu/sessions/ per working directory. Use
+--continue to pick up the most recent one or --resume <id> for a specific one.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runChat,
 }
 
 var runCmd = &cobra.Command{
 	Use:   "run [prompt]",
 	Short: "Execute a quick command",
-	Long:  `Generate and execute a command from natural language`,
-	Args:  cobra.ExactArgs(1),
-	RunE:  runCommand,
+	Long: `Run a single request non-interactively and print the answer to stdout.
+
+Commands that need approval are confirmed on the terminal, or decided up
+front with --yes or --no-exec. Exit status is 0 on success, 1 on error,
+2 when a command was not approved and 130 when interrupted.`,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE:         runCommand,
 }
 
 var installToolsCmd = &cobra.Command{
@@ -49,8 +70,27 @@ var installToolsCmd = &cobra.Command{
 
 func init() {
 	rootCmd.PersistentFlags().StringVar(&configFile, "config", "", "config file")
+	rootCmd.PersistentFlags().BoolVar(&sandboxMode, "sandbox", false, "record file writes and commands for review instead of performing them")
+	rootCmd.PersistentFlags().BoolVar(&scratchMode, "scratch", false, "work in a temporary copy of the directory and review the changes at the end")
+	rootCmd.MarkFlagsMutuallyExclusive("sandbox", "scratch")
+
+	runCmd.Flags().BoolVarP(&runAutoApprove, "yes", "y", false, "approve every command without prompting")
+	runCmd.Flags().BoolVar(&runNoExec, "no-exec", false, "deny every command that needs approval")
+	runCmd.MarkFlagsMutuallyExclusive("yes", "no-exec")
+	runCmd.Flags().StringVar(&modelProfile, "model", "", "model profile to use, from the models section of the config")
+
+	chatCmd.Flags().StringVar(&resumeID, "resume", "", "resume a saved session by ID (latest when no ID is given)")
+	chatCmd.Flags().Lookup("resume").NoOptDefVal = resumeLatest
+	chatCmd.Flags().BoolVarP(&continueLast, "continue", "c", false, "continue the most recent session")
+	chatCmd.Flags().StringVar(&modelProfile, "model", "", "model profile to use, from the models section of the config")
 
 	rootCmd.AddCommand(chatCmd)
+	rootCmd.AddCommand(sessionsCmd)
+	rootCmd.AddCommand(checkpointsCmd)
+	rootCmd.AddCommand(approvalsCmd)
+	rootCmd.AddCommand(policyCmd)
+	rootCmd.AddCommand(logsCmd)
+	rootCmd.AddCommand(usageCmd)
 	rootCmd.AddCommand(runCmd)
 	rootCmd.AddCommand(installToolsCmd)
 }
@@ -60,9 +100,41 @@ func runChat(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
+	if modelProfile != "" {
+		if err := cfg.UseModel(modelProfile); err != nil {
+			return err
+		}
+	}
+
+	store := session.NewStore(cfg.Workdir)
+	options := tui.Options{
+		Sandbox: sandboxMode || cfg.Security.SandboxMode,
+		Scratch: scratchMode || cfg.Security.ScratchMode,
+		Store:   store,
+	}
+
+	if resumeID == resumeLatest && len(args) == 1 {
+		resumeID = args[0]
+	} else if len(args) > 0 {
+		return fmt.Errorf("unexpected argument %q", args[0])
+	}
+	if continueLast {
+		resumeID = resumeLatest
+	}
+
+	switch resumeID {
+	case "":
+	case resumeLatest:
+		options.Session, err = store.Latest()
+	default:
+		options.Session, err = store.Load(resumeID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to resume session: %w", err)
+	}
 
 	ctx := context.Background()
-	model, err := tui.NewModel(ctx, cfg, false)
+	model, err := tui.NewModel(ctx, cfg, options)
 	if err != nil {
 		return fmt.Errorf("failed to create model: %w", err)
 	}
@@ -73,26 +145,18 @@ func runChat(cmd *cobra.Command, args []string) error {
 		tea.WithMouseCellMotion(),
 	)
 
-	if _, err := p.Run(); err != nil {
-		return fmt.Errorf("failed to start chat: %w", err)
-	}
-
-	return nil
-}
-
-func runCommand(cmd *cobra.Command, args []string) error {
-	cfg, err := config.Load(configFile)
+	final, err := p.Run()
 	if err != nil {
-		return fmt.Errorf("failed to load config: %w", err)
+		return fmt.Errorf("failed to start chat: %w", err)
 	}
 
-	if sandboxMode || cfg.Security.SandboxMode {
-		sandboxMode = true
+	if m, ok := final.(tui.Model); ok {
+		m.Close()
+		if m.ExitSummary() != "" {
+			fmt.Println(m.ExitSummary())
+		}
 	}
 
-	fmt.Printf("Processing: %s\n", args[0])
-	fmt.Println("Quick run mode coming soon. Use 'termu chat' for now.")
-
 	return nil
 }
 
@@ -102,8 +166,14 @@ func installTools(cmd *cobra.Command, args []string) error {
 }
 
 func main() {
+	shell.RunIsolationHelper()
+
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		var exitErr *exitCodeError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.code)
+		}
+		os.Exit(exitError)
 	}
 }
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/niradler/termu/internal/config"
+	"github.com/niradler/termu/internal/security"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	policyFile    string
+	policyCommand string
+	policyTool    string
+	policyPaths   []string
+	policyRisk    string
+	policyTime    string
+	policyExpect  string
+)
+
+var policyCmd = &cobra.Command{
+	Use:   "policy",
+	Short: "Work with the policy file",
+	Long: `security.policy_file names a YAML file of rules written in CEL that decide
+on commands and file operations the static security lists allow:
+
+  rules:
+    - name: kubectl-context
+      when: command.name == "kubectl" && !("--context" in command.flags)
+      action: deny
+      message: always pass --context
+
+The first rule whose when expression holds decides: allow, deny or ask.
+Rules see tool, command (name, args, subcommand, flags, source), line,
+paths, workdir, risk, now, hour and weekday.`,
+}
+
+var policyTestCmd = &cobra.Command{
+	Use:   "test [cases.yaml]",
+	Short: "Evaluate the policy file on sample commands and paths",
+	Long: `Evaluates the policy file on sample operations without running anything.
+Give one case with flags, or a YAML file of cases:
+
+  cases:
+    - name: deploy after hours
+      command: ./scripts/deploy.sh prod
+      time: "22:30"
+      expect: deny
+    - tool: write_file
+      paths: [.env]
+      expect: deny
+
+A case passes when the decision is its expected action: allow, deny, ask,
+or none when no rule should match. The exit status is non-zero when any
+case fails.`,
+	Args:         cobra.MaximumNArgs(1),
+	SilenceUsage: true,
+	RunE:         testPolicy,
+}
+
+func init() {
+	policyCmd.PersistentFlags().StringVar(&policyFile, "policy", "", "policy file to test instead of security.policy_file")
+	policyTestCmd.Flags().StringVar(&policyCommand, "command", "", "shell command to evaluate")
+	policyTestCmd.Flags().StringVar(&policyTool, "tool", "", "tool performing the operation (default execute_command)")
+	policyTestCmd.Flags().StringSliceVar(&policyPaths, "path", nil, "file the tool operates on")
+	policyTestCmd.Flags().StringVar(&policyRisk, "risk", "", "risk level to evaluate with instead of the assessed one")
+	policyTestCmd.Flags().StringVar(&policyTime, "time", "", "time to evaluate at, RFC 3339 or HH:MM (default now)")
+	policyTestCmd.Flags().StringVar(&policyExpect, "expect", "", "expected action: allow, deny, ask or none")
+	policyCmd.AddCommand(policyTestCmd)
+}
+
+func testPolicy(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	if policyFile != "" {
+		cfg.Security.PolicyFile = policyFile
+	}
+	if cfg.Security.PolicyFile == "" {
+		return fmt.Errorf("no policy file configured; set security.policy_file or pass --policy")
+	}
+
+	var cases []security.PolicyCase
+	if len(args) == 1 {
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			return fmt.Errorf("failed to read cases: %w", err)
+		}
+		var file struct {
+			Cases []security.PolicyCase `yaml:"cases"`
+		}
+		if err := yaml.Unmarshal(data, &file); err != nil {
+			return fmt.Errorf("failed to parse cases: %w", err)
+		}
+		cases = file.Cases
+	}
+	if policyCommand != "" || len(policyPaths) > 0 {
+		cases = append(cases, security.PolicyCase{
+			Tool:    policyTool,
+			Command: policyCommand,
+			Paths:   policyPaths,
+			Risk:    policyRisk,
+			Time:    policyTime,
+			Expect:  policyExpect,
+		})
+	}
+	if len(cases) == 0 {
+		return fmt.Errorf("nothing to test: pass a cases file, --command or --path")
+	}
+
+	validator := security.New(cfg)
+	if err := validator.PolicyError(); err != nil {
+		return err
+	}
+
+	failed := 0
+	for i, c := range cases {
+		name := c.Name
+		if name == "" {
+			name = c.Command
+			if name == "" {
+				name = fmt.Sprintf("%s %s", c.Tool, strings.Join(c.Paths, " "))
+			}
+		}
+
+		outcome, err := validator.TestPolicy(c, cfg.Workdir)
+		if err != nil {
+			failed++
+			fmt.Printf("❌ %d. %s: %v\n", i+1, name, err)
+			continue
+		}
+
+		mark := "  "
+		if c.Expect != "" {
+			mark = "✅"
+			if !strings.EqualFold(c.Expect, outcome.Action) {
+				mark = "❌"
+				failed++
+			}
+		}
+		fmt.Printf("%s %d. %s → %s", mark, i+1, name, outcome.Action)
+		if c.Expect != "" && !strings.EqualFold(c.Expect, outcome.Action) {
+			fmt.Printf(" (expected %s)", strings.ToLower(c.Expect))
+		}
+		fmt.Println()
+		if outcome.Decision != nil {
+			fmt.Printf("     %s", outcome.Decision)
+			if outcome.Subject != name {
+				fmt.Printf(" [%s]", outcome.Subject)
+			}
+			fmt.Println()
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d policy cases failed", failed, len(cases))
+	}
+	return nil
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/niradler/termu/internal/agent"
+	"github.com/niradler/termu/internal/audit"
+	"github.com/niradler/termu/internal/checkpoint"
+	"github.com/niradler/termu/internal/config"
+	"github.com/niradler/termu/internal/sandbox"
+	"github.com/niradler/termu/internal/security"
+	"github.com/niradler/termu/internal/session"
+	"github.com/niradler/termu/internal/shell"
+	"github.com/niradler/termu/internal/tools"
+	"github.com/niradler/termu/internal/usage"
+	"github.com/spf13/cobra"
+)
+
+// Exit codes returned by `termu run`.
+const (
+	exitError       = 1
+	exitDenied      = 2
+	exitInterrupted = 130
+)
+
+// exitCodeError carries a specific process exit code back to main.
+type exitCodeError struct {
+	code int
+	err  error
+}
+
+func (e *exitCodeError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitCodeError) Unwrap() error {
+	return e.err
+}
+
+func runCommand(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	if modelProfile != "" {
+		if err := cfg.UseModel(modelProfile); err != nil {
+			return err
+		}
+	}
+
+	if sandboxMode || cfg.Security.SandboxMode {
+		sandboxMode = true
+	}
+	if scratchMode || cfg.Security.ScratchMode {
+		scratchMode = true
+	}
+	if sandboxMode && scratchMode {
+		return fmt.Errorf("sandbox and scratch modes cannot be combined")
+	}
+
+	// In scratch mode the run works on a copy of the working directory.
+	var scratch *sandbox.Scratch
+	if scratchMode {
+		scratch, err = sandbox.NewScratch(cfg.Workdir)
+		if err != nil {
+			return fmt.Errorf("failed to create scratch workspace: %w", err)
+		}
+		// The copy is removed at the end unless a failed review keeps it.
+		defer func() {
+			if scratch != nil {
+				scratch.Discard()
+			}
+		}()
+		cfg = scratch.Config(cfg)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	validator := security.New(cfg)
+	if err := validator.PolicyError(); err != nil {
+		return err
+	}
+	executor := shell.New(cfg.Workdir, sandboxMode)
+	executor.SetOutputLimit(cfg.Security.MaxOutputBytes)
+	executor.SetEnvironment(cfg.Security.Environment.Allow, cfg.Security.Environment.Deny)
+	if err := executor.Isolate(cfg.Security.Isolation); err != nil {
+		return err
+	}
+	defer executor.Close()
+
+	// A run is not saved as a session but gets an ID of its own, for its
+	// checkpoints and audit log entries.
+	runID := session.New(cfg.ProjectDir(), "").ID
+	auditLog, err := audit.Open(cfg.Logging, cfg.ProjectDir())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "⚠️  Audit log disabled: %v\n", err)
+	}
+	defer auditLog.Close()
+	auditLog.SetSession(runID)
+	opts := agent.Options{Validator: validator, Executor: executor, Audit: auditLog}
+
+	var changeset *sandbox.Changeset
+	var checkpoints *checkpoint.Store
+	if sandboxMode {
+		changeset = sandbox.New(cfg.Workdir)
+		executor.SetRecorder(changeset)
+		opts.Files = changeset
+	} else if scratch == nil {
+		checkpoints, err = checkpoint.Open(session.NewStore(cfg.Workdir).CheckpointDir(runID))
+		if err != nil {
+			return err
+		}
+		checkpoints.Begin(args[0])
+		opts.Files = checkpoints.Track(tools.DiskStore{})
+	}
+
+	ag, err := agent.New(ctx, cfg, opts)
+	if err != nil {
+		return fmt.Errorf("failed to create agent: %w", err)
+	}
+
+	denied := 0
+	resp, err := ag.Generate(ctx, args[0])
+	for err == nil && resp.Command != "" {
+		reportToolCalls(resp)
+		approved, promptErr := approveCommand(validator, resp)
+		if promptErr != nil {
+			return promptErr
+		}
+		if !approved {
+			denied++
+		}
+		resp, err = ag.Resume(ctx, agent.Decision{Approved: approved})
+	}
+
+	if checkpoints != nil && len(checkpoints.List()) > 0 {
+		fmt.Fprintf(os.Stderr, "↩️  File changes checkpointed; undo with: termu checkpoints restore 1 --session %s\n", runID)
+	}
+
+	if err != nil {
+		if ctx.Err() != nil {
+			return &exitCodeError{code: exitInterrupted, err: errors.New("interrupted")}
+		}
+		return &exitCodeError{code: exitError, err: err}
+	}
+
+	reportToolCalls(resp)
+	if resp.Model != "" && resp.Model != ag.ModelName() {
+		fmt.Fprintf(os.Stderr, "↪️  %s was unavailable; fallback model %s answered\n", ag.ModelName(), resp.Model)
+	}
+	reportUsage(cfg, resp.Usage)
+	fmt.Println(agent.StripThinking(resp.Text))
+
+	if changeset != nil && !changeset.Empty() {
+		if err := reviewChangeset(changeset); err != nil {
+			return err
+		}
+	}
+	if scratch != nil {
+		if err := reviewScratch(scratch); err != nil {
+			// Keep the copy so the work is not lost with it.
+			fmt.Fprintf(os.Stderr, "📁 Scratch workspace kept at %s\n", scratch.Workdir())
+			scratch = nil
+			return err
+		}
+	}
+
+	if denied > 0 {
+		return &exitCodeError{
+			code: exitDenied,
+			err:  fmt.Errorf("%d command(s) were not approved; the task may be incomplete", denied),
+		}
+	}
+
+	return nil
+}
+
+// reportToolCalls lists the tools the agent ran on stderr, keeping stdout
+// for the answer.
+func reportToolCalls(resp *agent.Response) {
+	for _, call := range resp.ToolCalls {
+		fmt.Fprintf(os.Stderr, "🔧 %s\n", call.Summary())
+	}
+}
+
+// reportUsage prints the tokens the run used, and their cost when the
+// models have prices, on stderr.
+func reportUsage(cfg *config.Config, tally usage.Tally) {
+	total := tally.Total()
+	if total.Requests == 0 {
+		return
+	}
+	estimated := ""
+	if total.Estimated {
+		estimated = "~"
+	}
+	fmt.Fprintf(os.Stderr, "📊 %s%d input and %d output tokens in %d request(s)", estimated, total.Input, total.Output, total.Requests)
+	if cost, priced := tally.Cost(cfg); cost > 0 || priced {
+		fmt.Fprintf(os.Stderr, ", %s", usage.FormatCost(cost))
+	}
+	fmt.Fprintln(os.Stderr)
+}
+
+// approveCommand decides on a command awaiting approval, using --yes or
+// --no-exec when given and otherwise asking on the terminal. Without a
+// terminal to ask on, the command is denied. On the terminal the user may
+// also approve the suggested pattern for the rest of the run (s) or for the
+// project (p).
+func approveCommand(validator *security.Validator, resp *agent.Response) (bool, error) {
+	risk := resp.Risk
+	if resp.Reason != "" {
+		risk += " — " + resp.Reason
+	}
+	fmt.Fprintf(os.Stderr, "⚠️  Command approval required (risk: %s)\n  $ %s\n", risk, resp.Command)
+
+	switch {
+	case runNoExec:
+		fmt.Fprintln(os.Stderr, "❌ Denied (--no-exec)")
+		return false, nil
+	case runAutoApprove:
+		fmt.Fprintln(os.Stderr, "✅ Approved (--yes)")
+		return true, nil
+	}
+
+	if !isTerminal(os.Stdin) {
+		fmt.Fprintln(os.Stderr, "❌ Denied (no terminal to prompt on; use --yes to approve)")
+		return false, nil
+	}
+
+	pattern := validator.SuggestPattern(resp.Command)
+	fmt.Fprintf(os.Stderr, "Approve? [y]es once, [s]ession or [p]roject for `%s`, [N]o: ", pattern)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && answer == "" {
+		return false, &exitCodeError{code: exitInterrupted, err: errors.New("no answer to approval prompt")}
+	}
+
+	var scope security.ApprovalScope
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		scope = security.ApproveOnce
+	case "s", "session":
+		scope = security.ApproveSession
+	case "p", "project":
+		scope = security.ApproveProject
+	default:
+		return false, nil
+	}
+
+	if err := validator.Approve(resp.Command, scope, pattern); err != nil {
+		fmt.Fprintf(os.Stderr, "⚠️  Approval not saved: %v\n", err)
+	}
+	return true, nil
+}
+
+// reviewChangeset prints the sandbox changeset to stderr and applies it when
+// --yes is given or the user confirms on the terminal.
+func reviewChangeset(changeset *sandbox.Changeset) error {
+	fmt.Fprintf(os.Stderr, "\n🧪 Sandbox changeset:\n%s\n", changeset.Summary())
+
+	if !confirmApply("Apply file changes?") {
+		changeset.Discard()
+		fmt.Fprintln(os.Stderr, "🗑️  Sandbox changeset discarded")
+		return nil
+	}
+
+	if err := changeset.Apply(); err != nil {
+		return &exitCodeError{code: exitError, err: fmt.Errorf("failed to apply changeset: %w", err)}
+	}
+	fmt.Fprintln(os.Stderr, "✅ Sandbox changeset applied")
+	return nil
+}
+
+// reviewScratch prints the scratch workspace's diff against the real tree
+// to stderr and applies it when --yes is given or the user confirms on the
+// terminal. When it fails the caller keeps the workspace.
+func reviewScratch(scratch *sandbox.Scratch) error {
+	changes, err := scratch.Changes()
+	if err != nil {
+		return &exitCodeError{code: exitError, err: fmt.Errorf("failed to compare the scratch workspace: %w", err)}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "\n🧪 Scratch workspace changes:\n%s\n", scratch.Summary(changes))
+
+	if !confirmApply("Apply changes to your tree?") {
+		fmt.Fprintln(os.Stderr, "🗑️  Scratch workspace discarded")
+		return nil
+	}
+	if err := scratch.Apply(changes); err != nil {
+		return &exitCodeError{code: exitError, err: fmt.Errorf("failed to apply scratch changes: %w", err)}
+	}
+	fmt.Fprintf(os.Stderr, "✅ Applied %d file(s) from the scratch workspace\n", len(changes))
+	return nil
+}
+
+// confirmApply reports whether to apply reviewed changes: with --yes, or
+// when the user answers yes on the terminal.
+func confirmApply(prompt string) bool {
+	if runAutoApprove {
+		return true
+	}
+	if runNoExec || !isTerminal(os.Stdin) {
+		return false
+	}
+	fmt.Fprintf(os.Stderr, "%s [y/N]: ", prompt)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
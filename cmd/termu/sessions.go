@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/niradler/termu/internal/config"
+	"github.com/niradler/termu/internal/session"
+	"github.com/spf13/cobra"
+)
+
+var sessionsCmd = &cobra.Command{
+	Use:   "sessions",
+	Short: "Manage saved chat sessions",
+	Long:  `List, show and delete the chat sessions saved for the current directory`,
+}
+
+var sessionsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List saved sessions",
+	Args:  cobra.NoArgs,
+	RunE:  listSessions,
+}
+
+var sessionsShowCmd = &cobra.Command{
+	Use:   "show <id>",
+	Short: "Print a session transcript",
+	Args:  cobra.ExactArgs(1),
+	RunE:  showSession,
+}
+
+var sessionsDeleteCmd = &cobra.Command{
+	Use:   "delete <id>",
+	Short: "Delete a saved session",
+	Args:  cobra.ExactArgs(1),
+	RunE:  deleteSession,
+}
+
+func init() {
+	sessionsCmd.AddCommand(sessionsListCmd)
+	sessionsCmd.AddCommand(sessionsShowCmd)
+	sessionsCmd.AddCommand(sessionsDeleteCmd)
+}
+
+func sessionStore() (*session.Store, error) {
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	return session.NewStore(cfg.Workdir), nil
+}
+
+func listSessions(cmd *cobra.Command, args []string) error {
+	store, err := sessionStore()
+	if err != nil {
+		return err
+	}
+
+	sessions, err := store.List()
+	if err != nil {
+		return err
+	}
+	if len(sessions) == 0 {
+		fmt.Println("No saved sessions for this directory.")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tUPDATED\tMESSAGES\tMODEL\tTITLE")
+	for _, sess := range sessions {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n",
+			sess.ID,
+			sess.UpdatedAt.Local().Format("2006-01-02 15:04"),
+			len(sess.Transcript),
+			sess.Model,
+			sess.Title,
+		)
+	}
+	return w.Flush()
+}
+
+func showSession(cmd *cobra.Command, args []string) error {
+	store, err := sessionStore()
+	if err != nil {
+		return err
+	}
+
+	sess, err := store.Load(args[0])
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Session %s — %s\n", sess.ID, sess.Title)
+	fmt.Printf("Model: %s • Updated: %s\n\n", sess.Model, sess.UpdatedAt.Local().Format("2006-01-02 15:04"))
+	for _, entry := range sess.Transcript {
+		fmt.Printf("[%s]\n%s\n\n", entry.Role, entry.Content)
+	}
+	return nil
+}
+
+func deleteSession(cmd *cobra.Command, args []string) error {
+	store, err := sessionStore()
+	if err != nil {
+		return err
+	}
+
+	if err := store.Delete(args[0]); err != nil {
+		return err
+	}
+	fmt.Printf("Deleted session %s\n", args[0])
+	return nil
+}
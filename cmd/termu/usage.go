@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/niradler/termu/internal/config"
+	"github.com/niradler/termu/internal/session"
+	"github.com/niradler/termu/internal/usage"
+	"github.com/spf13/cobra"
+)
+
+var (
+	usageAll      bool
+	usageSessions bool
+	usageSince    time.Duration
+)
+
+var usageCmd = &cobra.Command{
+	Use:   "usage",
+	Short: "Report token usage and cost of saved sessions",
+	Long: `Adds up the tokens used by the saved chat sessions of the current directory,
+by model. Costs are computed with the prices in the pricing section of the
+config, in dollars per million tokens:
+
+  pricing:
+    anthropic/claude-sonnet-4-5: {input: 3, output: 15}
+
+Counts marked ~ are estimated because the provider, such as Ollama, does not
+report usage.`,
+	Args: cobra.NoArgs,
+	RunE: showUsage,
+}
+
+func init() {
+	usageCmd.Flags().BoolVar(&usageAll, "all", false, "include the sessions of every directory")
+	usageCmd.Flags().BoolVar(&usageSessions, "sessions", false, "list the usage of each session")
+	usageCmd.Flags().DurationVar(&usageSince, "since", 0, "only count sessions updated this long ago, e.g. 168h")
+}
+
+func showUsage(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	var sessions []*session.Session
+	if usageAll {
+		sessions, err = session.ListAll()
+	} else {
+		sessions, err = session.NewStore(cfg.Workdir).List()
+	}
+	if err != nil {
+		return err
+	}
+
+	tally := usage.Tally{}
+	counted := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if usageSessions {
+		fmt.Fprintln(w, "ID\tUPDATED\tREQUESTS\tINPUT\tOUTPUT\tCOST\tTITLE")
+	}
+	for _, sess := range sessions {
+		if usageSince > 0 && time.Since(sess.UpdatedAt) > usageSince {
+			continue
+		}
+		if len(sess.Usage) == 0 {
+			continue
+		}
+		counted++
+		tally.Merge(sess.Usage)
+		if usageSessions {
+			total := sess.Usage.Total()
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\t%s\n",
+				sess.ID,
+				sess.UpdatedAt.Local().Format("2006-01-02 15:04"),
+				total.Requests,
+				tokenCount(total.Input, total.Estimated),
+				tokenCount(total.Output, total.Estimated),
+				costText(sess.Usage.Cost(cfg)),
+				sess.Title,
+			)
+		}
+	}
+	if counted == 0 {
+		fmt.Println("No token usage recorded in saved sessions.")
+		return nil
+	}
+	if usageSessions {
+		fmt.Fprintln(w)
+	}
+
+	fmt.Fprintln(w, "MODEL\tREQUESTS\tINPUT\tOUTPUT\tCOST")
+	for _, model := range tally.Models() {
+		tokens := tally[model]
+		cost := "-"
+		if price, ok := cfg.PriceOf(model); ok {
+			cost = usage.FormatCost(tokens.Cost(price))
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\n",
+			model,
+			tokens.Requests,
+			tokenCount(tokens.Input, tokens.Estimated),
+			tokenCount(tokens.Output, tokens.Estimated),
+			cost,
+		)
+	}
+	total := tally.Total()
+	fmt.Fprintf(w, "TOTAL (%d sessions)\t%d\t%s\t%s\t%s\n",
+		counted,
+		total.Requests,
+		tokenCount(total.Input, total.Estimated),
+		tokenCount(total.Output, total.Estimated),
+		costText(tally.Cost(cfg)),
+	)
+	return w.Flush()
+}
+
+// tokenCount renders a token count, marked ~ when it was estimated.
+func tokenCount(n int, estimated bool) string {
+	if estimated {
+		return fmt.Sprintf("~%d", n)
+	}
+	return fmt.Sprint(n)
+}
+
+// costText renders a cost, with + when some models had no price and "-"
+// when none did.
+func costText(cost float64, priced bool) string {
+	switch {
+	case priced:
+		return usage.FormatCost(cost)
+	case cost > 0:
+		return usage.FormatCost(cost) + "+"
+	}
+	return "-"
+}
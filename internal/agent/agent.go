@@ -3,129 +3,411 @@ package agent
 import (
 	"context"
 	"fmt"
+	"strings"
+	"sync"
 
 	"github.com/firebase/genkit/go/ai"
 	"github.com/firebase/genkit/go/genkit"
-	"github.com/firebase/genkit/go/plugins/compat_oai"
-	"github.com/firebase/genkit/go/plugins/ollama"
+	"github.com/niradler/termu/internal/audit"
 	"github.com/niradler/termu/internal/config"
+	"github.com/niradler/termu/internal/security"
+	"github.com/niradler/termu/internal/shell"
 	"github.com/niradler/termu/internal/tools"
-	"github.com/openai/openai-go/option"
+	"github.com/niradler/termu/internal/usage"
 )
 
 type Agent struct {
-	genkit *genkit.Genkit
-	model  ai.Model
-	tools  []ai.Tool
+	cfg  *config.Config
+	opts Options
+
+	genkit    *genkit.Genkit
+	model     ai.Model
+	tools     []ai.Tool
+	shellTool ai.Tool
+	maxTurns  int
+
+	// contextSize and compactThreshold decide when the conversation is
+	// compacted; see compact.go.
+	contextSize      int
+	compactThreshold float64
+
+	// interrupted holds a generation paused on command approval, and
+	// decisions collects the restart parts for its interrupts until every
+	// one of them has been answered.
+	interrupted *ai.ModelResponse
+	decisions   []*ai.Part
+
+	conversation *Conversation
+
+	// systemPrompt is rebuilt from the environment at the start of every
+	// turn; see environment.go. It is guarded by stateMu.
+	systemPrompt string
+
+	// redactor masks secrets in tool output; nil when redaction is off.
+	redactor *tools.Redactor
+
+	// audit records prompts, model and tool calls and decisions; see
+	// audit.go.
+	audit    *audit.Logger
+	provider string
+
+	// stream receives incremental events for the generation in progress.
+	stream StreamFunc
+
+	// fallback is how far down the fallback list the generation in progress
+	// has moved, 0 while it uses the model itself; fallbacks caches the
+	// fallback models connected so far and answeredBy names the model that
+	// last answered. See retry.go.
+	fallback   int
+	fallbacks  map[string]*fallbackModel
+	answeredBy string
+
+	// usage tallies the tokens of every model request in the session and
+	// turnUsage those of the turn in progress; contextUsed is the input of
+	// the latest request, 0 when unknown. See usage.go. stateMu guards them
+	// and systemPrompt: the UI reads them while a generation updates them.
+	stateMu     sync.Mutex
+	usage       usage.Tally
+	turnUsage   usage.Tally
+	contextUsed int
+}
+
+// Options wires the collaborators the agent's tools depend on.
+type Options struct {
+	Validator *security.Validator
+	Executor  *shell.Executor
+	// Files backs the filesystem tools; nil means reading and writing the
+	// disk directly.
+	Files tools.FileStore
+	// Audit receives the agent's actions; nil disables the audit log.
+	Audit *audit.Logger
 }
 
 type Response struct {
-	Text    string
-	Command string
+	Text      string
+	Reasoning string
+	Command   string
+	Risk      string
+	Reason    string
+	// ToolCalls are the tools executed since the previous response.
+	ToolCalls []tools.ToolCall
+	// Compaction is set when the conversation was compacted before this
+	// turn to stay within the model's context window.
+	Compaction *Compaction
+	// Model is the model that answered, as provider/name; it differs from
+	// ModelName when a fallback model stepped in.
+	Model string
+	// Usage is the tokens used by the turn so far, by model.
+	Usage usage.Tally
 }
 
-func New(ctx context.Context, cfg *config.Config) (*Agent, error) {
-	var g *genkit.Genkit
-	var model ai.Model
+func New(ctx context.Context, cfg *config.Config, opts Options) (*Agent, error) {
+	if opts.Files == nil {
+		opts.Files = tools.DiskStore{}
+	}
 
-	switch cfg.Model.Provider {
-	case "ollama":
-		plugin := &ollama.Ollama{
-			ServerAddress: cfg.Model.Server,
-			Timeout:       cfg.Model.Timeout,
+	var redactor *tools.Redactor
+	if cfg.Security.RedactSecrets {
+		secrets := opts.Executor.WithheldSecrets()
+		if cfg.Model.APIKey != "" {
+			secrets = append(secrets, cfg.Model.APIKey)
 		}
-		g = genkit.Init(ctx, genkit.WithPlugins(plugin))
-		model = plugin.DefineModel(g,
-			ollama.ModelDefinition{
-				Name: cfg.Model.Name,
-				Type: "chat",
-			},
-			&ai.ModelOptions{
-				Label: "Ollama - " + cfg.Model.Name,
-				Supports: &ai.ModelSupports{
-					Multiturn:  true,
-					SystemRole: true,
-					Tools:      true,
-					Media:      false,
-				},
-			},
-		)
-
-	case "openai":
-		opts := []option.RequestOption{option.WithAPIKey(cfg.Model.APIKey)}
-		if cfg.Model.BaseURL != "" {
-			opts = append(opts, option.WithBaseURL(cfg.Model.BaseURL))
+		for _, profile := range cfg.Models {
+			if profile.APIKey != "" {
+				secrets = append(secrets, profile.APIKey)
+			}
 		}
+		var err error
+		redactor, err = tools.NewRedactor(cfg.Security.RedactPatterns, secrets)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-		plugin := &compat_oai.OpenAICompatible{
-			Opts:     opts,
-			Provider: "openai",
-			APIKey:   cfg.Model.APIKey,
-			BaseURL:  cfg.Model.BaseURL,
+	a := &Agent{
+		cfg:          cfg,
+		opts:         opts,
+		maxTurns:     cfg.Security.MaxToolIterations,
+		conversation: NewConversation(nil),
+		usage:        usage.Tally{},
+		turnUsage:    usage.Tally{},
+		redactor:     redactor,
+		audit:        opts.Audit,
+	}
+	if err := a.connect(ctx, cfg.Model); err != nil {
+		return nil, err
+	}
+	a.refreshSystemPrompt()
+	return a, nil
+}
+
+// SetModel switches to another model. The conversation carries over and
+// continues with the new model from the next turn; it cannot switch while
+// a command awaits approval.
+func (a *Agent) SetModel(ctx context.Context, model config.ModelConfig) error {
+	if a.interrupted != nil {
+		return fmt.Errorf("cannot switch models while a command is awaiting approval")
+	}
+	return a.connect(ctx, model)
+}
+
+// ModelName returns the model in use as provider/name.
+func (a *Agent) ModelName() string {
+	return a.provider + "/" + strings.TrimPrefix(a.model.Name(), a.provider+"/")
+}
+
+// connect sets up a Genkit instance for model with the agent's tools. Each
+// model gets an instance of its own because provider plugins can only be
+// initialized once per instance.
+func (a *Agent) connect(ctx context.Context, cfg config.ModelConfig) error {
+	provider, err := newProvider(cfg)
+	if err != nil {
+		return err
+	}
+	g := genkit.Init(ctx, genkit.WithPlugins(provider.Plugins()...))
+	model, err := provider.DefineModel(g)
+	if err != nil {
+		return err
+	}
+
+	opts, workdir := a.opts, a.cfg.Workdir
+	fsTools := tools.DefineFilesystemTools(g, func(tool, path string) (string, error) {
+		resolved, err := opts.Validator.ResolvePath(path, workdir)
+		if err != nil {
+			return "", err
+		}
+		return resolved, opts.Validator.CheckFile(tool, resolved, workdir)
+	}, opts.Files)
+	shellTool := tools.DefineShellTool(g, opts.Executor, func(command string) tools.CommandCheck {
+		result := opts.Validator.Validate(command, workdir)
+		logValidation(opts.Audit, command, result)
+		reason := result.Reason
+		if result.Allowed && result.Command != "" && result.Command != command {
+			reason = fmt.Sprintf("%s: %s", result.Command, reason)
 		}
-		g = genkit.Init(ctx, genkit.WithPlugins(plugin))
-		model = plugin.DefineModel("openai", cfg.Model.Name, ai.ModelOptions{
-			Label: "OpenAI Compatible - " + cfg.Model.Name,
-			Supports: &ai.ModelSupports{
-				Multiturn:  true,
-				SystemRole: true,
-				Tools:      true,
-				Media:      false,
-			},
-		})
-
-	default:
-		return nil, fmt.Errorf("unsupported provider: %s", cfg.Model.Provider)
-	}
-
-	// Initialize tools once
-	fsTools := tools.DefineFilesystemTools(g, cfg.Workdir)
-	shellTool := tools.DefineShellTool(g, cfg.Workdir)
+		return tools.CommandCheck{
+			Allowed:       result.Allowed,
+			NeedsApproval: result.NeedsApproval,
+			Reason:        reason,
+			Risk:          result.RiskLevel.String(),
+			Timeout:       opts.Validator.CommandTimeout(command),
+		}
+	})
+	outputTool := tools.DefineCommandOutputTool(g, opts.Executor)
 	clipboardTools := tools.DefineClipboardTools(g)
-	allTools := append(fsTools, shellTool)
+	allTools := append(fsTools, shellTool, outputTool)
 	allTools = append(allTools, clipboardTools...)
 
-	return &Agent{
-		genkit: g,
-		model:  model,
-		tools:  allTools,
-	}, nil
+	contextSize := cfg.ContextSize
+	if contextSize <= 0 {
+		contextSize = defaultContextSize
+	}
+	compactThreshold := cfg.CompactThreshold
+	if compactThreshold <= 0 || compactThreshold > 1 {
+		compactThreshold = defaultCompactThreshold
+	}
+
+	a.genkit = g
+	a.model = model
+	a.tools = allTools
+	a.shellTool = shellTool
+	a.provider = cfg.Provider
+	a.contextSize = contextSize
+	a.compactThreshold = compactThreshold
+	return nil
+}
+
+// refreshSystemPrompt rebuilds the system prompt, picking up a change of
+// branch, newly installed tools or edited instruction files.
+func (a *Agent) refreshSystemPrompt() {
+	prompt := BuildSystemPrompt(LoadEnvironment(a.cfg.Workdir, a.cfg.Tools.PreferModern))
+	a.stateMu.Lock()
+	defer a.stateMu.Unlock()
+	a.systemPrompt = prompt
+}
+
+// systemMessage returns the current system prompt as a message.
+func (a *Agent) systemMessage() *ai.Message {
+	a.stateMu.Lock()
+	defer a.stateMu.Unlock()
+	return ai.NewSystemTextMessage(a.systemPrompt)
+}
+
+// History returns the conversation so far, without the system prompt.
+func (a *Agent) History() []*ai.Message {
+	return a.conversation.Messages()
 }
 
-func (a *Agent) Generate(ctx context.Context, userInput string, history []ai.Message) (*Response, error) {
-	messages := []*ai.Message{
-		{
-			Role:    ai.RoleSystem,
-			Content: []*ai.Part{ai.NewTextPart(GetSystemPrompt())},
-		},
+// SetHistory replaces the conversation, for example with a resumed session.
+func (a *Agent) SetHistory(messages []*ai.Message) {
+	a.conversation.Replace(withoutSystem(messages))
+	a.resetContextUsed()
+}
+
+// Generate sends the user's message with the conversation so far. The user
+// turn is recorded right away and dropped again if the turn fails, so a
+// retry does not send it twice; the model's reply, including any tool
+// requests and responses, is recorded once the turn completes. When the
+// conversation nears the context window it is compacted first.
+func (a *Agent) Generate(ctx context.Context, userInput string) (*Response, error) {
+	a.interrupted = nil
+	a.decisions = nil
+	a.stateMu.Lock()
+	a.turnUsage = usage.Tally{}
+	a.stateMu.Unlock()
+	a.refreshSystemPrompt()
+
+	input := ai.NewUserTextMessage(userInput)
+	compaction, err := a.compactIfNeeded(ctx, EstimateTokens([]*ai.Message{input}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to compact conversation: %w", err)
 	}
 
-	for i := range history {
-		messages = append(messages, &history[i])
+	history := a.conversation.Messages()
+	a.conversation.Append(input)
+	a.audit.Log(audit.Event{Type: audit.EventPrompt, Prompt: userInput})
+
+	messages := append([]*ai.Message{a.systemMessage()}, a.conversation.Messages()...)
+	resp, err := a.generate(ctx, ai.WithMessages(messages...))
+	if err != nil {
+		a.conversation.Replace(history)
+		return nil, err
 	}
+	resp.Compaction = compaction
+	return resp, nil
+}
 
-	messages = append(messages, &ai.Message{
-		Role:    ai.RoleUser,
-		Content: []*ai.Part{ai.NewTextPart(userInput)},
-	})
+// Decision is the user's answer to a command awaiting approval.
+type Decision struct {
+	Approved bool
+	// Command replaces the proposed command when the user edited it
+	// before approving. It is validated again before it runs.
+	Command string
+	// Reason is the user's explanation for a rejection, passed on to the
+	// model.
+	Reason string
+}
+
+// Resume answers the command approval the last response is waiting on.
+// When the paused generation has further commands awaiting approval the
+// next one is returned without calling the model; once every command has
+// been decided the generation continues with the user's decisions.
+func (a *Agent) Resume(ctx context.Context, decision Decision) (*Response, error) {
+	pending := a.pendingInterrupts()
+	if len(pending) == 0 {
+		return nil, fmt.Errorf("no command is awaiting approval")
+	}
+
+	a.logDecision(pending[0], decision)
+
+	resumed := map[string]any{tools.ResumeApproved: decision.Approved}
+	if decision.Command != "" {
+		resumed[tools.ResumeCommand] = decision.Command
+	}
+	if decision.Reason != "" {
+		resumed[tools.ResumeReason] = decision.Reason
+	}
+	a.decisions = append(a.decisions, a.shellTool.Restart(pending[0], &ai.RestartOptions{
+		ResumedMetadata: resumed,
+	}))
+
+	if len(pending) > 1 {
+		return approvalResponse(a.interrupted, pending[1]), nil
+	}
+
+	history := a.interrupted.History()
+	decisions := a.decisions
+	a.interrupted = nil
+	a.decisions = nil
+
+	return a.generate(ctx,
+		ai.WithMessages(history...),
+		ai.WithToolRestarts(decisions...),
+	)
+}
 
+func (a *Agent) generate(ctx context.Context, opts ...ai.GenerateOption) (*Response, error) {
 	toolRefs := make([]ai.ToolRef, len(a.tools))
 	for i, t := range a.tools {
 		toolRefs[i] = t
 	}
 
-	resp, err := genkit.Generate(ctx, a.genkit,
+	opts = append(opts,
 		ai.WithModel(a.model),
-		ai.WithMessages(messages...),
 		ai.WithTools(toolRefs...),
+		ai.WithMiddleware(a.retryModelCalls, a.logModelCalls),
 	)
+	if a.maxTurns > 0 {
+		opts = append(opts, ai.WithMaxTurns(a.maxTurns))
+	}
+	if a.stream != nil {
+		opts = append(opts, ai.WithStreaming(streamCallback(a.stream)))
+	}
+
+	var mu sync.Mutex
+	var calls []tools.ToolCall
+	ctx = tools.WithCallRecorder(ctx, func(call tools.ToolCall) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, call)
+		a.logToolCall(call)
+	})
+	if a.redactor != nil {
+		ctx = tools.WithRedactor(ctx, a.redactor)
+	}
 
+	a.fallback, a.answeredBy = 0, ""
+	resp, err := genkit.Generate(ctx, a.genkit, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate with tools: %w", err)
 	}
 
+	if resp.FinishReason == ai.FinishReasonInterrupted {
+		a.interrupted = resp
+		if pending := a.pendingInterrupts(); len(pending) > 0 {
+			approval := approvalResponse(resp, pending[0])
+			approval.ToolCalls = calls
+			approval.Model = a.answeredBy
+			approval.Usage = a.turnTally()
+			return approval, nil
+		}
+		a.interrupted = nil
+	}
+
+	a.conversation.Replace(withoutSystem(resp.History()))
+
 	return &Response{
-		Text:    resp.Text(),
-		Command: "",
+		Text:      resp.Text(),
+		Reasoning: resp.Reasoning(),
+		ToolCalls: calls,
+		Model:     a.answeredBy,
+		Usage:     a.turnTally(),
 	}, nil
 }
+
+// pendingInterrupts returns the interrupted tool requests of the paused
+// generation that have not been decided yet.
+func (a *Agent) pendingInterrupts() []*ai.Part {
+	if a.interrupted == nil {
+		return nil
+	}
+	interrupts := a.interrupted.Interrupts()
+	if len(a.decisions) >= len(interrupts) {
+		return nil
+	}
+	return interrupts[len(a.decisions):]
+}
+
+func approvalResponse(resp *ai.ModelResponse, part *ai.Part) *Response {
+	meta, _ := part.Metadata["interrupt"].(map[string]any)
+	command, _ := meta[tools.InterruptCommand].(string)
+	risk, _ := meta[tools.InterruptRisk].(string)
+	reason, _ := meta[tools.InterruptReason].(string)
+
+	return &Response{
+		Text:    resp.Text(),
+		Command: command,
+		Risk:    risk,
+		Reason:  reason,
+	}
+}
@@ -0,0 +1,420 @@
+package agent
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core/api"
+	"github.com/firebase/genkit/go/genkit"
+	"github.com/niradler/termu/internal/config"
+)
+
+const (
+	anthropicBaseURL   = "https://api.anthropic.com"
+	anthropicVersion   = "2023-06-01"
+	anthropicMaxTokens = 8192
+)
+
+func init() {
+	RegisterProvider("anthropic", newAnthropicProvider)
+}
+
+// anthropicProvider calls the Anthropic Messages API, with its native tool
+// use. The key comes from model.api_key or ANTHROPIC_API_KEY.
+type anthropicProvider struct {
+	cfg config.ModelConfig
+}
+
+func newAnthropicProvider(cfg config.ModelConfig) (Provider, error) {
+	if cfg.APIKey == "" {
+		cfg.APIKey = os.Getenv("ANTHROPIC_API_KEY")
+	}
+	if cfg.APIKey == "" {
+		return nil, fmt.Errorf("anthropic provider needs model.api_key or ANTHROPIC_API_KEY")
+	}
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = anthropicBaseURL
+	}
+	if cfg.MaxTokens <= 0 {
+		cfg.MaxTokens = anthropicMaxTokens
+	}
+	return &anthropicProvider{cfg: cfg}, nil
+}
+
+func (p *anthropicProvider) Plugins() []api.Plugin {
+	return nil
+}
+
+func (p *anthropicProvider) DefineModel(g *genkit.Genkit) (ai.Model, error) {
+	client := httpClient(p.cfg.Timeout)
+	url := strings.TrimSuffix(p.cfg.BaseURL, "/") + "/v1/messages"
+	headers := map[string]string{
+		"x-api-key":         p.cfg.APIKey,
+		"anthropic-version": anthropicVersion,
+	}
+
+	return genkit.DefineModel(g, "anthropic/"+p.cfg.Name, modelOptions("Anthropic - "+p.cfg.Name),
+		func(ctx context.Context, req *ai.ModelRequest, cb ai.ModelStreamCallback) (*ai.ModelResponse, error) {
+			body := p.request(req)
+			body.Stream = cb != nil
+
+			resp, err := postJSON(ctx, client, "anthropic", url, headers, body)
+			if err != nil {
+				return nil, err
+			}
+
+			var msg *anthropicMessage
+			if body.Stream {
+				msg, err = readAnthropicStream(ctx, resp.Body, cb)
+				resp.Body.Close()
+			} else {
+				msg = &anthropicMessage{}
+				err = decodeJSON(resp, "anthropic", msg)
+			}
+			if err != nil {
+				return nil, err
+			}
+			return msg.response(req), nil
+		},
+	), nil
+}
+
+type anthropicRequest struct {
+	Model         string            `json:"model"`
+	MaxTokens     int               `json:"max_tokens"`
+	System        string            `json:"system,omitempty"`
+	Messages      []anthropicTurn   `json:"messages"`
+	Tools         []anthropicTool   `json:"tools,omitempty"`
+	ToolChoice    map[string]string `json:"tool_choice,omitempty"`
+	Temperature   *float64          `json:"temperature,omitempty"`
+	TopP          *float64          `json:"top_p,omitempty"`
+	TopK          int               `json:"top_k,omitempty"`
+	StopSequences []string          `json:"stop_sequences,omitempty"`
+	Stream        bool              `json:"stream,omitempty"`
+}
+
+type anthropicTurn struct {
+	Role    string           `json:"role"`
+	Content []anthropicBlock `json:"content"`
+}
+
+// anthropicBlock is a content block of any type; only the fields of its
+// Type are set.
+type anthropicBlock struct {
+	Type      string `json:"type"`
+	Text      string `json:"text,omitempty"`
+	ID        string `json:"id,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Input     any    `json:"input,omitempty"`
+	ToolUseID string `json:"tool_use_id,omitempty"`
+	Content   string `json:"content,omitempty"`
+	Thinking  string `json:"thinking,omitempty"`
+	Signature string `json:"signature,omitempty"`
+}
+
+type anthropicTool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	InputSchema map[string]any `json:"input_schema"`
+}
+
+type anthropicUsage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+}
+
+type anthropicMessage struct {
+	Content    []anthropicBlock `json:"content"`
+	StopReason string           `json:"stop_reason"`
+	Usage      anthropicUsage   `json:"usage"`
+}
+
+// request translates a Genkit request into a Messages API request.
+func (p *anthropicProvider) request(req *ai.ModelRequest) *anthropicRequest {
+	config := generationConfig(req)
+	body := &anthropicRequest{
+		Model:         p.cfg.Name,
+		MaxTokens:     p.cfg.MaxTokens,
+		TopK:          config.TopK,
+		StopSequences: config.StopSequences,
+	}
+	if config.MaxOutputTokens > 0 {
+		body.MaxTokens = config.MaxOutputTokens
+	}
+	if config.Temperature != 0 {
+		body.Temperature = &config.Temperature
+	}
+	if config.TopP != 0 {
+		body.TopP = &config.TopP
+	}
+
+	for _, tool := range req.Tools {
+		schema := tool.InputSchema
+		if schema == nil {
+			schema = map[string]any{"type": "object", "properties": map[string]any{}}
+		}
+		body.Tools = append(body.Tools, anthropicTool{Name: tool.Name, Description: tool.Description, InputSchema: schema})
+	}
+	if len(body.Tools) > 0 {
+		switch req.ToolChoice {
+		case ai.ToolChoiceRequired:
+			body.ToolChoice = map[string]string{"type": "any"}
+		case ai.ToolChoiceNone:
+			body.ToolChoice = map[string]string{"type": "none"}
+		}
+	}
+
+	var system []string
+	ids := &toolIDs{}
+	for _, msg := range req.Messages {
+		if msg.Role == ai.RoleSystem {
+			system = append(system, msg.Text())
+			continue
+		}
+
+		role := "user"
+		if msg.Role == ai.RoleModel {
+			role = "assistant"
+		}
+		var blocks []anthropicBlock
+		for _, part := range msg.Content {
+			switch {
+			case part.IsToolRequest():
+				blocks = append(blocks, anthropicBlock{
+					Type:  "tool_use",
+					ID:    ids.request(part.ToolRequest.Ref, part.ToolRequest.Name),
+					Name:  part.ToolRequest.Name,
+					Input: toolInput(part.ToolRequest.Input),
+				})
+			case part.IsToolResponse():
+				blocks = append(blocks, anthropicBlock{
+					Type:      "tool_result",
+					ToolUseID: ids.response(part.ToolResponse.Ref, part.ToolResponse.Name),
+					Content:   toolOutputText(part.ToolResponse.Output),
+				})
+			case part.IsReasoning():
+				// Thinking is only sent back with the signature that
+				// proves it came from the model.
+				if signature := reasoningSignature(part); signature != "" {
+					blocks = append(blocks, anthropicBlock{Type: "thinking", Thinking: part.Text, Signature: signature})
+				}
+			case part.IsText():
+				if strings.TrimSpace(part.Text) != "" {
+					blocks = append(blocks, anthropicBlock{Type: "text", Text: part.Text})
+				}
+			}
+		}
+		if len(blocks) == 0 {
+			continue
+		}
+
+		// Turns alternate between the user and the assistant; tool
+		// results follow the user's message in the same turn.
+		if n := len(body.Messages); n > 0 && body.Messages[n-1].Role == role {
+			body.Messages[n-1].Content = append(body.Messages[n-1].Content, blocks...)
+			continue
+		}
+		body.Messages = append(body.Messages, anthropicTurn{Role: role, Content: blocks})
+	}
+	body.System = strings.Join(system, "\n\n")
+	return body
+}
+
+// response translates a Messages API response into a Genkit response.
+func (m *anthropicMessage) response(req *ai.ModelRequest) *ai.ModelResponse {
+	resp := &ai.ModelResponse{
+		Request: req,
+		Message: &ai.Message{Role: ai.RoleModel},
+		Usage: &ai.GenerationUsage{
+			InputTokens:         m.Usage.InputTokens + m.Usage.CacheCreationInputTokens + m.Usage.CacheReadInputTokens,
+			OutputTokens:        m.Usage.OutputTokens,
+			CachedContentTokens: m.Usage.CacheReadInputTokens,
+		},
+	}
+	resp.Usage.TotalTokens = resp.Usage.InputTokens + resp.Usage.OutputTokens
+
+	for _, block := range m.Content {
+		if part := block.part(); part != nil {
+			resp.Message.Content = append(resp.Message.Content, part)
+		}
+	}
+
+	switch m.StopReason {
+	case "end_turn", "stop_sequence", "tool_use", "pause_turn":
+		resp.FinishReason = ai.FinishReasonStop
+	case "max_tokens":
+		resp.FinishReason = ai.FinishReasonLength
+	case "refusal":
+		resp.FinishReason = ai.FinishReasonBlocked
+	default:
+		resp.FinishReason = ai.FinishReasonUnknown
+	}
+	resp.FinishMessage = m.StopReason
+	return resp
+}
+
+// part translates a response content block into a Genkit part.
+func (b anthropicBlock) part() *ai.Part {
+	switch b.Type {
+	case "text":
+		return ai.NewTextPart(b.Text)
+	case "tool_use":
+		return ai.NewToolRequestPart(&ai.ToolRequest{Ref: b.ID, Name: b.Name, Input: b.Input})
+	case "thinking":
+		return ai.NewReasoningPart(b.Thinking, []byte(b.Signature))
+	}
+	return nil
+}
+
+// readAnthropicStream assembles a streamed response, passing text,
+// thinking and each completed tool call to cb as they arrive.
+func readAnthropicStream(ctx context.Context, body io.Reader, cb ai.ModelStreamCallback) (*anthropicMessage, error) {
+	msg := &anthropicMessage{}
+	var partialInput []strings.Builder
+
+	err := readSSE(body, func(_, data string) error {
+		var event struct {
+			Type         string           `json:"type"`
+			Index        int              `json:"index"`
+			Message      anthropicMessage `json:"message"`
+			ContentBlock anthropicBlock   `json:"content_block"`
+			Delta        struct {
+				Type        string `json:"type"`
+				Text        string `json:"text"`
+				Thinking    string `json:"thinking"`
+				Signature   string `json:"signature"`
+				PartialJSON string `json:"partial_json"`
+				StopReason  string `json:"stop_reason"`
+			} `json:"delta"`
+			Usage anthropicUsage `json:"usage"`
+			Error struct {
+				Type    string `json:"type"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return fmt.Errorf("failed to decode anthropic stream event: %w", err)
+		}
+
+		switch event.Type {
+		case "message_start":
+			msg.Usage = event.Message.Usage
+		case "content_block_start":
+			for len(msg.Content) <= event.Index {
+				msg.Content = append(msg.Content, anthropicBlock{})
+				partialInput = append(partialInput, strings.Builder{})
+			}
+			msg.Content[event.Index] = event.ContentBlock
+		case "content_block_delta":
+			if event.Index >= len(msg.Content) {
+				return nil
+			}
+			block := &msg.Content[event.Index]
+			switch event.Delta.Type {
+			case "text_delta":
+				block.Text += event.Delta.Text
+				return emit(ctx, cb, ai.NewTextPart(event.Delta.Text))
+			case "thinking_delta":
+				block.Thinking += event.Delta.Thinking
+				return emit(ctx, cb, ai.NewReasoningPart(event.Delta.Thinking, nil))
+			case "signature_delta":
+				block.Signature += event.Delta.Signature
+			case "input_json_delta":
+				partialInput[event.Index].WriteString(event.Delta.PartialJSON)
+			}
+		case "content_block_stop":
+			if event.Index >= len(msg.Content) || msg.Content[event.Index].Type != "tool_use" {
+				return nil
+			}
+			block := &msg.Content[event.Index]
+			if raw := partialInput[event.Index].String(); raw != "" {
+				var input any
+				if err := json.Unmarshal([]byte(raw), &input); err != nil {
+					return fmt.Errorf("failed to decode arguments of tool call %s: %w", block.Name, err)
+				}
+				block.Input = input
+			}
+			return emit(ctx, cb, block.part())
+		case "message_delta":
+			msg.StopReason = event.Delta.StopReason
+			msg.Usage.OutputTokens = event.Usage.OutputTokens
+		case "error":
+			return &apiError{Provider: "anthropic", StatusCode: anthropicStreamStatus(event.Error.Type), Message: event.Error.Message}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// anthropicStreamStatus maps an error reported inside a stream to the HTTP
+// status the same error has when reported up front.
+func anthropicStreamStatus(errorType string) int {
+	switch errorType {
+	case "overloaded_error":
+		return 529
+	case "rate_limit_error":
+		return 429
+	case "invalid_request_error":
+		return 400
+	default:
+		return 500
+	}
+}
+
+// reasoningSignature returns the signature of a reasoning part, which is
+// []byte when fresh from the model and a base64 string once the
+// conversation has been saved and loaded.
+func reasoningSignature(part *ai.Part) string {
+	switch signature := part.Metadata["signature"].(type) {
+	case []byte:
+		return string(signature)
+	case string:
+		if decoded, err := base64.StdEncoding.DecodeString(signature); err == nil {
+			return string(decoded)
+		}
+	}
+	return ""
+}
+
+// toolIDs pairs tool calls with their results for APIs that require an ID
+// on both, generating IDs for calls recorded without one, such as those
+// made by another provider earlier in the conversation.
+type toolIDs struct {
+	next    int
+	pending map[string][]string
+}
+
+func (t *toolIDs) request(ref, name string) string {
+	if ref != "" {
+		return ref
+	}
+	t.next++
+	id := fmt.Sprintf("toolu_termu_%d", t.next)
+	if t.pending == nil {
+		t.pending = map[string][]string{}
+	}
+	t.pending[name] = append(t.pending[name], id)
+	return id
+}
+
+func (t *toolIDs) response(ref, name string) string {
+	if ref != "" {
+		return ref
+	}
+	if ids := t.pending[name]; len(ids) > 0 {
+		t.pending[name] = ids[1:]
+		return ids[0]
+	}
+	return ""
+}
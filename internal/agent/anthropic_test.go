@@ -0,0 +1,269 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+func TestAnthropicRequest(t *testing.T) {
+	server := newStubServer(t, jsonReply(`{
+		"content": [{"type": "text", "text": "HI it is."}],
+		"stop_reason": "end_turn",
+		"usage": {"input_tokens": 10, "cache_read_input_tokens": 5, "output_tokens": 3}
+	}`))
+	_, model, _ := defineStubModel(t, "anthropic", "claude-test", server)
+
+	req := &ai.ModelRequest{
+		Messages: toolRoundTrip(),
+		Tools: []*ai.ToolDefinition{{
+			Name:        "echo",
+			Description: "Repeats text",
+			InputSchema: map[string]any{"type": "object", "properties": map[string]any{"text": map[string]any{"type": "string"}}},
+		}},
+		Config: &ai.GenerationCommonConfig{Temperature: 0.5, MaxOutputTokens: 100},
+	}
+	resp, err := model.Generate(context.Background(), req, nil)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+
+	received := server.received()
+	if len(received) != 1 {
+		t.Fatalf("got %d requests, want 1", len(received))
+	}
+	sent := received[0]
+	if sent.Path != "/v1/messages" {
+		t.Errorf("path = %q", sent.Path)
+	}
+	if sent.Header.Get("x-api-key") != "test-key" || sent.Header.Get("anthropic-version") != anthropicVersion {
+		t.Errorf("headers = %v", sent.Header)
+	}
+
+	checks := []struct {
+		keys []any
+		want any
+	}{
+		{[]any{"model"}, "claude-test"},
+		{[]any{"max_tokens"}, 100.0},
+		{[]any{"temperature"}, 0.5},
+		{[]any{"system"}, "Be brief."},
+		{[]any{"stream"}, nil},
+		{[]any{"tools", 0, "name"}, "echo"},
+		{[]any{"tools", 0, "input_schema", "type"}, "object"},
+		{[]any{"messages", 0, "role"}, "user"},
+		{[]any{"messages", 0, "content", 0, "text"}, "Shout hi"},
+		{[]any{"messages", 1, "role"}, "assistant"},
+		{[]any{"messages", 1, "content", 0, "type"}, "tool_use"},
+		{[]any{"messages", 1, "content", 0, "id"}, "call_1"},
+		{[]any{"messages", 1, "content", 0, "input", "text"}, "hi"},
+		{[]any{"messages", 2, "role"}, "user"},
+		{[]any{"messages", 2, "content", 0, "type"}, "tool_result"},
+		{[]any{"messages", 2, "content", 0, "tool_use_id"}, "call_1"},
+		{[]any{"messages", 2, "content", 0, "content"}, "HI"},
+	}
+	for _, check := range checks {
+		if got := path(t, sent.Body, check.keys...); got != check.want {
+			t.Errorf("request %v = %v, want %v", check.keys, got, check.want)
+		}
+	}
+
+	if resp.Text() != "HI it is." || resp.FinishReason != ai.FinishReasonStop {
+		t.Errorf("response = %q, %s", resp.Text(), resp.FinishReason)
+	}
+	if resp.Usage.InputTokens != 15 || resp.Usage.OutputTokens != 3 || resp.Usage.CachedContentTokens != 5 {
+		t.Errorf("usage = %+v", resp.Usage)
+	}
+}
+
+func TestAnthropicToolIDsForOtherProviders(t *testing.T) {
+	p := &anthropicProvider{}
+	req := &ai.ModelRequest{Messages: []*ai.Message{
+		ai.NewUserTextMessage("Shout hi"),
+		ai.NewModelMessage(ai.NewToolRequestPart(&ai.ToolRequest{Name: "echo", Input: map[string]any{"text": "hi"}})),
+		ai.NewMessage(ai.RoleTool, nil, ai.NewToolResponsePart(&ai.ToolResponse{Name: "echo", Output: "HI"})),
+	}}
+	body := p.request(req)
+	call := body.Messages[1].Content[0]
+	result := body.Messages[2].Content[0]
+	if call.ID == "" || call.ID != result.ToolUseID {
+		t.Errorf("tool_use id %q, tool_result id %q; want the same generated id", call.ID, result.ToolUseID)
+	}
+}
+
+func TestAnthropicToolRoundTrip(t *testing.T) {
+	server := newStubServer(t,
+		jsonReply(`{
+			"content": [
+				{"type": "text", "text": "Calling echo."},
+				{"type": "tool_use", "id": "toolu_1", "name": "echo", "input": {"text": "hi"}}
+			],
+			"stop_reason": "tool_use",
+			"usage": {"input_tokens": 20, "output_tokens": 8}
+		}`),
+		jsonReply(`{
+			"content": [{"type": "text", "text": "It said HI."}],
+			"stop_reason": "end_turn",
+			"usage": {"input_tokens": 40, "output_tokens": 4}
+		}`),
+	)
+	g, model, echo := defineStubModel(t, "anthropic", "claude-test", server)
+
+	resp, err := genkit.Generate(context.Background(), g,
+		ai.WithModel(model), ai.WithTools(echo), ai.WithPrompt("Shout hi"))
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if resp.Text() != "It said HI." {
+		t.Errorf("text = %q", resp.Text())
+	}
+
+	received := server.received()
+	if len(received) != 2 {
+		t.Fatalf("got %d requests, want 2", len(received))
+	}
+	second := received[1].Body
+	if got := path(t, second, "messages", 1, "content", 1, "id"); got != "toolu_1" {
+		t.Errorf("tool_use id sent back = %v", got)
+	}
+	if got := path(t, second, "messages", 2, "content", 0, "tool_use_id"); got != "toolu_1" {
+		t.Errorf("tool_result id = %v", got)
+	}
+	if got := path(t, second, "messages", 2, "content", 0, "content"); got != "HI" {
+		t.Errorf("tool_result content = %v", got)
+	}
+}
+
+func TestAnthropicStream(t *testing.T) {
+	server := newStubServer(t, sseReply(`event: message_start
+data: {"type": "message_start", "message": {"usage": {"input_tokens": 12, "output_tokens": 1}}}
+
+event: content_block_start
+data: {"type": "content_block_start", "index": 0, "content_block": {"type": "thinking", "thinking": ""}}
+
+event: content_block_delta
+data: {"type": "content_block_delta", "index": 0, "delta": {"type": "thinking_delta", "thinking": "User wants echo."}}
+
+event: content_block_delta
+data: {"type": "content_block_delta", "index": 0, "delta": {"type": "signature_delta", "signature": "sig"}}
+
+event: content_block_start
+data: {"type": "content_block_start", "index": 1, "content_block": {"type": "text", "text": ""}}
+
+event: ping
+data: {"type": "ping"}
+
+event: content_block_delta
+data: {"type": "content_block_delta", "index": 1, "delta": {"type": "text_delta", "text": "Call"}}
+
+event: content_block_delta
+data: {"type": "content_block_delta", "index": 1, "delta": {"type": "text_delta", "text": "ing echo."}}
+
+event: content_block_stop
+data: {"type": "content_block_stop", "index": 1}
+
+event: content_block_start
+data: {"type": "content_block_start", "index": 2, "content_block": {"type": "tool_use", "id": "toolu_1", "name": "echo", "input": {}}}
+
+event: content_block_delta
+data: {"type": "content_block_delta", "index": 2, "delta": {"type": "input_json_delta", "partial_json": "{\"text\": "}}
+
+event: content_block_delta
+data: {"type": "content_block_delta", "index": 2, "delta": {"type": "input_json_delta", "partial_json": "\"hi\"}"}}
+
+event: content_block_stop
+data: {"type": "content_block_stop", "index": 2}
+
+event: message_delta
+data: {"type": "message_delta", "delta": {"stop_reason": "tool_use"}, "usage": {"output_tokens": 30}}
+
+event: message_stop
+data: {"type": "message_stop"}
+
+`))
+	_, model, _ := defineStubModel(t, "anthropic", "claude-test", server)
+
+	var streamed, thought strings.Builder
+	var toolCalls int
+	cb := func(ctx context.Context, chunk *ai.ModelResponseChunk) error {
+		for _, part := range chunk.Content {
+			switch {
+			case part.IsToolRequest():
+				toolCalls++
+			case part.IsReasoning():
+				thought.WriteString(part.Text)
+			case part.IsText():
+				streamed.WriteString(part.Text)
+			}
+		}
+		return nil
+	}
+	resp, err := model.Generate(context.Background(), &ai.ModelRequest{Messages: []*ai.Message{ai.NewUserTextMessage("Shout hi")}}, cb)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+
+	if got := path(t, server.received()[0].Body, "stream"); got != true {
+		t.Errorf("stream = %v, want true", got)
+	}
+	if streamed.String() != "Calling echo." || thought.String() != "User wants echo." || toolCalls != 1 {
+		t.Errorf("streamed %q, thinking %q and %d tool calls", streamed.String(), thought.String(), toolCalls)
+	}
+	if resp.Text() != "Calling echo." || resp.Reasoning() != "User wants echo." {
+		t.Errorf("text %q, reasoning %q", resp.Text(), resp.Reasoning())
+	}
+	requests := resp.ToolRequests()
+	if len(requests) != 1 || requests[0].Ref != "toolu_1" || path(t, requests[0].Input, "text") != "hi" {
+		t.Errorf("tool requests = %+v", requests)
+	}
+	if got := reasoningSignature(resp.Message.Content[0]); got != "sig" {
+		t.Errorf("signature = %q", got)
+	}
+	if resp.Usage.InputTokens != 12 || resp.Usage.OutputTokens != 30 {
+		t.Errorf("usage = %+v", resp.Usage)
+	}
+}
+
+func TestAnthropicStreamError(t *testing.T) {
+	server := newStubServer(t, sseReply(`event: message_start
+data: {"type": "message_start", "message": {"usage": {"input_tokens": 12}}}
+
+event: error
+data: {"type": "error", "error": {"type": "overloaded_error", "message": "Overloaded"}}
+
+`))
+	_, model, _ := defineStubModel(t, "anthropic", "claude-test", server)
+
+	var b strings.Builder
+	_, err := model.Generate(context.Background(), &ai.ModelRequest{Messages: []*ai.Message{ai.NewUserTextMessage("hi")}}, collectText(&b))
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 529 || apiErr.Message != "Overloaded" {
+		t.Fatalf("err = %v, want an overloaded *apiError", err)
+	}
+	if retry, _ := retryable(err); !retry {
+		t.Errorf("an overloaded stream is not retried")
+	}
+}
+
+func TestAnthropicHTTPError(t *testing.T) {
+	server := newStubServer(t, errorReply(http.StatusTooManyRequests,
+		map[string]string{"Retry-After": "2", "Content-Type": "application/json"},
+		`{"type": "error", "error": {"type": "rate_limit_error", "message": "Number of requests has exceeded your rate limit"}}`))
+	_, model, _ := defineStubModel(t, "anthropic", "claude-test", server)
+
+	_, err := model.Generate(context.Background(), &ai.ModelRequest{Messages: []*ai.Message{ai.NewUserTextMessage("hi")}}, nil)
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("err = %v, want an *apiError", err)
+	}
+	if apiErr.StatusCode != http.StatusTooManyRequests || apiErr.RetryAfter != 2*time.Second ||
+		apiErr.Message != "Number of requests has exceeded your rate limit" {
+		t.Errorf("err = %+v", apiErr)
+	}
+}
@@ -0,0 +1,109 @@
+package agent
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/niradler/termu/internal/audit"
+	"github.com/niradler/termu/internal/security"
+	"github.com/niradler/termu/internal/tools"
+)
+
+// logModelCalls is model middleware recording every request to the model,
+// including each turn of a generation with tools, with its latency and
+// token usage.
+func (a *Agent) logModelCalls(next ai.ModelFunc) ai.ModelFunc {
+	return a.logModelCallsTo(a.provider, a.model, next)
+}
+
+// logModelCallsTo records the requests next makes to model, which is
+// served by provider.
+func (a *Agent) logModelCallsTo(provider string, model ai.Model, next ai.ModelFunc) ai.ModelFunc {
+	return func(ctx context.Context, req *ai.ModelRequest, cb ai.ModelStreamCallback) (*ai.ModelResponse, error) {
+		start := time.Now()
+		resp, err := next(ctx, req, cb)
+
+		event := audit.Event{
+			Type:      audit.EventModelCall,
+			Provider:  provider,
+			Model:     strings.TrimPrefix(model.Name(), provider+"/"),
+			LatencyMS: time.Since(start).Milliseconds(),
+		}
+		if resp != nil {
+			event.FinishReason = string(resp.FinishReason)
+			if resp.Usage != nil {
+				event.InputTokens = resp.Usage.InputTokens
+				event.OutputTokens = resp.Usage.OutputTokens
+			}
+			a.recordUsage(event.Provider+"/"+event.Model, req, resp)
+		}
+		if err != nil {
+			event.Error = err.Error()
+		}
+		a.audit.Log(event)
+		return resp, err
+	}
+}
+
+// logToolCall records a completed tool call and the files it modified.
+func (a *Agent) logToolCall(call tools.ToolCall) {
+	event := audit.Event{
+		Type:       audit.EventToolCall,
+		Tool:       call.Name,
+		Args:       call.Input,
+		DurationMS: call.Duration.Milliseconds(),
+		ExitCode:   call.ExitCode,
+		Redactions: call.Redactions,
+		Error:      call.Error,
+	}
+	if input, ok := call.Input.(tools.ExecuteCommandInput); ok {
+		event.Command = input.Command
+		event.Args = nil
+	}
+	if a.audit.Debug() {
+		event.Output = call.Output
+	}
+	a.audit.Log(event)
+
+	for _, path := range call.Files {
+		a.audit.Log(audit.Event{Type: audit.EventFileChange, Tool: call.Name, Path: path})
+	}
+}
+
+// logDecision records the user's decision on the command awaiting approval
+// in part.
+func (a *Agent) logDecision(part *ai.Part, decision Decision) {
+	approved := decision.Approved
+	event := audit.Event{
+		Type:     audit.EventApproval,
+		Command:  approvalResponse(a.interrupted, part).Command,
+		Approved: &approved,
+		Reason:   decision.Reason,
+	}
+	if decision.Command != "" && decision.Command != event.Command {
+		event.EditedCommand = decision.Command
+	}
+	if !approved {
+		event.Level = audit.LevelWarn
+	}
+	a.audit.Log(event)
+}
+
+// logValidation records the validator's decision on a command.
+func logValidation(logger *audit.Logger, command string, result *security.ValidationResult) {
+	allowed := result.Allowed
+	event := audit.Event{
+		Type:          audit.EventValidation,
+		Command:       command,
+		Allowed:       &allowed,
+		NeedsApproval: result.NeedsApproval,
+		Risk:          result.RiskLevel.String(),
+		Reason:        result.Reason,
+	}
+	if !allowed {
+		event.Level = audit.LevelWarn
+	}
+	logger.Log(event)
+}
@@ -0,0 +1,277 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+const (
+	defaultContextSize      = 8192
+	defaultCompactThreshold = 0.8
+
+	// autoKeepTurns and manualKeepTurns are the most recent user turns left
+	// untouched by automatic compaction and by /compact.
+	autoKeepTurns   = 2
+	manualKeepTurns = 1
+
+	// elideMinChars is the size above which an old tool output is replaced
+	// by a placeholder.
+	elideMinChars = 200
+
+	// summaryMessageChars bounds each message in the transcript handed to
+	// the summarizer.
+	summaryMessageChars = 2000
+
+	// summaryMetadataKey marks the user message carrying a summary.
+	summaryMetadataKey = "compacted"
+)
+
+const summaryPrompt = `You compress conversations between a user and termu, a terminal assistant.
+Summarize the transcript below so the assistant can continue the work without it.
+Keep: the user's goals and requests, decisions made, files read or changed and what was learned from them,
+commands run and their important results, errors, and anything still left to do.
+Drop: pleasantries, repeated content and raw tool output that is no longer needed.
+Reply with the summary only, as concise bullet points.`
+
+// Compaction reports how the conversation was shrunk.
+type Compaction struct {
+	// Before and After are the estimated tokens of the request.
+	Before int
+	After  int
+	// Summarized is the number of messages replaced by a summary.
+	Summarized int
+	// Elided is the number of old tool outputs replaced by a placeholder.
+	Elided int
+}
+
+// Changed reports whether compaction removed anything.
+func (c *Compaction) Changed() bool {
+	return c != nil && (c.Summarized > 0 || c.Elided > 0)
+}
+
+// Compact summarizes everything but the latest turn, regardless of how full
+// the context window is.
+func (a *Agent) Compact(ctx context.Context) (*Compaction, error) {
+	if a.interrupted != nil {
+		return nil, fmt.Errorf("cannot compact while a command is awaiting approval")
+	}
+	return a.compact(ctx, manualKeepTurns, 0, true)
+}
+
+// compactIfNeeded compacts the conversation when it, plus pending tokens
+// about to be added, exceeds the compaction threshold.
+func (a *Agent) compactIfNeeded(ctx context.Context, pending int) (*Compaction, error) {
+	return a.compact(ctx, autoKeepTurns, pending, false)
+}
+
+// compact first elides stale tool outputs before the last keepTurns user
+// turns and, if that is not enough or force is set, replaces those older
+// turns with a model-written summary.
+func (a *Agent) compact(ctx context.Context, keepTurns, pending int, force bool) (*Compaction, error) {
+	messages := a.conversation.Messages()
+	system := EstimateTokens([]*ai.Message{a.systemMessage()}) + pending
+	limit := int(float64(a.contextSize) * a.compactThreshold)
+
+	result := &Compaction{Before: system + EstimateTokens(messages)}
+	result.After = result.Before
+	if !force && result.Before <= limit {
+		return nil, nil
+	}
+
+	recent := recentTurnsStart(messages, keepTurns)
+	if recent == 0 {
+		return result, nil
+	}
+
+	older, elided := elideToolOutputs(messages[:recent])
+	result.Elided = elided
+	compacted := append(older, messages[recent:]...)
+
+	if force || system+EstimateTokens(compacted) > limit {
+		if a.stream != nil {
+			a.stream(StreamEvent{Kind: StreamCompact})
+		}
+		summary, err := a.summarize(ctx, messages[:recent])
+		if err != nil {
+			return nil, err
+		}
+		result.Summarized = recent
+		result.Elided = 0
+		compacted = append(summaryMessages(summary), messages[recent:]...)
+	}
+
+	a.conversation.Replace(compacted)
+	a.resetContextUsed()
+	result.After = system + EstimateTokens(compacted)
+	return result, nil
+}
+
+// summarize asks the model for a summary of messages, without tools.
+func (a *Agent) summarize(ctx context.Context, messages []*ai.Message) (string, error) {
+	transcript := renderTranscript(messages)
+	if budget := a.contextSize * 2; len(transcript) > budget {
+		transcript = transcript[len(transcript)-budget:]
+		if i := strings.Index(transcript, "\n\n"); i >= 0 {
+			transcript = transcript[i+2:]
+		}
+		transcript = "…\n\n" + transcript
+	}
+
+	a.fallback = 0
+	resp, err := genkit.Generate(ctx, a.genkit,
+		ai.WithModel(a.model),
+		ai.WithMiddleware(a.retryModelCalls, a.logModelCalls),
+		ai.WithMessages(
+			ai.NewSystemTextMessage(summaryPrompt),
+			ai.NewUserTextMessage(transcript),
+		),
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to summarize conversation: %w", err)
+	}
+
+	summary := strings.TrimSpace(StripThinking(resp.Text()))
+	if summary == "" {
+		return "", fmt.Errorf("failed to summarize conversation: empty summary")
+	}
+	return summary, nil
+}
+
+// summaryMessages returns the exchange that stands in for summarized turns.
+// The summary is sent as a user message answered by the model so roles
+// keep alternating for providers that require it.
+func summaryMessages(summary string) []*ai.Message {
+	return []*ai.Message{
+		ai.NewUserMessageWithMetadata(
+			map[string]any{summaryMetadataKey: true},
+			ai.NewTextPart("Summary of the earlier conversation:\n"+summary),
+		),
+		ai.NewModelTextMessage("Understood. I'll continue from this summary."),
+	}
+}
+
+// recentTurnsStart returns the index of the user message starting the last
+// keep turns, or 0 when the conversation has no more turns than that.
+func recentTurnsStart(messages []*ai.Message, keep int) int {
+	seen := 0
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role != ai.RoleUser {
+			continue
+		}
+		seen++
+		if seen == keep {
+			return i
+		}
+	}
+	return 0
+}
+
+// elideToolOutputs returns a copy of messages with large tool outputs
+// replaced by a placeholder, and how many were replaced. The originals are
+// left untouched.
+func elideToolOutputs(messages []*ai.Message) ([]*ai.Message, int) {
+	elided := 0
+	out := make([]*ai.Message, len(messages))
+	for i, msg := range messages {
+		out[i] = msg
+		for j, part := range msg.Content {
+			if !part.IsToolResponse() {
+				continue
+			}
+			size := len(jsonText(part.ToolResponse.Output))
+			if size <= elideMinChars {
+				continue
+			}
+
+			if out[i] == msg {
+				copied := *msg
+				copied.Content = append([]*ai.Part(nil), msg.Content...)
+				out[i] = &copied
+			}
+			response := *part.ToolResponse
+			response.Output = fmt.Sprintf("[output elided to save context: %d characters]", size)
+			elidedPart := *part
+			elidedPart.ToolResponse = &response
+			out[i].Content[j] = &elidedPart
+			elided++
+		}
+	}
+	return out, elided
+}
+
+// EstimateTokens roughly counts the tokens of messages at four characters
+// per token, which is close enough to decide when to compact.
+func EstimateTokens(messages []*ai.Message) int {
+	chars := 0
+	for _, msg := range messages {
+		chars += 16 // role and message framing
+		for _, part := range msg.Content {
+			switch {
+			case part.IsToolRequest():
+				chars += len(part.ToolRequest.Name) + len(jsonText(part.ToolRequest.Input))
+			case part.IsToolResponse():
+				chars += len(part.ToolResponse.Name) + len(jsonText(part.ToolResponse.Output))
+			default:
+				chars += len(part.Text)
+			}
+		}
+	}
+	return (chars + 3) / 4
+}
+
+// renderTranscript flattens messages into plain text for the summarizer.
+func renderTranscript(messages []*ai.Message) string {
+	var b strings.Builder
+	for _, msg := range messages {
+		for _, part := range msg.Content {
+			var line string
+			switch {
+			case part.IsToolRequest():
+				line = fmt.Sprintf("[tool call] %s %s", part.ToolRequest.Name, jsonText(part.ToolRequest.Input))
+			case part.IsToolResponse():
+				line = fmt.Sprintf("[tool result] %s: %s", part.ToolResponse.Name, jsonText(part.ToolResponse.Output))
+			case part.IsText():
+				if msg.Role == ai.RoleModel {
+					line = "assistant: " + StripThinking(part.Text)
+				} else {
+					line = string(msg.Role) + ": " + part.Text
+				}
+			default:
+				continue
+			}
+			line = Clip(line, summaryMessageChars)
+			b.WriteString(line)
+			b.WriteString("\n\n")
+		}
+	}
+	return b.String()
+}
+
+// Clip shortens s to at most n bytes without splitting a character,
+// marking the cut with an ellipsis.
+func Clip(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + "…"
+}
+
+func jsonText(v any) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
@@ -0,0 +1,53 @@
+package agent
+
+import (
+	"sync"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+// Conversation is the ordered message history of a chat, excluding the
+// system prompt: user turns, model replies, tool requests and tool responses.
+type Conversation struct {
+	mu       sync.Mutex
+	messages []*ai.Message
+}
+
+func NewConversation(messages []*ai.Message) *Conversation {
+	return &Conversation{messages: append([]*ai.Message(nil), messages...)}
+}
+
+// Messages returns a copy of the history.
+func (c *Conversation) Messages() []*ai.Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*ai.Message(nil), c.messages...)
+}
+
+func (c *Conversation) Append(messages ...*ai.Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.messages = append(c.messages, messages...)
+}
+
+// Replace swaps in a new history, such as the one genkit returns at the
+// end of a generation.
+func (c *Conversation) Replace(messages []*ai.Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.messages = append([]*ai.Message(nil), messages...)
+}
+
+func (c *Conversation) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.messages)
+}
+
+// withoutSystem drops leading system messages from a genkit history.
+func withoutSystem(messages []*ai.Message) []*ai.Message {
+	for len(messages) > 0 && messages[0].Role == ai.RoleSystem {
+		messages = messages[1:]
+	}
+	return messages
+}
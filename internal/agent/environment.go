@@ -0,0 +1,162 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/niradler/termu/internal/shell"
+	"github.com/niradler/termu/internal/tools"
+)
+
+// InstructionFiles are the names of the project instruction files read into
+// the system prompt, in order of preference within a directory.
+var InstructionFiles = []string{"TERMU.md", "AGENTS.md"}
+
+// maxInstructionBytes caps how much of each instruction file is included.
+const maxInstructionBytes = 32 << 10
+
+// Environment is what the system prompt tells the model about where it is
+// working.
+type Environment struct {
+	OS        string
+	Arch      string
+	Shell     string // Shell execute_command runs commands with
+	UserShell string // The user's login shell, from $SHELL
+	Workdir   string
+	GitRoot   string // "" outside a git repository
+	GitBranch string
+
+	// Installed and Missing split tools.ModernTools by whether they are
+	// on the PATH.
+	Installed []tools.Tool
+	Missing   []tools.Tool
+	// PreferModern asks the model to use the installed modern tools over
+	// their classic counterparts.
+	PreferModern bool
+
+	// Instructions are the user's and the project's instruction files,
+	// most general first.
+	Instructions []Instructions
+}
+
+// Instructions is the content of one instruction file.
+type Instructions struct {
+	Path    string
+	Content string
+}
+
+// LoadEnvironment takes a snapshot of the environment of workdir and reads
+// its instruction files: ~/.termu/TERMU.md, then a TERMU.md or AGENTS.md in
+// every directory from the repository root down to workdir.
+func LoadEnvironment(workdir string, preferModern bool) Environment {
+	env := Environment{
+		OS:           runtime.GOOS,
+		Arch:         runtime.GOARCH,
+		Shell:        shell.Name(),
+		UserShell:    os.Getenv("SHELL"),
+		Workdir:      workdir,
+		PreferModern: preferModern,
+	}
+	env.Installed, env.Missing = tools.NewInstaller().CheckInstalledTools()
+	env.GitRoot, env.GitBranch = gitBranch(workdir)
+
+	if home, err := os.UserHomeDir(); err == nil {
+		if inst, ok := readInstructions(filepath.Join(home, ".termu", "TERMU.md")); ok {
+			env.Instructions = append(env.Instructions, inst)
+		}
+	}
+
+	abs, err := filepath.Abs(workdir)
+	if err != nil {
+		abs = workdir
+	}
+	dirs := []string{abs}
+	if env.GitRoot != "" {
+		for dir := abs; dir != env.GitRoot; {
+			parent := filepath.Dir(dir)
+			if parent == dir {
+				break
+			}
+			dirs = append([]string{parent}, dirs...)
+			dir = parent
+		}
+	}
+	for _, dir := range dirs {
+		for _, name := range InstructionFiles {
+			if inst, ok := readInstructions(filepath.Join(dir, name)); ok {
+				env.Instructions = append(env.Instructions, inst)
+				break
+			}
+		}
+	}
+	return env
+}
+
+// readInstructions reads an instruction file, cut to maxInstructionBytes.
+func readInstructions(path string) (Instructions, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Instructions{}, false
+	}
+	content := strings.TrimSpace(string(data))
+	if content == "" {
+		return Instructions{}, false
+	}
+	if len(content) > maxInstructionBytes {
+		content = content[:maxInstructionBytes] + "\n\n[… truncated]"
+	}
+	return Instructions{Path: path, Content: content}, true
+}
+
+// gitBranch finds the git repository workdir is in and returns its root
+// and current branch, or the commit when HEAD is detached. It reads the
+// repository files rather than running git, which may not be installed.
+func gitBranch(workdir string) (root, branch string) {
+	dir, err := filepath.Abs(workdir)
+	if err != nil {
+		return "", ""
+	}
+	for {
+		gitPath := filepath.Join(dir, ".git")
+		if info, err := os.Stat(gitPath); err == nil {
+			gitDir := gitPath
+			if !info.IsDir() {
+				// A worktree or submodule: .git names the real directory.
+				data, err := os.ReadFile(gitPath)
+				if err != nil {
+					return dir, ""
+				}
+				gitDir = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(string(data)), "gitdir:"))
+				if !filepath.IsAbs(gitDir) {
+					gitDir = filepath.Join(dir, gitDir)
+				}
+			}
+			return dir, headBranch(gitDir)
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", ""
+		}
+		dir = parent
+	}
+}
+
+// headBranch reads the branch HEAD points to in gitDir.
+func headBranch(gitDir string) string {
+	data, err := os.ReadFile(filepath.Join(gitDir, "HEAD"))
+	if err != nil {
+		return ""
+	}
+	head := strings.TrimSpace(string(data))
+	if ref, ok := strings.CutPrefix(head, "ref: "); ok {
+		return strings.TrimPrefix(ref, "refs/heads/")
+	}
+	if len(head) > 12 {
+		head = head[:12]
+	}
+	return fmt.Sprintf("(detached at %s)", head)
+}
@@ -0,0 +1,483 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core/api"
+	"github.com/firebase/genkit/go/genkit"
+	"github.com/niradler/termu/internal/config"
+)
+
+const geminiBaseURL = "https://generativelanguage.googleapis.com"
+
+func init() {
+	RegisterProvider("gemini", newGeminiProvider)
+}
+
+// geminiProvider calls the Gemini API's generateContent, with its native
+// function calling. The key comes from model.api_key, GEMINI_API_KEY or
+// GOOGLE_API_KEY.
+type geminiProvider struct {
+	cfg config.ModelConfig
+}
+
+func newGeminiProvider(cfg config.ModelConfig) (Provider, error) {
+	for _, env := range []string{"GEMINI_API_KEY", "GOOGLE_API_KEY"} {
+		if cfg.APIKey == "" {
+			cfg.APIKey = os.Getenv(env)
+		}
+	}
+	if cfg.APIKey == "" {
+		return nil, fmt.Errorf("gemini provider needs model.api_key, GEMINI_API_KEY or GOOGLE_API_KEY")
+	}
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = geminiBaseURL
+	}
+	return &geminiProvider{cfg: cfg}, nil
+}
+
+func (p *geminiProvider) Plugins() []api.Plugin {
+	return nil
+}
+
+func (p *geminiProvider) DefineModel(g *genkit.Genkit) (ai.Model, error) {
+	client := httpClient(p.cfg.Timeout)
+	base := strings.TrimSuffix(p.cfg.BaseURL, "/") + "/v1beta/models/" + url.PathEscape(strings.TrimPrefix(p.cfg.Name, "models/"))
+	headers := map[string]string{"x-goog-api-key": p.cfg.APIKey}
+
+	return genkit.DefineModel(g, "gemini/"+p.cfg.Name, modelOptions("Gemini - "+p.cfg.Name),
+		func(ctx context.Context, req *ai.ModelRequest, cb ai.ModelStreamCallback) (*ai.ModelResponse, error) {
+			body := p.request(req)
+
+			if cb == nil {
+				resp, err := postJSON(ctx, client, "gemini", base+":generateContent", headers, body)
+				if err != nil {
+					return nil, err
+				}
+				var result geminiResponse
+				if err := decodeJSON(resp, "gemini", &result); err != nil {
+					return nil, err
+				}
+				return result.response(req)
+			}
+
+			resp, err := postJSON(ctx, client, "gemini", base+":streamGenerateContent?alt=sse", headers, body)
+			if err != nil {
+				return nil, err
+			}
+			defer resp.Body.Close()
+			result, err := readGeminiStream(ctx, resp.Body, cb)
+			if err != nil {
+				return nil, err
+			}
+			return result.response(req)
+		},
+	), nil
+}
+
+type geminiRequest struct {
+	Contents          []geminiContent   `json:"contents"`
+	SystemInstruction *geminiContent    `json:"systemInstruction,omitempty"`
+	Tools             []geminiTool      `json:"tools,omitempty"`
+	ToolConfig        *geminiToolConfig `json:"toolConfig,omitempty"`
+	GenerationConfig  map[string]any    `json:"generationConfig,omitempty"`
+}
+
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+// geminiPart is a part of any kind; only the field of its kind is set.
+// ThoughtSignature accompanies parts of thinking models and must be sent
+// back with them.
+type geminiPart struct {
+	Text             string              `json:"text,omitempty"`
+	Thought          bool                `json:"thought,omitempty"`
+	FunctionCall     *geminiFunctionCall `json:"functionCall,omitempty"`
+	FunctionResponse *geminiFunctionResp `json:"functionResponse,omitempty"`
+	ThoughtSignature string              `json:"thoughtSignature,omitempty"`
+}
+
+type geminiFunctionCall struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name"`
+	Args any    `json:"args,omitempty"`
+}
+
+type geminiFunctionResp struct {
+	ID       string         `json:"id,omitempty"`
+	Name     string         `json:"name"`
+	Response map[string]any `json:"response"`
+}
+
+type geminiTool struct {
+	FunctionDeclarations []geminiFunction `json:"functionDeclarations"`
+}
+
+type geminiFunction struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Parameters  map[string]any `json:"parameters,omitempty"`
+}
+
+type geminiToolConfig struct {
+	FunctionCallingConfig struct {
+		Mode string `json:"mode"`
+	} `json:"functionCallingConfig"`
+}
+
+type geminiResponse struct {
+	Candidates []struct {
+		Content      geminiContent `json:"content"`
+		FinishReason string        `json:"finishReason"`
+	} `json:"candidates"`
+	PromptFeedback struct {
+		BlockReason string `json:"blockReason"`
+	} `json:"promptFeedback"`
+	UsageMetadata struct {
+		PromptTokenCount        int `json:"promptTokenCount"`
+		CandidatesTokenCount    int `json:"candidatesTokenCount"`
+		ThoughtsTokenCount      int `json:"thoughtsTokenCount"`
+		CachedContentTokenCount int `json:"cachedContentTokenCount"`
+		TotalTokenCount         int `json:"totalTokenCount"`
+	} `json:"usageMetadata"`
+}
+
+// thoughtSignatureKey is the part metadata holding a Gemini thought
+// signature.
+const thoughtSignatureKey = "thoughtSignature"
+
+// request translates a Genkit request into a generateContent request.
+func (p *geminiProvider) request(req *ai.ModelRequest) *geminiRequest {
+	body := &geminiRequest{Contents: []geminiContent{}}
+
+	config := generationConfig(req)
+	generation := map[string]any{}
+	if config.MaxOutputTokens > 0 {
+		generation["maxOutputTokens"] = config.MaxOutputTokens
+	} else if p.cfg.MaxTokens > 0 {
+		generation["maxOutputTokens"] = p.cfg.MaxTokens
+	}
+	if config.Temperature != 0 {
+		generation["temperature"] = config.Temperature
+	}
+	if config.TopP != 0 {
+		generation["topP"] = config.TopP
+	}
+	if config.TopK != 0 {
+		generation["topK"] = config.TopK
+	}
+	if len(config.StopSequences) > 0 {
+		generation["stopSequences"] = config.StopSequences
+	}
+	if len(generation) > 0 {
+		body.GenerationConfig = generation
+	}
+
+	if len(req.Tools) > 0 {
+		tool := geminiTool{}
+		for _, def := range req.Tools {
+			tool.FunctionDeclarations = append(tool.FunctionDeclarations, geminiFunction{
+				Name:        def.Name,
+				Description: def.Description,
+				Parameters:  geminiParameters(def.InputSchema),
+			})
+		}
+		body.Tools = []geminiTool{tool}
+
+		mode := ""
+		switch req.ToolChoice {
+		case ai.ToolChoiceRequired:
+			mode = "ANY"
+		case ai.ToolChoiceNone:
+			mode = "NONE"
+		}
+		if mode != "" {
+			body.ToolConfig = &geminiToolConfig{}
+			body.ToolConfig.FunctionCallingConfig.Mode = mode
+		}
+	}
+
+	var system []geminiPart
+	for _, msg := range req.Messages {
+		if msg.Role == ai.RoleSystem {
+			system = append(system, geminiPart{Text: msg.Text()})
+			continue
+		}
+
+		role := "user"
+		if msg.Role == ai.RoleModel {
+			role = "model"
+		}
+		var parts []geminiPart
+		for _, part := range msg.Content {
+			signature, _ := part.Metadata[thoughtSignatureKey].(string)
+			switch {
+			case part.IsToolRequest():
+				parts = append(parts, geminiPart{
+					FunctionCall: &geminiFunctionCall{
+						ID:   part.ToolRequest.Ref,
+						Name: part.ToolRequest.Name,
+						Args: toolInput(part.ToolRequest.Input),
+					},
+					ThoughtSignature: signature,
+				})
+			case part.IsToolResponse():
+				parts = append(parts, geminiPart{
+					FunctionResponse: &geminiFunctionResp{
+						ID:       part.ToolResponse.Ref,
+						Name:     part.ToolResponse.Name,
+						Response: geminiFunctionOutput(part.ToolResponse.Output),
+					},
+				})
+			case part.IsReasoning():
+				// Thought summaries are for the user; the model only needs
+				// its signatures back, which travel on the other parts.
+			case part.IsText():
+				if strings.TrimSpace(part.Text) != "" || signature != "" {
+					parts = append(parts, geminiPart{Text: part.Text, ThoughtSignature: signature})
+				}
+			}
+		}
+		if len(parts) == 0 {
+			continue
+		}
+
+		if n := len(body.Contents); n > 0 && body.Contents[n-1].Role == role {
+			body.Contents[n-1].Parts = append(body.Contents[n-1].Parts, parts...)
+			continue
+		}
+		body.Contents = append(body.Contents, geminiContent{Role: role, Parts: parts})
+	}
+	if len(system) > 0 {
+		body.SystemInstruction = &geminiContent{Parts: system}
+	}
+	return body
+}
+
+// geminiFunctionOutput wraps a tool's output in the object a function
+// response must be.
+func geminiFunctionOutput(output any) map[string]any {
+	if object, ok := output.(map[string]any); ok {
+		return object
+	}
+	return map[string]any{"output": output}
+}
+
+// geminiSchemaFields are the JSON Schema keywords the Gemini API accepts in
+// function parameters; it rejects requests with any others.
+var geminiSchemaFields = map[string]bool{
+	"type": true, "format": true, "description": true, "nullable": true, "enum": true,
+	"properties": true, "required": true, "items": true, "minItems": true, "maxItems": true,
+	"minimum": true, "maximum": true, "anyOf": true, "title": true,
+}
+
+// geminiSchema reduces a tool's JSON Schema to the subset the Gemini API
+// accepts, inlining references to definitions in root.
+func geminiSchema(schema, root map[string]any) map[string]any {
+	if schema == nil {
+		return nil
+	}
+	if ref, ok := schema["$ref"].(string); ok {
+		name := ref[strings.LastIndex(ref, "/")+1:]
+		for _, key := range []string{"$defs", "definitions"} {
+			if defs, ok := root[key].(map[string]any); ok {
+				if def, ok := defs[name].(map[string]any); ok {
+					return geminiSchema(def, root)
+				}
+			}
+		}
+		return map[string]any{"type": "object"}
+	}
+
+	out := map[string]any{}
+	for key, value := range schema {
+		if !geminiSchemaFields[key] {
+			continue
+		}
+		switch key {
+		case "type":
+			// A list of types such as ["string", "null"] becomes the
+			// first real type, marked nullable.
+			if types, ok := value.([]any); ok {
+				for _, t := range types {
+					if t == "null" {
+						out["nullable"] = true
+					} else if _, set := out["type"]; !set {
+						out["type"] = t
+					}
+				}
+				continue
+			}
+			out[key] = value
+		case "properties":
+			properties := map[string]any{}
+			if props, ok := value.(map[string]any); ok {
+				for name, prop := range props {
+					if prop, ok := prop.(map[string]any); ok {
+						properties[name] = geminiSchema(prop, root)
+					}
+				}
+			}
+			out[key] = properties
+		case "items":
+			if items, ok := value.(map[string]any); ok {
+				out[key] = geminiSchema(items, root)
+			}
+		case "anyOf":
+			var variants []any
+			if list, ok := value.([]any); ok {
+				for _, variant := range list {
+					if variant, ok := variant.(map[string]any); ok {
+						variants = append(variants, geminiSchema(variant, root))
+					}
+				}
+			}
+			out[key] = variants
+		default:
+			out[key] = value
+		}
+	}
+	return out
+}
+
+// geminiParameters returns the parameters of a function declaration for a
+// tool's input schema. Gemini rejects an object without properties, so a
+// tool without arguments declares no parameters at all.
+func geminiParameters(schema map[string]any) map[string]any {
+	params := geminiSchema(schema, schema)
+	if props, ok := params["properties"].(map[string]any); !ok || len(props) == 0 {
+		return nil
+	}
+	return params
+}
+
+// response translates a generateContent response into a Genkit response.
+func (r *geminiResponse) response(req *ai.ModelRequest) (*ai.ModelResponse, error) {
+	resp := &ai.ModelResponse{
+		Request: req,
+		Message: &ai.Message{Role: ai.RoleModel},
+		Usage: &ai.GenerationUsage{
+			InputTokens:         r.UsageMetadata.PromptTokenCount,
+			OutputTokens:        r.UsageMetadata.CandidatesTokenCount + r.UsageMetadata.ThoughtsTokenCount,
+			ThoughtsTokens:      r.UsageMetadata.ThoughtsTokenCount,
+			CachedContentTokens: r.UsageMetadata.CachedContentTokenCount,
+			TotalTokens:         r.UsageMetadata.TotalTokenCount,
+		},
+	}
+
+	if len(r.Candidates) == 0 {
+		if reason := r.PromptFeedback.BlockReason; reason != "" {
+			resp.FinishReason = ai.FinishReasonBlocked
+			resp.FinishMessage = reason
+			return resp, nil
+		}
+		return nil, fmt.Errorf("gemini returned no candidates")
+	}
+
+	candidate := r.Candidates[0]
+	for _, part := range candidate.Content.Parts {
+		if p := part.part(); p != nil {
+			resp.Message.Content = append(resp.Message.Content, p)
+		}
+	}
+
+	switch candidate.FinishReason {
+	case "STOP", "":
+		resp.FinishReason = ai.FinishReasonStop
+	case "MAX_TOKENS":
+		resp.FinishReason = ai.FinishReasonLength
+	case "SAFETY", "RECITATION", "BLOCKLIST", "PROHIBITED_CONTENT", "SPII":
+		resp.FinishReason = ai.FinishReasonBlocked
+	default:
+		resp.FinishReason = ai.FinishReasonOther
+	}
+	resp.FinishMessage = candidate.FinishReason
+	return resp, nil
+}
+
+// part translates a response part into a Genkit part.
+func (p geminiPart) part() *ai.Part {
+	var part *ai.Part
+	switch {
+	case p.FunctionCall != nil:
+		part = ai.NewToolRequestPart(&ai.ToolRequest{Ref: p.FunctionCall.ID, Name: p.FunctionCall.Name, Input: p.FunctionCall.Args})
+	case p.Thought:
+		part = ai.NewReasoningPart(p.Text, nil)
+	case p.Text != "" || p.ThoughtSignature != "":
+		part = ai.NewTextPart(p.Text)
+	default:
+		return nil
+	}
+	if p.ThoughtSignature != "" {
+		if part.Metadata == nil {
+			part.Metadata = map[string]any{}
+		}
+		part.Metadata[thoughtSignatureKey] = p.ThoughtSignature
+	}
+	return part
+}
+
+// readGeminiStream assembles a streamed response, passing each part to cb
+// as it arrives. Every event is a complete response holding the next
+// parts; the last one carries the finish reason and final usage.
+func readGeminiStream(ctx context.Context, body io.Reader, cb ai.ModelStreamCallback) (*geminiResponse, error) {
+	var result geminiResponse
+	err := readSSE(body, func(_, data string) error {
+		var chunk geminiResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return fmt.Errorf("failed to decode gemini stream event: %w", err)
+		}
+		if chunk.UsageMetadata.TotalTokenCount > 0 {
+			result.UsageMetadata = chunk.UsageMetadata
+		}
+		if chunk.PromptFeedback.BlockReason != "" {
+			result.PromptFeedback = chunk.PromptFeedback
+		}
+		if len(chunk.Candidates) == 0 {
+			return nil
+		}
+		candidate := chunk.Candidates[0]
+		if len(result.Candidates) == 0 {
+			result.Candidates = append(result.Candidates, candidate)
+			result.Candidates[0].Content.Parts = nil
+		}
+		if candidate.FinishReason != "" {
+			result.Candidates[0].FinishReason = candidate.FinishReason
+		}
+		var parts []*ai.Part
+		for _, part := range candidate.Content.Parts {
+			result.Candidates[0].Content.Parts = appendGeminiPart(result.Candidates[0].Content.Parts, part)
+			if p := part.part(); p != nil {
+				parts = append(parts, p)
+			}
+		}
+		return emit(ctx, cb, parts...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// appendGeminiPart adds a streamed part to parts, joining consecutive text
+// so the assembled response has one part per passage rather than per
+// chunk.
+func appendGeminiPart(parts []geminiPart, part geminiPart) []geminiPart {
+	if n := len(parts); n > 0 && part.FunctionCall == nil && part.ThoughtSignature == "" {
+		last := &parts[n-1]
+		if last.FunctionCall == nil && last.Thought == part.Thought {
+			last.Text += part.Text
+			return parts
+		}
+	}
+	return append(parts, part)
+}
@@ -0,0 +1,212 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+func TestGeminiRequest(t *testing.T) {
+	server := newStubServer(t, jsonReply(`{
+		"candidates": [{"content": {"role": "model", "parts": [{"text": "HI it is."}]}, "finishReason": "STOP"}],
+		"usageMetadata": {"promptTokenCount": 10, "candidatesTokenCount": 3, "thoughtsTokenCount": 2, "totalTokenCount": 15}
+	}`))
+	_, model, _ := defineStubModel(t, "gemini", "gemini-test", server)
+
+	req := &ai.ModelRequest{
+		Messages: toolRoundTrip(),
+		Tools: []*ai.ToolDefinition{{
+			Name:        "echo",
+			Description: "Repeats text",
+			InputSchema: map[string]any{
+				"$schema":              "http://json-schema.org/draft-07/schema#",
+				"type":                 "object",
+				"additionalProperties": false,
+				"properties":           map[string]any{"text": map[string]any{"type": []any{"string", "null"}}},
+			},
+		}},
+		Config: &ai.GenerationCommonConfig{Temperature: 0.5, MaxOutputTokens: 100},
+	}
+	resp, err := model.Generate(context.Background(), req, nil)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+
+	received := server.received()
+	if len(received) != 1 {
+		t.Fatalf("got %d requests, want 1", len(received))
+	}
+	sent := received[0]
+	if sent.Path != "/v1beta/models/gemini-test:generateContent" {
+		t.Errorf("path = %q", sent.Path)
+	}
+	if sent.Header.Get("x-goog-api-key") != "test-key" {
+		t.Errorf("headers = %v", sent.Header)
+	}
+
+	checks := []struct {
+		keys []any
+		want any
+	}{
+		{[]any{"generationConfig", "maxOutputTokens"}, 100.0},
+		{[]any{"generationConfig", "temperature"}, 0.5},
+		{[]any{"systemInstruction", "parts", 0, "text"}, "Be brief."},
+		{[]any{"tools", 0, "functionDeclarations", 0, "name"}, "echo"},
+		{[]any{"tools", 0, "functionDeclarations", 0, "parameters", "additionalProperties"}, nil},
+		{[]any{"tools", 0, "functionDeclarations", 0, "parameters", "properties", "text", "type"}, "string"},
+		{[]any{"tools", 0, "functionDeclarations", 0, "parameters", "properties", "text", "nullable"}, true},
+		{[]any{"contents", 0, "role"}, "user"},
+		{[]any{"contents", 0, "parts", 0, "text"}, "Shout hi"},
+		{[]any{"contents", 1, "role"}, "model"},
+		{[]any{"contents", 1, "parts", 0, "functionCall", "id"}, "call_1"},
+		{[]any{"contents", 1, "parts", 0, "functionCall", "name"}, "echo"},
+		{[]any{"contents", 1, "parts", 0, "functionCall", "args", "text"}, "hi"},
+		{[]any{"contents", 2, "role"}, "user"},
+		{[]any{"contents", 2, "parts", 0, "functionResponse", "id"}, "call_1"},
+		{[]any{"contents", 2, "parts", 0, "functionResponse", "response", "output"}, "HI"},
+	}
+	for _, check := range checks {
+		if got := path(t, sent.Body, check.keys...); got != check.want {
+			t.Errorf("request %v = %v, want %v", check.keys, got, check.want)
+		}
+	}
+
+	if resp.Text() != "HI it is." || resp.FinishReason != ai.FinishReasonStop {
+		t.Errorf("response = %q, %s", resp.Text(), resp.FinishReason)
+	}
+	if resp.Usage.InputTokens != 10 || resp.Usage.OutputTokens != 5 || resp.Usage.ThoughtsTokens != 2 {
+		t.Errorf("usage = %+v", resp.Usage)
+	}
+}
+
+func TestGeminiToolRoundTrip(t *testing.T) {
+	server := newStubServer(t,
+		jsonReply(`{
+			"candidates": [{"content": {"role": "model", "parts": [
+				{"functionCall": {"name": "echo", "args": {"text": "hi"}}, "thoughtSignature": "c2ln"}
+			]}, "finishReason": "STOP"}],
+			"usageMetadata": {"promptTokenCount": 20, "candidatesTokenCount": 8, "totalTokenCount": 28}
+		}`),
+		jsonReply(`{
+			"candidates": [{"content": {"role": "model", "parts": [{"text": "It said HI."}]}, "finishReason": "STOP"}],
+			"usageMetadata": {"promptTokenCount": 40, "candidatesTokenCount": 4, "totalTokenCount": 44}
+		}`),
+	)
+	g, model, echo := defineStubModel(t, "gemini", "gemini-test", server)
+
+	resp, err := genkit.Generate(context.Background(), g,
+		ai.WithModel(model), ai.WithTools(echo), ai.WithPrompt("Shout hi"))
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if resp.Text() != "It said HI." {
+		t.Errorf("text = %q", resp.Text())
+	}
+
+	received := server.received()
+	if len(received) != 2 {
+		t.Fatalf("got %d requests, want 2", len(received))
+	}
+	second := received[1].Body
+	if got := path(t, second, "contents", 1, "parts", 0, "thoughtSignature"); got != "c2ln" {
+		t.Errorf("thought signature sent back = %v", got)
+	}
+	if got := path(t, second, "contents", 2, "parts", 0, "functionResponse", "name"); got != "echo" {
+		t.Errorf("function response name = %v", got)
+	}
+	if got := path(t, second, "contents", 2, "parts", 0, "functionResponse", "response", "output"); got != "HI" {
+		t.Errorf("function response = %v", got)
+	}
+}
+
+func TestGeminiStream(t *testing.T) {
+	server := newStubServer(t, sseReply(`data: {"candidates": [{"content": {"role": "model", "parts": [{"text": "Thinking it over.", "thought": true}]}}]}
+
+data: {"candidates": [{"content": {"role": "model", "parts": [{"text": "Call"}]}}]}
+
+data: {"candidates": [{"content": {"role": "model", "parts": [{"text": "ing echo."}]}}]}
+
+data: {"candidates": [{"content": {"role": "model", "parts": [{"functionCall": {"name": "echo", "args": {"text": "hi"}}}]}, "finishReason": "STOP"}], "usageMetadata": {"promptTokenCount": 12, "candidatesTokenCount": 30, "totalTokenCount": 42}}
+
+`))
+	_, model, _ := defineStubModel(t, "gemini", "gemini-test", server)
+
+	var streamed strings.Builder
+	var toolCalls int
+	cb := func(ctx context.Context, chunk *ai.ModelResponseChunk) error {
+		for _, part := range chunk.Content {
+			switch {
+			case part.IsToolRequest():
+				toolCalls++
+			case part.IsText():
+				streamed.WriteString(part.Text)
+			}
+		}
+		return nil
+	}
+	resp, err := model.Generate(context.Background(), &ai.ModelRequest{Messages: []*ai.Message{ai.NewUserTextMessage("Shout hi")}}, cb)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+
+	sent := server.received()[0]
+	if sent.Path != "/v1beta/models/gemini-test:streamGenerateContent" || sent.Query != "alt=sse" {
+		t.Errorf("url = %s?%s", sent.Path, sent.Query)
+	}
+	if streamed.String() != "Calling echo." || toolCalls != 1 {
+		t.Errorf("streamed %q and %d tool calls", streamed.String(), toolCalls)
+	}
+	if resp.Text() != "Calling echo." || resp.Reasoning() != "Thinking it over." {
+		t.Errorf("text %q, reasoning %q", resp.Text(), resp.Reasoning())
+	}
+	if n := len(resp.Message.Content); n != 3 {
+		t.Errorf("response has %d parts, want reasoning, joined text and a tool call", n)
+	}
+	requests := resp.ToolRequests()
+	if len(requests) != 1 || requests[0].Name != "echo" || path(t, requests[0].Input, "text") != "hi" {
+		t.Errorf("tool requests = %+v", requests)
+	}
+	if resp.Usage.InputTokens != 12 || resp.Usage.OutputTokens != 30 {
+		t.Errorf("usage = %+v", resp.Usage)
+	}
+}
+
+func TestGeminiBlockedPrompt(t *testing.T) {
+	server := newStubServer(t, jsonReply(`{"promptFeedback": {"blockReason": "SAFETY"}}`))
+	_, model, _ := defineStubModel(t, "gemini", "gemini-test", server)
+
+	resp, err := model.Generate(context.Background(), &ai.ModelRequest{Messages: []*ai.Message{ai.NewUserTextMessage("hi")}}, nil)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if resp.FinishReason != ai.FinishReasonBlocked || resp.FinishMessage != "SAFETY" {
+		t.Errorf("finish = %s %q, want blocked by SAFETY", resp.FinishReason, resp.FinishMessage)
+	}
+}
+
+func TestGeminiHTTPError(t *testing.T) {
+	server := newStubServer(t, errorReply(http.StatusServiceUnavailable,
+		map[string]string{"Retry-After": "3", "Content-Type": "application/json"},
+		`{"error": {"code": 503, "message": "The model is overloaded.", "status": "UNAVAILABLE"}}`))
+	_, model, _ := defineStubModel(t, "gemini", "gemini-test", server)
+
+	var b strings.Builder
+	_, err := model.Generate(context.Background(), &ai.ModelRequest{Messages: []*ai.Message{ai.NewUserTextMessage("hi")}}, collectText(&b))
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("err = %v, want an *apiError", err)
+	}
+	if apiErr.StatusCode != http.StatusServiceUnavailable || apiErr.RetryAfter != 3*time.Second ||
+		apiErr.Message != "The model is overloaded." {
+		t.Errorf("err = %+v", apiErr)
+	}
+	if retry, wait := retryable(err); !retry || wait != 3*time.Second {
+		t.Errorf("retryable = %v, %s; want true, 3s", retry, wait)
+	}
+}
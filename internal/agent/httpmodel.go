@@ -0,0 +1,214 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+// Helpers for the providers that call their model's HTTP API directly
+// rather than through a Genkit plugin.
+
+// maxErrorBody caps how much of a failed response is read for its message.
+const maxErrorBody = 64 << 10
+
+// apiError is a model API request that failed with an HTTP error status.
+type apiError struct {
+	Provider   string
+	StatusCode int
+	Message    string
+	// RetryAfter is the delay the server asked for before trying again;
+	// 0 when it did not say.
+	RetryAfter time.Duration
+}
+
+func (e *apiError) Error() string {
+	status := strconv.Itoa(e.StatusCode)
+	if text := http.StatusText(e.StatusCode); text != "" {
+		status += " " + text
+	}
+	return fmt.Sprintf("%s API error (%s): %s", e.Provider, status, e.Message)
+}
+
+// httpClient returns the client for a provider's requests. The timeout,
+// in seconds with 0 meaning none, bounds the wait for the response
+// headers only: a streamed reply may take much longer to arrive in full.
+func httpClient(timeout int) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = time.Duration(timeout) * time.Second
+	return &http.Client{Transport: transport}
+}
+
+// postJSON sends body as JSON to url and returns the response when its
+// status is 2xx. Other statuses are returned as an *apiError carrying the
+// message from the error body.
+func postJSON(ctx context.Context, client *http.Client, provider, url string, headers map[string]string, body any) (*http.Response, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s request: %w", provider, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s request failed: %w", provider, err)
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	return nil, &apiError{
+		Provider:   provider,
+		StatusCode: resp.StatusCode,
+		Message:    errorMessage(raw),
+		RetryAfter: retryAfter(resp.Header),
+	}
+}
+
+// retryAfter parses a Retry-After header, given in seconds or as an HTTP
+// date, into the delay it asks for.
+func retryAfter(header http.Header) time.Duration {
+	value := strings.TrimSpace(header.Get("Retry-After"))
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		if seconds <= 0 {
+			return 0
+		}
+		return time.Duration(seconds * float64(time.Second))
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if delay := time.Until(at); delay > 0 {
+			return delay
+		}
+	}
+	return 0
+}
+
+// errorMessage extracts the message from an API error body, which both
+// Anthropic and Gemini shape as {"error": {"message": ...}}.
+func errorMessage(raw []byte) string {
+	var body struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(raw, &body) == nil && body.Error.Message != "" {
+		return body.Error.Message
+	}
+	if msg := strings.TrimSpace(string(raw)); msg != "" {
+		return msg
+	}
+	return "no error message"
+}
+
+// decodeJSON decodes a provider's complete response.
+func decodeJSON(resp *http.Response, provider string, v any) error {
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode %s response: %w", provider, err)
+	}
+	return nil
+}
+
+// readSSE calls fn for every server-sent event in r, with its event name
+// (empty when unset) and data, until r ends or fn fails.
+func readSSE(r io.Reader, fn func(event, data string) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 16<<20)
+
+	var event string
+	var data []string
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if len(data) > 0 {
+				if err := fn(event, strings.Join(data, "\n")); err != nil {
+					return err
+				}
+			}
+			event, data = "", nil
+		case strings.HasPrefix(line, ":"):
+			// A comment, sent to keep the connection alive.
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if len(data) > 0 {
+		return fn(event, strings.Join(data, "\n"))
+	}
+	return nil
+}
+
+// generationConfig returns the request's common generation settings, or
+// an empty config when it sets none.
+func generationConfig(req *ai.ModelRequest) *ai.GenerationCommonConfig {
+	switch config := req.Config.(type) {
+	case *ai.GenerationCommonConfig:
+		if config != nil {
+			return config
+		}
+	case ai.GenerationCommonConfig:
+		return &config
+	case map[string]any:
+		var common ai.GenerationCommonConfig
+		if data, err := json.Marshal(config); err == nil && json.Unmarshal(data, &common) == nil {
+			return &common
+		}
+	}
+	return &ai.GenerationCommonConfig{}
+}
+
+// toolOutputText renders a tool's output for APIs that take it as text.
+func toolOutputText(output any) string {
+	if s, ok := output.(string); ok {
+		return s
+	}
+	data, err := json.Marshal(output)
+	if err != nil {
+		return fmt.Sprint(output)
+	}
+	return string(data)
+}
+
+// toolInput returns a tool request's input as a JSON object, which is
+// what the APIs expect even for tools that take no arguments.
+func toolInput(input any) any {
+	if input == nil {
+		return map[string]any{}
+	}
+	return input
+}
+
+// emit sends parts to the stream callback, if there is one.
+func emit(ctx context.Context, cb ai.ModelStreamCallback, parts ...*ai.Part) error {
+	if cb == nil || len(parts) == 0 {
+		return nil
+	}
+	return cb(ctx, &ai.ModelResponseChunk{Role: ai.RoleModel, Content: parts})
+}
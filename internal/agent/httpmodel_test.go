@@ -0,0 +1,282 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+	"github.com/niradler/termu/internal/config"
+)
+
+// stubRequest is a request received by a stub API server.
+type stubRequest struct {
+	Path   string
+	Query  string
+	Header http.Header
+	Body   map[string]any
+}
+
+// stubServer stands in for a provider's API. Each request is recorded and
+// answered by the next handler; the last one answers any further requests.
+type stubServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []stubRequest
+}
+
+func newStubServer(t *testing.T, handlers ...http.HandlerFunc) *stubServer {
+	t.Helper()
+	s := &stubServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := io.ReadAll(r.Body)
+		req := stubRequest{Path: r.URL.Path, Query: r.URL.RawQuery, Header: r.Header.Clone()}
+		if err := json.Unmarshal(raw, &req.Body); err != nil {
+			t.Errorf("request body is not JSON: %v: %s", err, raw)
+		}
+		s.mu.Lock()
+		s.requests = append(s.requests, req)
+		handler := handlers[min(len(s.requests), len(handlers))-1]
+		s.mu.Unlock()
+		handler(w, r)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// received returns the requests received so far.
+func (s *stubServer) received() []stubRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]stubRequest(nil), s.requests...)
+}
+
+// jsonReply answers with body as JSON.
+func jsonReply(body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, body)
+	}
+}
+
+// sseReply answers with body as a stream of server-sent events.
+func sseReply(body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, body)
+	}
+}
+
+// errorReply answers with an error status, its headers and body.
+func errorReply(status int, headers map[string]string, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for name, value := range headers {
+			w.Header().Set(name, value)
+		}
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}
+}
+
+// defineStubModel defines the provider's model talking to server, with the
+// echo tool that upper-cases its text.
+func defineStubModel(t *testing.T, provider, name string, server *stubServer) (*genkit.Genkit, ai.Model, ai.Tool) {
+	t.Helper()
+	p, err := newProvider(config.ModelConfig{Provider: provider, Name: name, APIKey: "test-key", BaseURL: server.URL, Timeout: 5})
+	if err != nil {
+		t.Fatalf("newProvider: %v", err)
+	}
+	g := genkit.Init(context.Background())
+	model, err := p.DefineModel(g)
+	if err != nil {
+		t.Fatalf("DefineModel: %v", err)
+	}
+	type echoInput struct {
+		Text string `json:"text"`
+	}
+	echo := genkit.DefineTool(g, "echo", "Repeats text in upper case",
+		func(ctx *ai.ToolContext, input echoInput) (string, error) {
+			return strings.ToUpper(input.Text), nil
+		})
+	return g, model, echo
+}
+
+// collectText returns a stream callback gathering the streamed text.
+func collectText(b *strings.Builder) ai.ModelStreamCallback {
+	return func(ctx context.Context, chunk *ai.ModelResponseChunk) error {
+		b.WriteString(chunk.Text())
+		return nil
+	}
+}
+
+// toolRoundTrip is a conversation in which the model called the echo tool
+// and got its output back.
+func toolRoundTrip() []*ai.Message {
+	return []*ai.Message{
+		ai.NewSystemTextMessage("Be brief."),
+		ai.NewUserTextMessage("Shout hi"),
+		ai.NewModelMessage(ai.NewToolRequestPart(&ai.ToolRequest{Ref: "call_1", Name: "echo", Input: map[string]any{"text": "hi"}})),
+		ai.NewMessage(ai.RoleTool, nil, ai.NewToolResponsePart(&ai.ToolResponse{Ref: "call_1", Name: "echo", Output: "HI"})),
+	}
+}
+
+// path walks a decoded JSON value by object keys and array indexes.
+func path(t *testing.T, value any, keys ...any) any {
+	t.Helper()
+	for _, key := range keys {
+		switch k := key.(type) {
+		case string:
+			object, ok := value.(map[string]any)
+			if !ok {
+				t.Fatalf("%v: not an object at %q", value, k)
+			}
+			value = object[k]
+		case int:
+			array, ok := value.([]any)
+			if !ok || k >= len(array) {
+				t.Fatalf("%v: no element %d", value, k)
+			}
+			value = array[k]
+		}
+	}
+	return value
+}
+
+func TestPostJSONErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		headers   map[string]string
+		body      string
+		message   string
+		retryable bool
+		wait      time.Duration
+	}{
+		{
+			name:      "rate limited",
+			status:    http.StatusTooManyRequests,
+			headers:   map[string]string{"Retry-After": "7"},
+			body:      `{"error": {"type": "rate_limit_error", "message": "slow down"}}`,
+			message:   "test API error (429 Too Many Requests): slow down",
+			retryable: true,
+			wait:      7 * time.Second,
+		},
+		{
+			name:      "overloaded",
+			status:    529,
+			body:      `{"error": {"message": "overloaded"}}`,
+			message:   "test API error (529): overloaded",
+			retryable: true,
+		},
+		{
+			name:    "bad request",
+			status:  http.StatusBadRequest,
+			body:    `{"error": {"message": "bad tool schema"}}`,
+			message: "test API error (400 Bad Request): bad tool schema",
+		},
+		{
+			name:    "plain text body",
+			status:  http.StatusUnauthorized,
+			body:    "no key\n",
+			message: "test API error (401 Unauthorized): no key",
+		},
+		{
+			name:      "server error without body",
+			status:    http.StatusBadGateway,
+			message:   "test API error (502 Bad Gateway): no error message",
+			retryable: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newStubServer(t, errorReply(tt.status, tt.headers, tt.body))
+			_, err := postJSON(context.Background(), httpClient(5), "test", server.URL, nil, map[string]any{})
+			var apiErr *apiError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("err = %v, want an *apiError", err)
+			}
+			if apiErr.StatusCode != tt.status || err.Error() != tt.message {
+				t.Errorf("err = %q (status %d), want %q", err, apiErr.StatusCode, tt.message)
+			}
+			retry, wait := retryable(err)
+			if retry != tt.retryable || wait != tt.wait {
+				t.Errorf("retryable = %v, %s; want %v, %s", retry, wait, tt.retryable, tt.wait)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"3", 3 * time.Second},
+		{"0.5", 500 * time.Millisecond},
+		{"-1", 0},
+		{"soon", 0},
+		{time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat), 0},
+	}
+	for _, tt := range tests {
+		header := http.Header{}
+		header.Set("Retry-After", tt.value)
+		if got := retryAfter(header); got != tt.want {
+			t.Errorf("retryAfter(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+
+	header := http.Header{}
+	header.Set("Retry-After", time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+	if got := retryAfter(header); got <= 50*time.Second || got > time.Minute {
+		t.Errorf("retryAfter(date a minute away) = %s", got)
+	}
+}
+
+func TestReadSSE(t *testing.T) {
+	stream := ": keep-alive\n\nevent: one\ndata: a\ndata: b\n\ndata: c\n\ndata: last"
+	var got []string
+	err := readSSE(strings.NewReader(stream), func(event, data string) error {
+		got = append(got, event+"="+data)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("readSSE: %v", err)
+	}
+	want := []string{"one=a\nb", "=c", "=last"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("events = %q, want %q", got, want)
+	}
+}
+
+func TestHTTPClientTimesOutOnHeadersOnly(t *testing.T) {
+	server := newStubServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		// The body takes longer than the timeout to arrive.
+		time.Sleep(1500 * time.Millisecond)
+		io.WriteString(w, "data: done\n\n")
+	})
+
+	resp, err := postJSON(context.Background(), httpClient(1), "test", server.URL, nil, map[string]any{})
+	if err != nil {
+		t.Fatalf("postJSON: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading a slow body: %v", err)
+	}
+	if string(body) != "data: done\n\n" {
+		t.Errorf("body = %q", body)
+	}
+}
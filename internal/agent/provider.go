@@ -0,0 +1,137 @@
+package agent
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core/api"
+	"github.com/firebase/genkit/go/genkit"
+	"github.com/firebase/genkit/go/plugins/compat_oai"
+	"github.com/firebase/genkit/go/plugins/ollama"
+	"github.com/niradler/termu/internal/config"
+	"github.com/openai/openai-go/option"
+)
+
+// A Provider connects the agent to one model backend.
+type Provider interface {
+	// Plugins returns the Genkit plugins to initialize before the model is
+	// defined; providers that talk to their API directly need none.
+	Plugins() []api.Plugin
+	// DefineModel defines the configured model in g.
+	DefineModel(g *genkit.Genkit) (ai.Model, error)
+}
+
+// ProviderFactory creates the Provider for a model configuration.
+type ProviderFactory func(cfg config.ModelConfig) (Provider, error)
+
+var providers = map[string]ProviderFactory{}
+
+// RegisterProvider makes a backend available as model.provider name.
+func RegisterProvider(name string, factory ProviderFactory) {
+	if _, ok := providers[name]; ok {
+		panic("agent: provider registered twice: " + name)
+	}
+	providers[name] = factory
+}
+
+// Providers returns the names of the registered providers, sorted.
+func Providers() []string {
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newProvider returns the provider selected by cfg.
+func newProvider(cfg config.ModelConfig) (Provider, error) {
+	factory, ok := providers[cfg.Provider]
+	if !ok {
+		return nil, fmt.Errorf("unsupported provider: %s (available: %s)", cfg.Provider, strings.Join(Providers(), ", "))
+	}
+	return factory(cfg)
+}
+
+// modelOptions describes every model the agent uses: it must take turns,
+// follow a system prompt and call tools.
+func modelOptions(label string) *ai.ModelOptions {
+	return &ai.ModelOptions{
+		Label: label,
+		Supports: &ai.ModelSupports{
+			Multiturn:  true,
+			SystemRole: true,
+			Tools:      true,
+			Media:      false,
+		},
+	}
+}
+
+func init() {
+	RegisterProvider("ollama", newOllamaProvider)
+	RegisterProvider("openai", newOpenAIProvider)
+}
+
+// ollamaProvider runs models on an Ollama server.
+type ollamaProvider struct {
+	cfg    config.ModelConfig
+	plugin *ollama.Ollama
+}
+
+func newOllamaProvider(cfg config.ModelConfig) (Provider, error) {
+	return &ollamaProvider{
+		cfg: cfg,
+		plugin: &ollama.Ollama{
+			ServerAddress: cfg.Server,
+			Timeout:       cfg.Timeout,
+		},
+	}, nil
+}
+
+func (p *ollamaProvider) Plugins() []api.Plugin {
+	return []api.Plugin{p.plugin}
+}
+
+func (p *ollamaProvider) DefineModel(g *genkit.Genkit) (ai.Model, error) {
+	return p.plugin.DefineModel(g,
+		ollama.ModelDefinition{
+			Name: p.cfg.Name,
+			Type: "chat",
+		},
+		modelOptions("Ollama - "+p.cfg.Name),
+	), nil
+}
+
+// openAIProvider talks to OpenAI or any server with a compatible API.
+type openAIProvider struct {
+	cfg    config.ModelConfig
+	plugin *compat_oai.OpenAICompatible
+}
+
+func newOpenAIProvider(cfg config.ModelConfig) (Provider, error) {
+	// The agent retries failed requests itself; see retry.go.
+	opts := []option.RequestOption{option.WithAPIKey(cfg.APIKey), option.WithMaxRetries(0)}
+	if cfg.BaseURL != "" {
+		opts = append(opts, option.WithBaseURL(cfg.BaseURL))
+	}
+
+	return &openAIProvider{
+		cfg: cfg,
+		plugin: &compat_oai.OpenAICompatible{
+			Opts:     opts,
+			Provider: "openai",
+			APIKey:   cfg.APIKey,
+			BaseURL:  cfg.BaseURL,
+		},
+	}, nil
+}
+
+func (p *openAIProvider) Plugins() []api.Plugin {
+	return []api.Plugin{p.plugin}
+}
+
+func (p *openAIProvider) DefineModel(g *genkit.Genkit) (ai.Model, error) {
+	return p.plugin.DefineModel("openai", p.cfg.Name, *modelOptions("OpenAI Compatible - " + p.cfg.Name)), nil
+}
@@ -0,0 +1,233 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+	"github.com/openai/openai-go"
+)
+
+// fallbackModel is a model from the fallback list, connected on first use.
+type fallbackModel struct {
+	profile  string
+	provider string
+	model    ai.Model
+	err      error
+}
+
+// name returns the fallback model as provider/name.
+func (f *fallbackModel) name() string {
+	return f.provider + "/" + strings.TrimPrefix(f.model.Name(), f.provider+"/")
+}
+
+// retryModelCalls is model middleware that retries requests failing with a
+// transient error and, once the model in use keeps failing, moves on to the
+// fallback models in order. A generation that has moved to a fallback
+// model stays with it; the next one starts with the model in use again.
+// Nothing is retried once part of the response has been streamed.
+func (a *Agent) retryModelCalls(next ai.ModelFunc) ai.ModelFunc {
+	return func(ctx context.Context, req *ai.ModelRequest, cb ai.ModelStreamCallback) (*ai.ModelResponse, error) {
+		streamed := false
+		if cb != nil {
+			forward := cb
+			cb = func(ctx context.Context, chunk *ai.ModelResponseChunk) error {
+				streamed = true
+				return forward(ctx, chunk)
+			}
+		}
+
+		var lastErr error
+		for a.fallback <= len(a.cfg.Fallback) {
+			name, generate := a.ModelName(), next
+			if a.fallback > 0 {
+				f := a.fallbackModel(ctx, a.cfg.Fallback[a.fallback-1])
+				if f == nil {
+					a.fallback++
+					continue
+				}
+				if f.err != nil {
+					a.notify(fmt.Sprintf("Fallback model %s is unavailable: %v", f.profile, f.err))
+					a.fallback++
+					continue
+				}
+				name = f.name()
+				generate = a.logModelCallsTo(f.provider, f.model, f.model.Generate)
+			}
+
+			resp, err := a.withRetries(ctx, name, generate, req, cb, &streamed)
+			if err == nil {
+				a.answeredBy = name
+				return resp, nil
+			}
+			lastErr = err
+			if streamed || ctx.Err() != nil {
+				return nil, err
+			}
+
+			a.fallback++
+			if a.fallback <= len(a.cfg.Fallback) {
+				a.notify(fmt.Sprintf("%s failed: %v; trying fallback model %s", name, err, a.cfg.Fallback[a.fallback-1]))
+			}
+		}
+		return nil, lastErr
+	}
+}
+
+// withRetries calls generate, retrying transient errors as configured.
+func (a *Agent) withRetries(ctx context.Context, name string, generate ai.ModelFunc, req *ai.ModelRequest, cb ai.ModelStreamCallback, streamed *bool) (*ai.ModelResponse, error) {
+	retry := a.cfg.Retry
+	maxDelay := seconds(retry.MaxDelay)
+	for attempt := 0; ; attempt++ {
+		resp, err := generate(ctx, req, cb)
+		if err == nil {
+			return resp, nil
+		}
+		transient, wait := retryable(err)
+		if !transient || *streamed || attempt >= retry.MaxRetries || ctx.Err() != nil {
+			return nil, err
+		}
+		if wait == 0 {
+			wait = backoff(attempt, seconds(retry.InitialDelay), maxDelay)
+		} else if maxDelay > 0 && wait > maxDelay {
+			// The server will not take requests again soon enough.
+			return nil, err
+		}
+
+		a.notify(fmt.Sprintf("%s failed: %v; retrying in %s (%d of %d)", name, err, wait.Round(100*time.Millisecond), attempt+1, retry.MaxRetries))
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// fallbackModel returns the named fallback model, connecting to it on
+// first use, or nil when it is the model in use.
+func (a *Agent) fallbackModel(ctx context.Context, profile string) *fallbackModel {
+	if f, ok := a.fallbacks[profile]; ok {
+		if f.model != nil && f.name() == a.ModelName() {
+			return nil
+		}
+		return f
+	}
+
+	f := &fallbackModel{profile: profile}
+	if a.fallbacks == nil {
+		a.fallbacks = map[string]*fallbackModel{}
+	}
+	a.fallbacks[profile] = f
+
+	cfg, err := a.cfg.ModelProfile(profile)
+	if err != nil {
+		f.err = err
+		return f
+	}
+	f.provider = cfg.Provider
+	provider, err := newProvider(cfg)
+	if err != nil {
+		f.err = err
+		return f
+	}
+	g := genkit.Init(ctx, genkit.WithPlugins(provider.Plugins()...))
+	if f.model, f.err = provider.DefineModel(g); f.err != nil {
+		return f
+	}
+	if f.name() == a.ModelName() {
+		return nil
+	}
+	return f
+}
+
+// notify reports a retry or a switch to a fallback model to the stream.
+func (a *Agent) notify(text string) {
+	if a.stream != nil {
+		a.stream(StreamEvent{Kind: StreamRetry, Text: text})
+	}
+}
+
+// backoff returns the delay before retry number attempt+1: initial doubled
+// for every earlier retry, capped at max, with the upper half jittered so
+// that clients do not retry in lockstep.
+func backoff(attempt int, initial, max time.Duration) time.Duration {
+	delay := initial
+	for i := 0; i < attempt && (max <= 0 || delay < max); i++ {
+		delay *= 2
+	}
+	if max > 0 && delay > max {
+		delay = max
+	}
+	if half := int64(delay / 2); half > 0 {
+		delay = time.Duration(half + rand.Int63n(half+1))
+	}
+	return delay
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// ollamaStatus matches the status in the Ollama plugin's error messages,
+// which do not wrap a typed error.
+var ollamaStatus = regexp.MustCompile(`non-200 status: (\d{3})`)
+
+// retryable reports whether err is worth retrying and the delay the server
+// asked for, if any. Rate limits, server errors and failures to reach the
+// server are; errors in the request itself are not.
+func retryable(err error) (bool, time.Duration) {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false, 0
+	}
+
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		return retryableStatus(apiErr.StatusCode), apiErr.RetryAfter
+	}
+	var oaiErr *openai.Error
+	if errors.As(err, &oaiErr) {
+		var wait time.Duration
+		if oaiErr.Response != nil {
+			wait = retryAfter(oaiErr.Response.Header)
+		}
+		return retryableStatus(oaiErr.StatusCode), wait
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true, 0
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true, 0
+	}
+
+	msg := err.Error()
+	if m := ollamaStatus.FindStringSubmatch(msg); m != nil {
+		status, _ := strconv.Atoi(m[1])
+		return retryableStatus(status), 0
+	}
+	return strings.Contains(msg, "failed to send request") || strings.Contains(msg, "connection refused"), 0
+}
+
+// retryableStatus reports whether a request failing with an HTTP status
+// may succeed when sent again.
+func retryableStatus(status int) bool {
+	switch status {
+	case http.StatusRequestTimeout, http.StatusConflict, http.StatusTooManyRequests:
+		return true
+	}
+	return status >= 500
+}
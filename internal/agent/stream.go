@@ -0,0 +1,83 @@
+package agent
+
+import (
+	"context"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+type StreamKind int
+
+const (
+	// StreamText is a piece of the model's answer.
+	StreamText StreamKind = iota
+	// StreamReasoning is a piece of the model's reasoning, for models that
+	// report it separately from the answer.
+	StreamReasoning
+	// StreamToolStart reports that the model requested a tool call.
+	StreamToolStart
+	// StreamToolEnd reports a tool call's result.
+	StreamToolEnd
+	// StreamCompact reports that older turns are being summarized to fit
+	// the context window.
+	StreamCompact
+	// StreamRetry reports a failed model request that is being retried, or
+	// a switch to a fallback model.
+	StreamRetry
+)
+
+// StreamEvent is one incremental update from a generation in progress.
+type StreamEvent struct {
+	Kind       StreamKind
+	Text       string
+	ToolName   string
+	ToolInput  any
+	ToolOutput any
+}
+
+// StreamFunc receives stream events. It is called from the generating
+// goroutine, in order.
+type StreamFunc func(StreamEvent)
+
+// GenerateStream is Generate with incremental events delivered to onEvent.
+func (a *Agent) GenerateStream(ctx context.Context, userInput string, onEvent StreamFunc) (*Response, error) {
+	a.stream = onEvent
+	defer func() { a.stream = nil }()
+	return a.Generate(ctx, userInput)
+}
+
+// ResumeStream is Resume with incremental events delivered to onEvent.
+func (a *Agent) ResumeStream(ctx context.Context, decision Decision, onEvent StreamFunc) (*Response, error) {
+	a.stream = onEvent
+	defer func() { a.stream = nil }()
+	return a.Resume(ctx, decision)
+}
+
+// streamCallback translates genkit response chunks into stream events.
+func streamCallback(onEvent StreamFunc) ai.ModelStreamCallback {
+	return func(ctx context.Context, chunk *ai.ModelResponseChunk) error {
+		for _, part := range chunk.Content {
+			switch {
+			case part.IsToolResponse():
+				onEvent(StreamEvent{
+					Kind:       StreamToolEnd,
+					ToolName:   part.ToolResponse.Name,
+					ToolOutput: part.ToolResponse.Output,
+				})
+			case part.IsToolRequest():
+				onEvent(StreamEvent{
+					Kind:      StreamToolStart,
+					ToolName:  part.ToolRequest.Name,
+					ToolInput: part.ToolRequest.Input,
+				})
+			case part.IsReasoning():
+				onEvent(StreamEvent{Kind: StreamReasoning, Text: part.Text})
+			case part.IsText():
+				if part.Text != "" {
+					onEvent(StreamEvent{Kind: StreamText, Text: part.Text})
+				}
+			}
+		}
+		return nil
+	}
+}
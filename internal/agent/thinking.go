@@ -0,0 +1,65 @@
+package agent
+
+import "strings"
+
+// StripThinking removes <think>...</think> blocks emitted by reasoning models.
+func StripThinking(content string) string {
+	result := content
+	for {
+		start := strings.Index(result, "<think>")
+		if start == -1 {
+			break
+		}
+		end := strings.Index(result[start:], "</think>")
+		if end == -1 {
+			break
+		}
+		end += start + len("</think>")
+		result = result[:start] + result[end:]
+	}
+	return strings.TrimSpace(result)
+}
+
+// ExtractThinking returns the contents of all <think>...</think> blocks.
+func ExtractThinking(content string) string {
+	var thinking strings.Builder
+	temp := content
+	for {
+		start := strings.Index(temp, "<think>")
+		if start == -1 {
+			break
+		}
+		end := strings.Index(temp[start:], "</think>")
+		if end == -1 {
+			break
+		}
+		thinkContent := temp[start+len("<think>") : start+end]
+		if thinking.Len() > 0 {
+			thinking.WriteString("\n\n")
+		}
+		thinking.WriteString(strings.TrimSpace(thinkContent))
+		temp = temp[start+end+len("</think>"):]
+	}
+	return thinking.String()
+}
+
+// SplitThinking separates partial model output into its reasoning and its
+// answer. Unlike StripThinking it treats an unterminated <think> block as
+// reasoning still in progress, which is what streamed output looks like.
+func SplitThinking(content string) (thinking, answer string) {
+	closed := content
+	var open string
+	if start := strings.LastIndex(content, "<think>"); start != -1 && !strings.Contains(content[start:], "</think>") {
+		closed = content[:start]
+		open = strings.TrimSpace(content[start+len("<think>"):])
+	}
+
+	thinking = ExtractThinking(closed)
+	if open != "" {
+		if thinking != "" {
+			thinking += "\n\n"
+		}
+		thinking += open
+	}
+	return thinking, StripThinking(closed)
+}
@@ -0,0 +1,73 @@
+package agent
+
+import (
+	"github.com/firebase/genkit/go/ai"
+	"github.com/niradler/termu/internal/usage"
+)
+
+// recordUsage adds the tokens of one model request to the turn and the
+// session. Providers that do not report usage, such as Ollama, are
+// estimated from the length of the request and the reply.
+func (a *Agent) recordUsage(model string, req *ai.ModelRequest, resp *ai.ModelResponse) {
+	tokens := usage.Tokens{Requests: 1}
+	if resp.Usage != nil {
+		tokens.Input = resp.Usage.InputTokens
+		tokens.Output = resp.Usage.OutputTokens
+	}
+	if tokens.Input == 0 && tokens.Output == 0 {
+		tokens.Input = EstimateTokens(req.Messages)
+		if resp.Message != nil {
+			tokens.Output = EstimateTokens([]*ai.Message{resp.Message})
+		}
+		tokens.Estimated = true
+	}
+
+	a.stateMu.Lock()
+	defer a.stateMu.Unlock()
+	a.turnUsage.Add(model, tokens)
+	a.usage.Add(model, tokens)
+	a.contextUsed = tokens.Input
+}
+
+// Usage returns the tokens used in the session so far, by model.
+func (a *Agent) Usage() usage.Tally {
+	a.stateMu.Lock()
+	defer a.stateMu.Unlock()
+	return a.usage.Clone()
+}
+
+// SetUsage replaces the session's usage, for example with that of a
+// resumed session.
+func (a *Agent) SetUsage(tally usage.Tally) {
+	a.stateMu.Lock()
+	defer a.stateMu.Unlock()
+	a.usage = tally.Clone()
+}
+
+// turnTally returns the tokens used in the turn in progress.
+func (a *Agent) turnTally() usage.Tally {
+	a.stateMu.Lock()
+	defer a.stateMu.Unlock()
+	return a.turnUsage.Clone()
+}
+
+// resetContextUsed forgets the input of the latest request once the
+// conversation has been replaced, so ContextUsage estimates it again.
+func (a *Agent) resetContextUsed() {
+	a.stateMu.Lock()
+	defer a.stateMu.Unlock()
+	a.contextUsed = 0
+}
+
+// ContextUsage returns how many tokens the conversation takes up and the
+// size of the model's context window. The count is the input of the
+// latest model request, or an estimate before there is one.
+func (a *Agent) ContextUsage() (used, size int) {
+	a.stateMu.Lock()
+	used = a.contextUsed
+	a.stateMu.Unlock()
+	if used == 0 {
+		used = EstimateTokens(append([]*ai.Message{a.systemMessage()}, a.conversation.Messages()...))
+	}
+	return used, a.contextSize
+}
@@ -0,0 +1,314 @@
+// Package audit writes a JSON-lines log of everything the agent does:
+// prompts, model calls, tool calls, validation and approval decisions and
+// file changes.
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/niradler/termu/internal/config"
+)
+
+// Event types.
+const (
+	EventPrompt     = "prompt"
+	EventModelCall  = "model_call"
+	EventToolCall   = "tool_call"
+	EventValidation = "validation"
+	EventApproval   = "approval"
+	EventFileChange = "file_change"
+)
+
+// Levels, from least to most severe. LevelOff disables the log.
+const (
+	LevelDebug = "debug"
+	LevelInfo  = "info"
+	LevelWarn  = "warn"
+	LevelError = "error"
+	LevelOff   = "off"
+)
+
+// Defaults for rotation when the config leaves them unset.
+const (
+	defaultMaxSizeMB = 10
+	defaultMaxFiles  = 5
+)
+
+// Event is one line of the audit log. Only the fields that apply to its
+// Type are set.
+type Event struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Type    string    `json:"type"`
+	Session string    `json:"session,omitempty"`
+	Workdir string    `json:"workdir,omitempty"`
+
+	Prompt string `json:"prompt,omitempty"`
+
+	Provider     string `json:"provider,omitempty"`
+	Model        string `json:"model,omitempty"`
+	LatencyMS    int64  `json:"latency_ms,omitempty"`
+	InputTokens  int    `json:"input_tokens,omitempty"`
+	OutputTokens int    `json:"output_tokens,omitempty"`
+	FinishReason string `json:"finish_reason,omitempty"`
+
+	Tool       string `json:"tool,omitempty"`
+	Args       any    `json:"args,omitempty"`
+	Output     string `json:"output,omitempty"`
+	DurationMS int64  `json:"duration_ms,omitempty"`
+	ExitCode   *int   `json:"exit_code,omitempty"`
+	Redactions int    `json:"redactions,omitempty"`
+
+	Command       string `json:"command,omitempty"`
+	Allowed       *bool  `json:"allowed,omitempty"`
+	NeedsApproval bool   `json:"needs_approval,omitempty"`
+	Risk          string `json:"risk,omitempty"`
+	Reason        string `json:"reason,omitempty"`
+	Approved      *bool  `json:"approved,omitempty"`
+	EditedCommand string `json:"edited_command,omitempty"`
+
+	Path string `json:"path,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// Summary describes the event on one line.
+func (e Event) Summary() string {
+	var b strings.Builder
+	switch e.Type {
+	case EventPrompt:
+		fmt.Fprintf(&b, "%q", oneLine(e.Prompt, 100))
+	case EventModelCall:
+		fmt.Fprintf(&b, "%s/%s %dms", e.Provider, e.Model, e.LatencyMS)
+		if e.InputTokens > 0 || e.OutputTokens > 0 {
+			fmt.Fprintf(&b, " tokens in=%d out=%d", e.InputTokens, e.OutputTokens)
+		}
+		if e.FinishReason != "" {
+			fmt.Fprintf(&b, " (%s)", e.FinishReason)
+		}
+	case EventToolCall:
+		b.WriteString(e.Tool)
+		if e.Command != "" {
+			b.WriteString(" $ " + oneLine(e.Command, 100))
+		} else if args, err := json.Marshal(e.Args); err == nil && e.Args != nil {
+			b.WriteString(" " + oneLine(string(args), 100))
+		}
+		fmt.Fprintf(&b, " %dms", e.DurationMS)
+		if e.ExitCode != nil {
+			fmt.Fprintf(&b, " exit=%d", *e.ExitCode)
+		}
+		if e.Redactions > 0 {
+			fmt.Fprintf(&b, " redacted=%d", e.Redactions)
+		}
+	case EventValidation:
+		verdict := "allowed"
+		switch {
+		case e.Allowed != nil && !*e.Allowed:
+			verdict = "blocked"
+		case e.NeedsApproval:
+			verdict = "needs approval"
+		}
+		fmt.Fprintf(&b, "%s risk=%s $ %s", verdict, e.Risk, oneLine(e.Command, 100))
+	case EventApproval:
+		verdict := "rejected"
+		if e.Approved != nil && *e.Approved {
+			verdict = "approved"
+		}
+		fmt.Fprintf(&b, "%s $ %s", verdict, oneLine(e.Command, 100))
+		if e.EditedCommand != "" {
+			b.WriteString(" → " + oneLine(e.EditedCommand, 100))
+		}
+	case EventFileChange:
+		fmt.Fprintf(&b, "%s via %s", e.Path, e.Tool)
+	}
+	if e.Reason != "" {
+		b.WriteString(" — " + oneLine(e.Reason, 100))
+	}
+	if e.Error != "" {
+		b.WriteString(" error: " + oneLine(e.Error, 100))
+	}
+	return b.String()
+}
+
+func oneLine(s string, maxLen int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if runes := []rune(s); len(runes) > maxLen {
+		return string(runes[:maxLen-1]) + "…"
+	}
+	return s
+}
+
+// Logger appends events to the audit log, rotating it when it grows past
+// its size limit. A nil Logger discards events, so callers need not check
+// whether logging is enabled.
+type Logger struct {
+	mu       sync.Mutex
+	path     string
+	file     *os.File
+	size     int64
+	maxSize  int64
+	maxFiles int
+	debug    bool
+	// level is the rank of the least severe level written.
+	level   int
+	session string
+	workdir string
+}
+
+// Open opens the log configured by cfg for events from workdir. It returns
+// a nil Logger when logging is off.
+func Open(cfg config.LoggingConfig, workdir string) (*Logger, error) {
+	if cfg.File == "" || strings.EqualFold(cfg.Level, LevelOff) {
+		return nil, nil
+	}
+
+	maxSize := cfg.MaxSizeMB
+	if maxSize <= 0 {
+		maxSize = defaultMaxSizeMB
+	}
+	maxFiles := cfg.MaxFiles
+	if maxFiles <= 0 {
+		maxFiles = defaultMaxFiles
+	}
+
+	l := &Logger{
+		path:     Path(cfg),
+		maxSize:  int64(maxSize) << 20,
+		maxFiles: maxFiles,
+		debug:    strings.EqualFold(cfg.Level, LevelDebug),
+		level:    levelRank[LevelInfo],
+		workdir:  workdir,
+	}
+	if rank, ok := levelRank[strings.ToLower(cfg.Level)]; ok {
+		l.level = rank
+	}
+	if err := os.MkdirAll(filepath.Dir(l.path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Path returns the log file configured by cfg, with ~ expanded.
+func Path(cfg config.LoggingConfig) string {
+	path := cfg.File
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		home, _ := os.UserHomeDir()
+		path = filepath.Join(home, path[1:])
+	}
+	return path
+}
+
+func (l *Logger) open() error {
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	l.file, l.size = file, info.Size()
+	return nil
+}
+
+// SetSession tags the following events with a session ID.
+func (l *Logger) SetSession(id string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.session = id
+}
+
+// Debug reports whether events should carry tool output as well.
+func (l *Logger) Debug() bool {
+	return l != nil && l.debug
+}
+
+// Log appends event, filling in its time, level, session and workdir when
+// they are unset, and drops it when it is below the configured level.
+// Failures to write are ignored: the log must never stop the agent.
+func (l *Logger) Log(event Event) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	if event.Level == "" {
+		event.Level = LevelInfo
+		if event.Error != "" {
+			event.Level = LevelError
+		}
+	}
+	if levelRank[event.Level] < l.level {
+		return
+	}
+	if event.Session == "" {
+		event.Session = l.session
+	}
+	if event.Workdir == "" {
+		event.Workdir = l.workdir
+	}
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+
+	if l.file == nil {
+		return
+	}
+	if l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		l.rotate()
+		if l.file == nil {
+			return
+		}
+	}
+	n, _ := l.file.Write(line)
+	l.size += int64(n)
+}
+
+// rotate renames the log to <path>.1, shifting older files up and
+// dropping the oldest, and starts a new one.
+func (l *Logger) rotate() {
+	l.file.Close()
+	l.file = nil
+
+	os.Remove(fmt.Sprintf("%s.%d", l.path, l.maxFiles))
+	for i := l.maxFiles - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", l.path, i), fmt.Sprintf("%s.%d", l.path, i+1))
+	}
+	os.Rename(l.path, l.path+".1")
+	l.open()
+}
+
+// Close closes the log file.
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
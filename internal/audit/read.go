@@ -0,0 +1,181 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// followInterval is how often Follow checks the log for new events.
+const followInterval = 500 * time.Millisecond
+
+var levelRank = map[string]int{LevelDebug: 0, LevelInfo: 1, LevelWarn: 2, LevelError: 3}
+
+// Filter selects events from the log. Unset fields match everything.
+type Filter struct {
+	Types   []string
+	Session string
+	// Level is the least severe level shown.
+	Level string
+	Since time.Time
+	// Text must appear somewhere in the event's JSON.
+	Text string
+}
+
+// Match reports whether the event, decoded from line, passes the filter.
+func (f Filter) Match(event Event, line []byte) bool {
+	if len(f.Types) > 0 && !contains(f.Types, event.Type) {
+		return false
+	}
+	if f.Session != "" && !strings.HasPrefix(event.Session, f.Session) {
+		return false
+	}
+	if f.Level != "" && levelRank[event.Level] < levelRank[strings.ToLower(f.Level)] {
+		return false
+	}
+	if !f.Since.IsZero() && event.Time.Before(f.Since) {
+		return false
+	}
+	if f.Text != "" && !bytes.Contains(bytes.ToLower(line), []byte(strings.ToLower(f.Text))) {
+		return false
+	}
+	return true
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Files returns the log at path and its rotated files, oldest first.
+func Files(path string) []string {
+	matches, _ := filepath.Glob(path + ".*")
+	var rotated []int
+	for _, match := range matches {
+		if n, err := strconv.Atoi(strings.TrimPrefix(match, path+".")); err == nil {
+			rotated = append(rotated, n)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(rotated)))
+
+	var files []string
+	for _, n := range rotated {
+		files = append(files, path+"."+strconv.Itoa(n))
+	}
+	if _, err := os.Stat(path); err == nil {
+		files = append(files, path)
+	}
+	return files
+}
+
+// Read returns the last limit events matching filter, oldest first, from
+// the log at path and its rotated files. A limit of 0 returns them all.
+func Read(path string, filter Filter, limit int) ([]Event, error) {
+	var events []Event
+	for _, file := range Files(path) {
+		f, err := os.Open(file)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		_, err = scan(f, filter, func(event Event) {
+			events = append(events, event)
+			if limit > 0 && len(events) > 2*limit {
+				events = append(events[:0], events[len(events)-limit:]...)
+			}
+		})
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if limit > 0 && len(events) > limit {
+		events = events[len(events)-limit:]
+	}
+	return events, nil
+}
+
+// Follow calls fn for every event matching filter appended to the log at
+// path from now on, until ctx is done. It keeps following the log across
+// rotation.
+func Follow(ctx context.Context, path string, filter Filter, fn func(Event)) error {
+	var offset int64
+	if info, err := os.Stat(path); err == nil {
+		offset = info.Size()
+	}
+
+	ticker := time.NewTicker(followInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if info.Size() < offset {
+			// Rotated: the new file starts from the beginning.
+			offset = 0
+		}
+		if info.Size() == offset {
+			continue
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		if _, err := f.Seek(offset, io.SeekStart); err == nil {
+			n, _ := scan(f, filter, fn)
+			offset += n
+		}
+		f.Close()
+	}
+}
+
+// scan decodes the complete lines of r, calling fn for those matching
+// filter, and returns how many bytes it consumed. Lines that are not
+// events are skipped.
+func scan(r io.Reader, filter Filter, fn func(Event)) (int64, error) {
+	reader := bufio.NewReader(r)
+	var consumed int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// A partial line is still being written; read it next time.
+			return consumed, nil
+		}
+		if err != nil {
+			return consumed, err
+		}
+		consumed += int64(len(line))
+
+		var event Event
+		if json.Unmarshal(line, &event) != nil {
+			continue
+		}
+		if filter.Match(event, line) {
+			fn(event)
+		}
+	}
+}
@@ -0,0 +1,318 @@
+package checkpoint
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/niradler/termu/internal/tools"
+)
+
+// ErrNoCheckpoints is returned when there is nothing to restore.
+var ErrNoCheckpoints = errors.New("no checkpoints")
+
+// Checkpoint holds the content files had before the agent first changed
+// them during one user turn.
+type Checkpoint struct {
+	ID        int        `json:"id"`
+	Label     string     `json:"label"`
+	CreatedAt time.Time  `json:"created_at"`
+	Files     []Snapshot `json:"files"`
+}
+
+// Snapshot is a file's state before a checkpointed change. Content is kept
+// in the store's blob directory under its SHA-256.
+type Snapshot struct {
+	Path    string      `json:"path"`
+	Existed bool        `json:"existed"`
+	Blob    string      `json:"blob,omitempty"`
+	Mode    fs.FileMode `json:"mode,omitempty"`
+}
+
+// Store keeps the checkpoints of one session in a directory: an index.json
+// listing them and a blobs/ directory of prior file contents. It does not
+// rely on git, so it works in any working directory.
+type Store struct {
+	mu          sync.Mutex
+	dir         string
+	checkpoints []Checkpoint
+	// label is the turn the next snapshot belongs to; open reports whether
+	// that turn already has a checkpoint.
+	label string
+	open  bool
+}
+
+// Open loads the checkpoint store in dir, which is created on first write.
+func Open(dir string) (*Store, error) {
+	s := &Store{dir: dir}
+
+	data, err := os.ReadFile(s.indexPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoints: %w", err)
+	}
+	if err := json.Unmarshal(data, &s.checkpoints); err != nil {
+		return nil, fmt.Errorf("failed to decode checkpoints: %w", err)
+	}
+	return s, nil
+}
+
+// Begin starts a new turn. Its checkpoint is created by the first snapshot,
+// so turns that change no files leave no checkpoint behind.
+func (s *Store) Begin(label string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.label = label
+	s.open = false
+}
+
+// Snapshot saves path's current content into the current turn's checkpoint
+// unless the turn already saved it.
+func (s *Store) Snapshot(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.open {
+		id := 1
+		if n := len(s.checkpoints); n > 0 {
+			id = s.checkpoints[n-1].ID + 1
+		}
+		s.checkpoints = append(s.checkpoints, Checkpoint{ID: id, Label: s.label, CreatedAt: time.Now()})
+		s.open = true
+	}
+
+	current := &s.checkpoints[len(s.checkpoints)-1]
+	for _, snap := range current.Files {
+		if snap.Path == path {
+			return nil
+		}
+	}
+
+	snap := Snapshot{Path: path}
+	info, err := os.Stat(path)
+	switch {
+	case err == nil:
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		blob, err := s.writeBlob(data)
+		if err != nil {
+			return err
+		}
+		snap.Existed = true
+		snap.Blob = blob
+		snap.Mode = info.Mode().Perm()
+	case !errors.Is(err, fs.ErrNotExist):
+		return fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+
+	current.Files = append(current.Files, snap)
+	return s.saveIndex()
+}
+
+// List returns the checkpoints, oldest first.
+func (s *Store) List() []Checkpoint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Checkpoint(nil), s.checkpoints...)
+}
+
+// Undo restores the files changed by the latest checkpoint.
+func (s *Store) Undo() (*Checkpoint, []string, error) {
+	s.mu.Lock()
+	n := len(s.checkpoints)
+	s.mu.Unlock()
+	if n == 0 {
+		return nil, nil, ErrNoCheckpoints
+	}
+
+	last := s.List()[n-1]
+	restored, err := s.Restore(last.ID)
+	return &last, restored, err
+}
+
+// Restore puts every file changed in checkpoint id or later back to its
+// state before checkpoint id, then drops those checkpoints. It returns the
+// restored paths.
+func (s *Store) Restore(id int) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index := -1
+	for i, cp := range s.checkpoints {
+		if cp.ID == id {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return nil, fmt.Errorf("checkpoint %d not found", id)
+	}
+
+	// Later checkpoints are undone first so each file ends up with the
+	// content saved by the earliest checkpoint that touched it.
+	restored := make(map[string]bool)
+	for i := len(s.checkpoints) - 1; i >= index; i-- {
+		for _, snap := range s.checkpoints[i].Files {
+			if err := s.restoreFile(snap); err != nil {
+				return nil, err
+			}
+			restored[snap.Path] = true
+		}
+	}
+
+	s.checkpoints = s.checkpoints[:index]
+	s.open = false
+	if err := s.saveIndex(); err != nil {
+		return nil, err
+	}
+	s.pruneBlobs()
+
+	paths := make([]string, 0, len(restored))
+	for path := range restored {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+func (s *Store) restoreFile(snap Snapshot) error {
+	if !snap.Existed {
+		if err := os.Remove(snap.Path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to remove %s: %w", snap.Path, err)
+		}
+		return nil
+	}
+
+	data, err := os.ReadFile(s.blobPath(snap.Blob))
+	if err != nil {
+		return fmt.Errorf("failed to read checkpoint of %s: %w", snap.Path, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(snap.Path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", snap.Path, err)
+	}
+	if err := os.WriteFile(snap.Path, data, snap.Mode); err != nil {
+		return fmt.Errorf("failed to restore %s: %w", snap.Path, err)
+	}
+	return os.Chmod(snap.Path, snap.Mode)
+}
+
+func (s *Store) writeBlob(data []byte) (string, error) {
+	sum := sha256.Sum256(data)
+	blob := hex.EncodeToString(sum[:])
+
+	path := s.blobPath(blob)
+	if _, err := os.Stat(path); err == nil {
+		return blob, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", fmt.Errorf("failed to create checkpoint directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return "", fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	return blob, nil
+}
+
+// pruneBlobs removes blobs no remaining checkpoint refers to.
+func (s *Store) pruneBlobs() {
+	used := make(map[string]bool)
+	for _, cp := range s.checkpoints {
+		for _, snap := range cp.Files {
+			used[snap.Blob] = true
+		}
+	}
+
+	entries, err := os.ReadDir(filepath.Join(s.dir, "blobs"))
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if !used[entry.Name()] {
+			_ = os.Remove(filepath.Join(s.dir, "blobs", entry.Name()))
+		}
+	}
+}
+
+func (s *Store) saveIndex() error {
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return fmt.Errorf("failed to create checkpoint directory: %w", err)
+	}
+	data, err := json.MarshalIndent(s.checkpoints, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode checkpoints: %w", err)
+	}
+	tmp := s.indexPath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write checkpoints: %w", err)
+	}
+	return os.Rename(tmp, s.indexPath())
+}
+
+func (s *Store) indexPath() string {
+	return filepath.Join(s.dir, "index.json")
+}
+
+func (s *Store) blobPath(blob string) string {
+	return filepath.Join(s.dir, "blobs", blob)
+}
+
+// Track returns a FileStore that snapshots each file into the store before
+// files overwrites it.
+func (s *Store) Track(files tools.FileStore) tools.FileStore {
+	return trackedStore{FileStore: files, checkpoints: s}
+}
+
+type trackedStore struct {
+	tools.FileStore
+	checkpoints *Store
+}
+
+func (t trackedStore) WriteFile(path string, data []byte) error {
+	if err := t.checkpoints.Snapshot(path); err != nil {
+		return fmt.Errorf("failed to checkpoint %s: %w", path, err)
+	}
+	return t.FileStore.WriteFile(path, data)
+}
+
+// Sets returns the IDs of the checkpoint stores under root, most recently
+// changed first.
+func Sets(root string) ([]string, error) {
+	entries, err := os.ReadDir(root)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint directory: %w", err)
+	}
+
+	modified := make(map[string]time.Time)
+	var ids []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		info, err := os.Stat(filepath.Join(root, entry.Name(), "index.json"))
+		if err != nil {
+			continue
+		}
+		ids = append(ids, entry.Name())
+		modified[entry.Name()] = info.ModTime()
+	}
+
+	sort.Slice(ids, func(i, j int) bool {
+		return modified[ids[i]].After(modified[ids[j]])
+	})
+	return ids, nil
+}
@@ -1,28 +1,84 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/niradler/termu/internal/tools"
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	Model    ModelConfig    `yaml:"model"`
-	Security SecurityConfig `yaml:"security"`
-	Tools    ToolsConfig    `yaml:"tools"`
-	Logging  LoggingConfig  `yaml:"logging"`
-	Workdir  string         `yaml:"-"`
+	Model    ModelConfig            `yaml:"model"`
+	Models   map[string]ModelConfig `yaml:"models"`   // Named model profiles, chosen with --model or /model
+	Fallback []string               `yaml:"fallback"` // Model profiles tried in order when the model in use fails
+	Retry    RetryConfig            `yaml:"retry"`
+	Pricing  map[string]Price       `yaml:"pricing"` // Model prices, keyed by provider/name or model name
+	Security SecurityConfig         `yaml:"security"`
+	Tools    ToolsConfig            `yaml:"tools"`
+	Logging  LoggingConfig          `yaml:"logging"`
+	Workdir  string                 `yaml:"-"`
+	// Project is the directory approvals, the policy file, sessions and
+	// audit entries belong to when it differs from Workdir, as it does
+	// while working in a scratch copy. See ProjectDir.
+	Project string `yaml:"-"`
+
+	// Profile is the model profile in use, set by UseModel; "" means the
+	// model section itself.
+	Profile string `yaml:"-"`
+	// baseModel keeps the model section once a profile replaces Model.
+	baseModel *ModelConfig
 }
 
+// DefaultProfile names the model section when choosing a model profile.
+const DefaultProfile = "default"
+
 type ModelConfig struct {
 	Provider string `yaml:"provider"`
 	Name     string `yaml:"name"`
 	Server   string `yaml:"server"`
 	Timeout  int    `yaml:"timeout"`
 	APIKey   string `yaml:"api_key"`  // API key for OpenAI-compatible providers
-	BaseURL  string `yaml:"base_url"` // Base URL for custom OpenAI-compatible endpoints
+	BaseURL  string `yaml:"base_url"` // Base URL for custom OpenAI-compatible endpoints, or to override the Anthropic and Gemini APIs
+
+	MaxTokens int `yaml:"max_tokens"` // Longest response, in tokens; 0 uses the provider's default
+
+	ContextSize      int     `yaml:"context_size"`      // Context window of the model, in tokens
+	CompactThreshold float64 `yaml:"compact_threshold"` // Fraction of the context window at which history is compacted
+}
+
+// RetryConfig controls how model requests failing with a transient error,
+// such as a rate limit or an unreachable server, are retried. The delay
+// doubles with every retry, with jitter, unless the server asks for a
+// specific delay with Retry-After.
+type RetryConfig struct {
+	MaxRetries   int     `yaml:"max_retries"`   // Retries before giving up on a model; 0 disables
+	InitialDelay float64 `yaml:"initial_delay"` // Seconds before the first retry
+	MaxDelay     float64 `yaml:"max_delay"`     // Longest wait in seconds; a longer Retry-After gives up on the model
+}
+
+// Price is what a model charges, in dollars per million tokens.
+type Price struct {
+	Input  float64 `yaml:"input"`
+	Output float64 `yaml:"output"`
+}
+
+// PriceOf returns the price of a model given as provider/name, looked up
+// by that key first and then by the model name alone.
+func (c *Config) PriceOf(model string) (Price, bool) {
+	if price, ok := c.Pricing[model]; ok {
+		return price, true
+	}
+	if _, name, ok := strings.Cut(model, "/"); ok {
+		price, ok := c.Pricing[name]
+		return price, ok
+	}
+	return Price{}, false
 }
 
 type SecurityConfig struct {
@@ -32,8 +88,57 @@ type SecurityConfig struct {
 	HighRiskCommands  []string `yaml:"high_risk_commands"`
 	BlockedPatterns   []string `yaml:"blocked_patterns"`
 	SandboxMode       bool     `yaml:"sandbox_mode"`
+	ScratchMode       bool     `yaml:"scratch_mode"` // Work in a temporary copy of the working directory
+	Isolation         string   `yaml:"isolation"`    // none, auto or linux; see shell.Executor.Isolate
 	AlwaysApprove     bool     `yaml:"always_approve"`
 	MaxToolIterations int      `yaml:"max_tool_iterations"`
+
+	CommandTimeout  int            `yaml:"command_timeout"`  // Seconds a command may run before it is killed; 0 disables
+	CommandTimeouts map[string]int `yaml:"command_timeouts"` // Timeout overrides in seconds, keyed by command name
+	MaxOutputBytes  int            `yaml:"max_output_bytes"` // Output returned to the model; longer output is cut to its start and end
+
+	Environment    EnvironmentConfig `yaml:"environment"`     // Environment variables commands inherit
+	RedactSecrets  bool              `yaml:"redact_secrets"`  // Mask secrets in tool output before it reaches the model
+	RedactPatterns []string          `yaml:"redact_patterns"` // Extra regular expressions for secrets to mask
+
+	// CommandPolicies refine allowed commands by subcommand, flag and
+	// argument, keyed by command name.
+	CommandPolicies map[string]CommandPolicy `yaml:"command_policies"`
+
+	// PolicyFile is a file of CEL rules deciding on commands and file
+	// operations, relative to the working directory unless absolute.
+	PolicyFile string `yaml:"policy_file"`
+}
+
+// EnvironmentConfig filters the environment commands inherit from termu.
+// Names are globs matched case-insensitively; a variable is passed when it
+// matches Allow, or Allow is empty, and does not match Deny.
+type EnvironmentConfig struct {
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+}
+
+// CommandPolicy restricts how one command may be used. Rules are checked
+// first, in order, and the first matching rule decides; the subcommand and
+// flag lists apply when no rule matches.
+type CommandPolicy struct {
+	AllowedSubcommands []string     `yaml:"allowed_subcommands"` // If set, only these subcommands may run
+	DeniedSubcommands  []string     `yaml:"denied_subcommands"`
+	AllowedFlags       []string     `yaml:"allowed_flags"` // If set, only these flags may be used
+	DeniedFlags        []string     `yaml:"denied_flags"`
+	Rules              []PolicyRule `yaml:"rules"`
+}
+
+// PolicyRule matches a command invocation and decides what happens to it.
+// Every condition that is set must match.
+type PolicyRule struct {
+	Name       string   `yaml:"name"`
+	Subcommand string   `yaml:"subcommand"` // Glob for the first non-flag argument
+	Flags      []string `yaml:"flags"`      // Matches when any of these flags is present
+	Args       []string `yaml:"args"`       // Globs that must each match some argument
+	Action     string   `yaml:"action"`     // "allow", "approve" or "deny"
+	Risk       string   `yaml:"risk"`       // "low", "medium", "high" or "critical"
+	Reason     string   `yaml:"reason"`
 }
 
 type ToolsConfig struct {
@@ -41,9 +146,14 @@ type ToolsConfig struct {
 	PreferModern bool `yaml:"prefer_modern"`
 }
 
+// LoggingConfig controls the JSON-lines audit log. Level is debug (tool
+// output included), info, warn, error or off; events below it are not
+// written.
 type LoggingConfig struct {
-	Level string `yaml:"level"`
-	File  string `yaml:"file"`
+	Level     string `yaml:"level"`
+	File      string `yaml:"file"`
+	MaxSizeMB int    `yaml:"max_size_mb"` // Size at which the log is rotated
+	MaxFiles  int    `yaml:"max_files"`   // Rotated logs kept
 }
 
 func DefaultConfig() *Config {
@@ -54,6 +164,14 @@ func DefaultConfig() *Config {
 			Name:     "qwen3",
 			Server:   "http://localhost:11434",
 			Timeout:  60,
+
+			ContextSize:      8192,
+			CompactThreshold: 0.8,
+		},
+		Retry: RetryConfig{
+			MaxRetries:   3,
+			InitialDelay: 1,
+			MaxDelay:     30,
 		},
 		Security: SecurityConfig{
 			AllowedCommands: tools.GetDefaultAllowedCommands(),
@@ -69,21 +187,133 @@ func DefaultConfig() *Config {
 				"rm -rf /", "rm -rf *", ":(){ :|:& };:", "mkfs", "dd if=",
 			},
 			SandboxMode:       false,
+			Isolation:         "none",
 			AlwaysApprove:     false,
 			MaxToolIterations: 5,
+			CommandTimeout:    120,
+			MaxOutputBytes:    32 * 1024,
+			Environment: EnvironmentConfig{
+				Deny: []string{
+					"*API_KEY*", "*APIKEY*", "*_TOKEN", "*SECRET*", "*PASSWORD*", "*PASSWD*",
+					"*_CREDENTIALS", "*PRIVATE_KEY*", "*_AUTH",
+				},
+			},
+			RedactSecrets: true,
+			CommandPolicies: map[string]CommandPolicy{
+				"git": {
+					Rules: []PolicyRule{
+						{Name: "force-push", Subcommand: "push", Flags: []string{"--force", "-f", "--force-with-lease", "--mirror", "--delete"}, Action: "deny", Reason: "rewrites or deletes remote history"},
+						{Name: "force-push-refspec", Subcommand: "push", Args: []string{"+*"}, Action: "deny", Reason: "rewrites remote history"},
+						{Name: "delete-refspec", Subcommand: "push", Args: []string{":?*"}, Action: "deny", Reason: "deletes a remote branch or tag"},
+						{Name: "push", Subcommand: "push", Action: "approve", Risk: "high", Reason: "publishes commits to a remote"},
+						{Name: "discard-changes", Subcommand: "reset", Flags: []string{"--hard"}, Action: "approve", Risk: "high", Reason: "discards uncommitted changes"},
+						{Name: "checkout-discard", Subcommand: "checkout", Args: []string{"--"}, Action: "approve", Risk: "high", Reason: "discards uncommitted changes"},
+					},
+					DeniedSubcommands: []string{"clean", "filter-branch"},
+				},
+				"find": {
+					DeniedFlags: []string{"-delete", "-exec", "-execdir", "-ok", "-okdir", "-fprint", "-fprintf", "-fls"},
+				},
+			},
 		},
 		Tools: ToolsConfig{
 			AutoInstall:  false,
 			PreferModern: true,
 		},
 		Logging: LoggingConfig{
-			Level: "info",
-			File:  "~/.termu/logs/termu.log",
+			Level:     "info",
+			File:      "~/.termu/logs/termu.log",
+			MaxSizeMB: 10,
+			MaxFiles:  5,
 		},
 		Workdir: workdir,
 	}
 }
 
+// ModelProfiles returns the names of the model profiles, sorted, starting
+// with DefaultProfile unless a profile is named that.
+func (c *Config) ModelProfiles() []string {
+	names := make([]string, 0, len(c.Models)+1)
+	for name := range c.Models {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if _, ok := c.Models[DefaultProfile]; !ok {
+		names = append([]string{DefaultProfile}, names...)
+	}
+	return names
+}
+
+// ModelProfile returns the named model profile. Settings the profile
+// leaves unset come from the model section; its server, API key and base
+// URL only when the profile uses the same provider.
+func (c *Config) ModelProfile(name string) (ModelConfig, error) {
+	base := c.Model
+	if c.baseModel != nil {
+		base = *c.baseModel
+	}
+
+	profile, ok := c.Models[name]
+	if !ok {
+		if name == DefaultProfile || name == "" {
+			return base, nil
+		}
+		return ModelConfig{}, fmt.Errorf("unknown model profile %q (available: %s)", name, strings.Join(c.ModelProfiles(), ", "))
+	}
+
+	if profile.Provider == "" {
+		profile.Provider = base.Provider
+	}
+	if profile.Provider == base.Provider {
+		if profile.Name == "" {
+			profile.Name = base.Name
+		}
+		if profile.Server == "" {
+			profile.Server = base.Server
+		}
+		if profile.APIKey == "" {
+			profile.APIKey = base.APIKey
+		}
+		if profile.BaseURL == "" {
+			profile.BaseURL = base.BaseURL
+		}
+	}
+	if profile.Server == "" && profile.Provider == "ollama" {
+		profile.Server = DefaultConfig().Model.Server
+	}
+	if profile.Timeout == 0 {
+		profile.Timeout = base.Timeout
+	}
+	if profile.ContextSize == 0 {
+		profile.ContextSize = base.ContextSize
+	}
+	if profile.CompactThreshold == 0 {
+		profile.CompactThreshold = base.CompactThreshold
+	}
+	if profile.Name == "" {
+		return ModelConfig{}, fmt.Errorf("model profile %q has no model name", name)
+	}
+	return profile, nil
+}
+
+// UseModel makes the named model profile the model in use.
+func (c *Config) UseModel(name string) error {
+	profile, err := c.ModelProfile(name)
+	if err != nil {
+		return err
+	}
+	if c.baseModel == nil {
+		base := c.Model
+		c.baseModel = &base
+	}
+	c.Model = profile
+	c.Profile = name
+	if _, ok := c.Models[name]; !ok {
+		c.Profile = ""
+	}
+	return nil
+}
+
 func Load(path string) (*Config, error) {
 	if path == "" {
 		path = findConfigFile()
@@ -102,10 +332,36 @@ func Load(path string) (*Config, error) {
 	if err := yaml.Unmarshal(data, cfg); err != nil {
 		return nil, err
 	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
 
 	return cfg, nil
 }
 
+// validate rejects settings that would otherwise be silently ignored.
+func (c *Config) validate() error {
+	for name, policy := range c.Security.CommandPolicies {
+		for i, rule := range policy.Rules {
+			label := rule.Name
+			if label == "" {
+				label = fmt.Sprintf("rule %d", i+1)
+			}
+			switch rule.Action {
+			case "", "allow", "approve", "deny":
+			default:
+				return fmt.Errorf("command_policies.%s %s: unknown action %q (use allow, approve or deny)", name, label, rule.Action)
+			}
+			switch strings.ToLower(rule.Risk) {
+			case "", "low", "medium", "high", "critical":
+			default:
+				return fmt.Errorf("command_policies.%s %s: unknown risk %q (use low, medium, high or critical)", name, label, rule.Risk)
+			}
+		}
+	}
+	return nil
+}
+
 func findConfigFile() string {
 	candidates := []string{
 		".termu.yaml",
@@ -129,6 +385,31 @@ func findConfigFile() string {
 	return ""
 }
 
+// ProjectDir returns the directory of the project termu works on: Project
+// when set, otherwise Workdir.
+func (c *Config) ProjectDir() string {
+	if c.Project != "" {
+		return c.Project
+	}
+	return c.Workdir
+}
+
+// WorkdirKey names the folders termu keeps per working directory under
+// ~/.termu: the directory's base name for readability plus a hash of its
+// full path to keep folders apart.
+func WorkdirKey(workdir string) string {
+	abs, err := filepath.Abs(workdir)
+	if err != nil {
+		abs = workdir
+	}
+	sum := sha256.Sum256([]byte(abs))
+	base := filepath.Base(abs)
+	if base == string(filepath.Separator) || base == "." {
+		base = "root"
+	}
+	return base + "-" + hex.EncodeToString(sum[:4])
+}
+
 func fileExists(path string) bool {
 	_, err := os.Stat(path)
 	return err == nil
@@ -0,0 +1,256 @@
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/niradler/termu/internal/diff"
+)
+
+// FileChange is a file change held back for review: a write recorded by
+// sandbox mode, or a difference between a scratch workspace and the tree.
+type FileChange struct {
+	Path     string
+	Existed  bool
+	Original string
+	Content  string
+	// Deleted is set when applying the change removes the file.
+	Deleted bool
+	// Mode is the mode to write the file with; zero keeps the existing
+	// mode, or 0644 for a new file.
+	Mode fs.FileMode
+}
+
+// Status describes the change as new, modified or deleted.
+func (f FileChange) Status() string {
+	switch {
+	case f.Deleted:
+		return "deleted"
+	case !f.Existed:
+		return "new"
+	default:
+		return "modified"
+	}
+}
+
+// Stats counts the lines the change adds and removes.
+func (f FileChange) Stats() (added, removed int) {
+	if isBinary(f.Original) || isBinary(f.Content) {
+		return 0, 0
+	}
+	return diff.Stats(f.Original, f.Content)
+}
+
+// diffText returns the unified diff of the change, naming the file by
+// rel.
+func (f FileChange) diffText(rel string) string {
+	oldName, newName := "a/"+rel, "b/"+rel
+	if !f.Existed {
+		oldName = "/dev/null"
+	}
+	if f.Deleted {
+		newName = "/dev/null"
+	}
+	if isBinary(f.Original) || isBinary(f.Content) {
+		return fmt.Sprintf("Binary files %s and %s differ\n", oldName, newName)
+	}
+	return diff.Unified(oldName, newName, f.Original, f.Content)
+}
+
+// apply writes the change to disk.
+func (f FileChange) apply() error {
+	if f.Deleted {
+		if err := os.Remove(f.Path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to remove %s: %w", f.Path, err)
+		}
+		return nil
+	}
+
+	mode := f.Mode
+	if mode == 0 {
+		mode = 0644
+		if info, err := os.Stat(f.Path); err == nil {
+			mode = info.Mode().Perm()
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(f.Path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", f.Path, err)
+	}
+	if err := os.WriteFile(f.Path, []byte(f.Content), mode); err != nil {
+		return fmt.Errorf("failed to write %s: %w", f.Path, err)
+	}
+	return os.Chmod(f.Path, mode)
+}
+
+// isBinary reports whether content looks like a binary file: it has a NUL
+// byte early on, as git checks.
+func isBinary(content string) bool {
+	if len(content) > 8000 {
+		content = content[:8000]
+	}
+	return strings.IndexByte(content, 0) >= 0
+}
+
+// Changeset records the file writes and commands a sandboxed session
+// intended to perform so they can be reviewed, then applied or discarded.
+// It implements tools.FileStore, overlaying recorded writes on the disk so
+// the agent reads back its own changes.
+type Changeset struct {
+	mu       sync.Mutex
+	workdir  string
+	files    map[string]*FileChange
+	order    []string
+	commands []string
+}
+
+func New(workdir string) *Changeset {
+	if real, err := filepath.EvalSymlinks(workdir); err == nil {
+		workdir = real
+	}
+	return &Changeset{
+		workdir: workdir,
+		files:   make(map[string]*FileChange),
+	}
+}
+
+func (c *Changeset) ReadFile(path string) ([]byte, error) {
+	c.mu.Lock()
+	change, ok := c.files[path]
+	c.mu.Unlock()
+
+	if ok {
+		return []byte(change.Content), nil
+	}
+	return os.ReadFile(path)
+}
+
+func (c *Changeset) WriteFile(path string, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if change, ok := c.files[path]; ok {
+		change.Content = string(data)
+		return nil
+	}
+
+	change := &FileChange{Path: path, Content: string(data)}
+	original, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		change.Existed = true
+		change.Original = string(original)
+	case !errors.Is(err, fs.ErrNotExist):
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	c.files[path] = change
+	c.order = append(c.order, path)
+	return nil
+}
+
+// RecordCommand implements shell.CommandRecorder.
+func (c *Changeset) RecordCommand(command string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.commands = append(c.commands, command)
+}
+
+// Files returns the recorded file changes in the order they were first made.
+func (c *Changeset) Files() []FileChange {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	files := make([]FileChange, 0, len(c.order))
+	for _, path := range c.order {
+		files = append(files, *c.files[path])
+	}
+	return files
+}
+
+func (c *Changeset) Commands() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.commands...)
+}
+
+func (c *Changeset) Empty() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.order) == 0 && len(c.commands) == 0
+}
+
+// Diff returns a unified diff of every recorded file change.
+func (c *Changeset) Diff() string {
+	var b strings.Builder
+	for _, change := range c.Files() {
+		b.WriteString(change.diffText(relPath(c.workdir, change.Path)))
+	}
+	return b.String()
+}
+
+// Summary renders the changeset for review: a per-file line count, the
+// skipped commands and the full diff.
+func (c *Changeset) Summary() string {
+	var b strings.Builder
+
+	files := c.Files()
+	if len(files) > 0 {
+		b.WriteString("Files:\n")
+		for _, change := range files {
+			added, removed := change.Stats()
+			fmt.Fprintf(&b, "  %s (%s, +%d -%d)\n", relPath(c.workdir, change.Path), change.Status(), added, removed)
+		}
+	}
+
+	if commands := c.Commands(); len(commands) > 0 {
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString("Commands not executed:\n")
+		for _, command := range commands {
+			fmt.Fprintf(&b, "  $ %s\n", command)
+		}
+	}
+
+	if d := c.Diff(); d != "" {
+		b.WriteString("\n")
+		b.WriteString(d)
+	}
+
+	return b.String()
+}
+
+// Apply writes every recorded file change to disk and clears the changeset.
+// Recorded commands are not run; they are listed for the user to run by hand.
+func (c *Changeset) Apply() error {
+	for _, change := range c.Files() {
+		if err := change.apply(); err != nil {
+			return err
+		}
+	}
+
+	c.Discard()
+	return nil
+}
+
+// Discard drops every recorded change.
+func (c *Changeset) Discard() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.files = make(map[string]*FileChange)
+	c.order = nil
+	c.commands = nil
+}
+
+func relPath(workdir, path string) string {
+	if rel, err := filepath.Rel(workdir, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return filepath.ToSlash(rel)
+	}
+	return filepath.ToSlash(path)
+}
@@ -0,0 +1,416 @@
+package sandbox
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/niradler/termu/internal/config"
+	"github.com/niradler/termu/internal/security"
+)
+
+// Scratch is a temporary copy of the working directory that a session
+// works in instead of the real tree. Inside a git repository it is a clone
+// checked out at HEAD with the uncommitted changes copied over; elsewhere
+// the directory is copied. Changes are only made to the real tree when
+// applied.
+type Scratch struct {
+	real    string
+	tmp     string
+	workdir string
+	// repo is the real repository root when the copy is a git clone.
+	repo     string
+	clone    string
+	baseline map[string]fileStamp
+}
+
+// fileStamp identifies a file's state cheaply, so only files touched since
+// the copy was made need comparing.
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+	mode    fs.FileMode
+}
+
+// NewScratch copies workdir to a temporary directory.
+func NewScratch(workdir string) (*Scratch, error) {
+	real, err := filepath.EvalSymlinks(workdir)
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := os.MkdirTemp("", "termu-scratch-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create scratch directory: %w", err)
+	}
+
+	s := &Scratch{real: real, tmp: tmp}
+	if err := s.create(); err != nil {
+		s.Discard()
+		return nil, err
+	}
+
+	s.baseline = make(map[string]fileStamp)
+	err = walkFiles(s.workdir, func(rel string, info fs.FileInfo) error {
+		s.baseline[rel] = stamp(info)
+		return nil
+	})
+	if err != nil {
+		s.Discard()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Scratch) create() error {
+	if repo, err := git(s.real, "rev-parse", "--show-toplevel"); err == nil {
+		repo = strings.TrimSpace(repo)
+		if real, err := filepath.EvalSymlinks(repo); err == nil {
+			repo = real
+		}
+		// A repository without commits has no HEAD to check out; it is
+		// copied instead.
+		if head, err := git(repo, "rev-parse", "--verify", "HEAD"); err == nil {
+			clone := filepath.Join(s.tmp, filepath.Base(repo))
+			if err := cloneRepo(repo, clone, strings.TrimSpace(head)); err != nil {
+				return err
+			}
+			s.repo, s.clone = repo, clone
+			rel, err := filepath.Rel(repo, s.real)
+			if err != nil {
+				return err
+			}
+			s.workdir = filepath.Join(clone, rel)
+			return s.copyUncommitted()
+		}
+	}
+
+	s.workdir = filepath.Join(s.tmp, filepath.Base(s.real))
+	return copyTree(s.real, s.workdir)
+}
+
+// cloneRepo clones repo to dir and checks out head, detached. The clone
+// borrows the repository's objects but has refs, an index and a config of
+// its own, so commits, branches, stashes and resets made in it leave the
+// real repository alone. It has no remote: pushing from the copy would
+// publish work the user has not reviewed.
+func cloneRepo(repo, dir, head string) error {
+	if _, err := git(repo, "clone", "--quiet", "--shared", "--no-checkout", repo, dir); err != nil {
+		return err
+	}
+	if _, err := git(dir, "remote", "remove", "origin"); err != nil {
+		return err
+	}
+	_, err := git(dir, "checkout", "--quiet", "--detach", head)
+	return err
+}
+
+// copyUncommitted brings the clone, checked out at HEAD, to the state
+// of the real tree: staged and unstaged changes and untracked files.
+func (s *Scratch) copyUncommitted() error {
+	changed, err := git(s.repo, "diff", "--name-only", "-z", "HEAD")
+	if err != nil {
+		return err
+	}
+	untracked, err := git(s.repo, "ls-files", "-z", "--others", "--exclude-standard")
+	if err != nil {
+		return err
+	}
+
+	for _, rel := range strings.Split(changed+untracked, "\x00") {
+		if rel == "" {
+			continue
+		}
+		src := filepath.Join(s.repo, rel)
+		dst := filepath.Join(s.clone, rel)
+		info, err := os.Lstat(src)
+		if errors.Is(err, fs.ErrNotExist) {
+			if err := os.Remove(dst); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := copyEntry(src, dst, info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Workdir is the copy of the working directory to work in.
+func (s *Scratch) Workdir() string {
+	return s.workdir
+}
+
+// Config returns cfg with the copy as the working directory, for the file
+// tools and commands. The real working directory stays the project that
+// approvals, the policy file, sessions and the audit log belong to. Where
+// folder rules allow the real working directory they allow the copy too.
+func (s *Scratch) Config(cfg *config.Config) *config.Config {
+	scratch := *cfg
+	scratch.Project = cfg.ProjectDir()
+	scratch.Workdir = s.workdir
+	if len(cfg.Security.AllowedFolders) > 0 && security.New(cfg).AllowsWorkdir(cfg.Workdir) {
+		scratch.Security.AllowedFolders = append(append([]string(nil), cfg.Security.AllowedFolders...), s.workdir)
+	}
+	return &scratch
+}
+
+// Changes compares the copy with the real tree and returns the files that
+// differ, sorted by path. Only regular files are compared. In a clone,
+// files git ignores, such as build outputs and dependencies, are left out.
+func (s *Scratch) Changes() ([]FileChange, error) {
+	ignored, err := s.ignored()
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []FileChange
+	seen := make(map[string]bool)
+
+	err = walkFiles(s.workdir, func(rel string, info fs.FileInfo) error {
+		seen[rel] = true
+		if base, ok := s.baseline[rel]; ok && base == stamp(info) {
+			return nil
+		}
+		if ignored(rel) {
+			return nil
+		}
+
+		content, err := os.ReadFile(filepath.Join(s.workdir, rel))
+		if err != nil {
+			return err
+		}
+		change := FileChange{
+			Path:    filepath.Join(s.real, rel),
+			Content: string(content),
+			Mode:    info.Mode().Perm(),
+		}
+		original, err := os.ReadFile(change.Path)
+		switch {
+		case err == nil:
+			if bytes.Equal(original, content) {
+				return nil
+			}
+			change.Existed = true
+			change.Original = string(original)
+		case !errors.Is(err, fs.ErrNotExist):
+			return err
+		}
+		changes = append(changes, change)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for rel := range s.baseline {
+		if seen[rel] {
+			continue
+		}
+		path := filepath.Join(s.real, rel)
+		original, err := os.ReadFile(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, FileChange{Path: path, Existed: true, Original: string(original), Deleted: true})
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, nil
+}
+
+// ignored returns a function reporting whether git ignores a file in the
+// clone, by its path relative to the working directory. Ignored files are
+// not copied into the clone, so any there were made in it.
+func (s *Scratch) ignored() (func(rel string) bool, error) {
+	if s.clone == "" {
+		return func(string) bool { return false }, nil
+	}
+	out, err := git(s.workdir, "ls-files", "-z", "--others", "--ignored", "--exclude-standard", "--directory")
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]bool)
+	var dirs []string
+	for _, path := range strings.Split(out, "\x00") {
+		switch {
+		case path == "":
+		case strings.HasSuffix(path, "/"):
+			dirs = append(dirs, filepath.FromSlash(path))
+		default:
+			files[filepath.FromSlash(path)] = true
+		}
+	}
+	return func(rel string) bool {
+		for _, dir := range dirs {
+			if strings.HasPrefix(rel, dir) {
+				return true
+			}
+		}
+		return files[rel]
+	}, nil
+}
+
+// Summary renders changes for review: a per-file line count and the full
+// diff against the real tree.
+func (s *Scratch) Summary(changes []FileChange) string {
+	var b strings.Builder
+	b.WriteString("Files:\n")
+	for _, change := range changes {
+		added, removed := change.Stats()
+		fmt.Fprintf(&b, "  %s (%s, +%d -%d)\n", s.RelPath(change.Path), change.Status(), added, removed)
+	}
+	b.WriteString("\n")
+	b.WriteString(s.Diff(changes))
+	return b.String()
+}
+
+// Diff returns the unified diff of changes.
+func (s *Scratch) Diff(changes []FileChange) string {
+	var b strings.Builder
+	for _, change := range changes {
+		b.WriteString(change.diffText(s.RelPath(change.Path)))
+	}
+	return b.String()
+}
+
+// RelPath returns path relative to the real working directory.
+func (s *Scratch) RelPath(path string) string {
+	return relPath(s.real, path)
+}
+
+// Apply writes changes to the real tree.
+func (s *Scratch) Apply(changes []FileChange) error {
+	for _, change := range changes {
+		if err := change.apply(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Discard removes the copy.
+func (s *Scratch) Discard() {
+	os.RemoveAll(s.tmp)
+}
+
+// walkFiles calls fn for every regular file under root, with its path
+// relative to root. Git metadata is skipped.
+func walkFiles(root string, fn func(rel string, info fs.FileInfo) error) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Name() == ".git" && path != root {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		return fn(rel, info)
+	})
+}
+
+func stamp(info fs.FileInfo) fileStamp {
+	return fileStamp{size: info.Size(), modTime: info.ModTime(), mode: info.Mode()}
+}
+
+// copyTree copies directories, regular files and symlinks from src to dst.
+func copyTree(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return copyEntry(path, filepath.Join(dst, rel), info)
+	})
+}
+
+// copyEntry copies one directory, regular file or symlink, keeping its
+// mode and modification time. Other file types are skipped.
+func copyEntry(src, dst string, info fs.FileInfo) error {
+	switch {
+	case info.IsDir():
+		return os.MkdirAll(dst, info.Mode().Perm()|0700)
+
+	case info.Mode()&fs.ModeSymlink != 0:
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		os.Remove(dst)
+		return os.Symlink(target, dst)
+
+	case info.Mode().IsRegular():
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		in, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, in); err != nil {
+			out.Close()
+			return err
+		}
+		if err := out.Close(); err != nil {
+			return err
+		}
+		return os.Chtimes(dst, info.ModTime(), info.ModTime())
+	}
+	return nil
+}
+
+func git(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return "", err
+	}
+	return string(out), nil
+}
@@ -0,0 +1,325 @@
+package security
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/niradler/termu/internal/config"
+)
+
+// ApprovalScope is how far a user's approval of a command reaches.
+type ApprovalScope int
+
+const (
+	// ApproveOnce runs the command this time only; nothing is remembered.
+	ApproveOnce ApprovalScope = iota
+	// ApproveSession approves a pattern until termu exits.
+	ApproveSession
+	// ApproveProject approves a pattern in the project's allow file.
+	ApproveProject
+)
+
+func (s ApprovalScope) String() string {
+	switch s {
+	case ApproveOnce:
+		return "once"
+	case ApproveSession:
+		return "session"
+	case ApproveProject:
+		return "project"
+	default:
+		return "unknown"
+	}
+}
+
+// ProjectApprovalPath returns the allow file of the project in workdir:
+// one approval pattern per line, # starts a comment. It is kept under
+// ~/.termu/approvals rather than in the project, where the agent's file
+// tools could write it and approve its own commands.
+func ProjectApprovalPath(workdir string) string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".termu", "approvals", config.WorkdirKey(workdir))
+}
+
+// Approval is a remembered approval. Pattern is either an exact command or
+// a prefix followed by " *", such as "git push *", which matches the prefix
+// alone or followed by any arguments.
+type Approval struct {
+	Pattern string
+	Scope   ApprovalScope
+}
+
+// approvalStore holds session approvals in memory and project approvals in
+// the allow file.
+type approvalStore struct {
+	mu          sync.Mutex
+	session     []string
+	project     []string
+	projectPath string
+	loadErr     error
+}
+
+func newApprovalStore(workdir string) *approvalStore {
+	s := &approvalStore{projectPath: ProjectApprovalPath(workdir)}
+	s.project, s.loadErr = readApprovalFile(s.projectPath)
+	return s
+}
+
+// Approve remembers the user's approval of command. With ApproveOnce
+// nothing is stored; the caller runs the command it was asked about. For
+// the other scopes pattern is stored, or the exact command when pattern is
+// empty.
+func (v *Validator) Approve(command string, scope ApprovalScope, pattern string) error {
+	if scope == ApproveOnce {
+		return nil
+	}
+
+	pattern = normalizeCommand(pattern)
+	if pattern == "" {
+		pattern = normalizeCommand(command)
+	}
+	if strings.TrimSuffix(pattern, "*") == "" {
+		return fmt.Errorf("approval pattern %q would approve every command", pattern)
+	}
+
+	s := v.approvals
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if scope == ApproveSession {
+		if !contains(s.session, pattern) {
+			s.session = append(s.session, pattern)
+		}
+		return nil
+	}
+
+	if contains(s.project, pattern) {
+		return nil
+	}
+	s.project = append(s.project, pattern)
+	return writeApprovalFile(s.projectPath, s.project)
+}
+
+// Approvals lists the session approvals followed by the project ones.
+func (v *Validator) Approvals() []Approval {
+	s := v.approvals
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var approvals []Approval
+	for _, pattern := range s.session {
+		approvals = append(approvals, Approval{Pattern: pattern, Scope: ApproveSession})
+	}
+	for _, pattern := range s.project {
+		approvals = append(approvals, Approval{Pattern: pattern, Scope: ApproveProject})
+	}
+	return approvals
+}
+
+// ProjectApprovalFile returns the path of the project allow file.
+func (v *Validator) ProjectApprovalFile() string {
+	return v.approvals.projectPath
+}
+
+// ProjectApprovalsError reports a project allow file that could not be
+// read; its approvals are then ignored.
+func (v *Validator) ProjectApprovalsError() error {
+	return v.approvals.loadErr
+}
+
+// Revoke removes an approval pattern from the given scope and reports
+// whether it was there.
+func (v *Validator) Revoke(pattern string, scope ApprovalScope) (bool, error) {
+	pattern = normalizeCommand(pattern)
+
+	s := v.approvals
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch scope {
+	case ApproveSession:
+		var found bool
+		s.session, found = remove(s.session, pattern)
+		return found, nil
+	case ApproveProject:
+		var found bool
+		s.project, found = remove(s.project, pattern)
+		if !found {
+			return false, nil
+		}
+		return true, writeApprovalFile(s.projectPath, s.project)
+	default:
+		return false, nil
+	}
+}
+
+// ClearApprovals forgets every session approval.
+func (v *Validator) ClearApprovals() {
+	v.approvals.mu.Lock()
+	defer v.approvals.mu.Unlock()
+	v.approvals.session = nil
+}
+
+// IsApproved reports whether the shell line matches an exact session or
+// project approval, or every command in it matches one.
+func (v *Validator) IsApproved(command string) bool {
+	if v.approvals.matchesExactly(command) {
+		return true
+	}
+	commands, err := ParseCommand(command)
+	if err != nil || len(commands) == 0 {
+		return false
+	}
+	for _, cmd := range commands {
+		if !v.approvals.matches(cmd.Source) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *approvalStore) matches(command string) bool {
+	command = normalizeCommand(command)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, list := range [][]string{s.session, s.project} {
+		for _, pattern := range list {
+			if matchApproval(pattern, command) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchesExactly reports whether command is approved by an exact pattern,
+// not a prefix one. Whole shell lines are only approved this way.
+func (s *approvalStore) matchesExactly(command string) bool {
+	command = normalizeCommand(command)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, list := range [][]string{s.session, s.project} {
+		if contains(list, command) && !strings.HasSuffix(command, " *") {
+			return true
+		}
+	}
+	return false
+}
+
+// matchApproval matches an exact pattern, or a prefix pattern ending in
+// " *" on whole words.
+func matchApproval(pattern, command string) bool {
+	prefix, ok := strings.CutSuffix(pattern, " *")
+	if !ok {
+		return pattern == command
+	}
+	return command == prefix || strings.HasPrefix(command, prefix+" ")
+}
+
+var subcommandWord = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+
+// SuggestPattern proposes a pattern for approving command beyond this
+// once: the program and its subcommand, such as "git push *" or "npm run
+// *". Shell lines with several commands, and high-risk programs, get the
+// exact command instead so one approval cannot cover unrelated uses.
+func (v *Validator) SuggestPattern(command string) string {
+	exact := normalizeCommand(command)
+
+	commands, err := ParseCommand(command)
+	if err != nil || len(commands) != 1 {
+		return exact
+	}
+	cmd := commands[0]
+	if cmd.Name == "" || cmd.Dynamic || len(cmd.Args) == 0 || len(cmd.Assigns) > 0 || len(cmd.Redirects) > 0 {
+		return exact
+	}
+	for _, highRisk := range v.config.Security.HighRiskCommands {
+		if cmd.Name == highRisk {
+			return exact
+		}
+	}
+
+	fields := strings.Fields(exact)
+	if subcommandWord.MatchString(cmd.Args[0]) && len(fields) > 1 && fields[1] == cmd.Args[0] {
+		return fields[0] + " " + fields[1] + " *"
+	}
+	return fields[0] + " *"
+}
+
+func normalizeCommand(command string) string {
+	return strings.Join(strings.Fields(command), " ")
+}
+
+func readApprovalFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	defer file.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, normalizeCommand(line))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return patterns, nil
+}
+
+func writeApprovalFile(path string, patterns []string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+
+	var b strings.Builder
+	b.WriteString("# Commands termu may run in this project without asking.\n")
+	b.WriteString("# One per line: an exact command, or a prefix followed by \" *\".\n")
+	for _, pattern := range patterns {
+		b.WriteString(pattern)
+		b.WriteString("\n")
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(b.String()), 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return os.Rename(tmp, path)
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func remove(list []string, value string) ([]string, bool) {
+	for i, item := range list {
+		if item == value {
+			return append(list[:i:i], list[i+1:]...), true
+		}
+	}
+	return list, false
+}
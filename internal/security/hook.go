@@ -0,0 +1,471 @@
+package security
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/ext"
+	"gopkg.in/yaml.v3"
+)
+
+// PolicyAsk is the policy hook action that requires the user's approval.
+// Rules may also spell it "approve", as command policies do.
+const PolicyAsk = "ask"
+
+// PolicyHook is a policy file: rules written as CEL expressions that decide
+// on commands and file operations beyond what the static security lists
+// can express. The first rule whose condition holds decides.
+type PolicyHook struct {
+	Path  string
+	rules []hookRule
+}
+
+type hookRule struct {
+	name    string
+	action  string
+	message string
+	program cel.Program
+}
+
+// policyFile is the YAML layout of a policy file.
+type policyFile struct {
+	Rules []struct {
+		Name    string `yaml:"name"`
+		When    string `yaml:"when"`
+		Action  string `yaml:"action"`
+		Message string `yaml:"message"`
+	} `yaml:"rules"`
+}
+
+// PolicyInput is what a policy rule sees of one operation.
+type PolicyInput struct {
+	// Tool is the agent tool performing the operation, such as
+	// execute_command or write_file.
+	Tool string
+	// Command is the simple command being checked, for execute_command;
+	// Line is the whole shell line it is part of.
+	Command SimpleCommand
+	Line    string
+	// Paths are the files involved, relative to Workdir when inside it.
+	Paths   []string
+	Workdir string
+	Risk    RiskLevel
+	Time    time.Time
+}
+
+// PolicyDecision is the outcome of the rule that matched.
+type PolicyDecision struct {
+	Rule    string
+	Action  string
+	Message string
+}
+
+// String describes the decision for error messages and approval prompts.
+func (d *PolicyDecision) String() string {
+	s := "policy rule " + d.Rule
+	if d.Message != "" {
+		s += ": " + d.Message
+	}
+	return s
+}
+
+// policyEnv declares the variables rules can use.
+func policyEnv() (*cel.Env, error) {
+	return cel.NewEnv(
+		ext.Strings(),
+		cel.Variable("tool", cel.StringType),
+		cel.Variable("command", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("line", cel.StringType),
+		cel.Variable("paths", cel.ListType(cel.StringType)),
+		cel.Variable("workdir", cel.StringType),
+		cel.Variable("risk", cel.StringType),
+		cel.Variable("now", cel.TimestampType),
+		cel.Variable("hour", cel.IntType),
+		cel.Variable("weekday", cel.IntType),
+	)
+}
+
+// LoadPolicyHook reads and compiles the policy file at path.
+func LoadPolicyHook(path string) (*PolicyHook, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy file: %w", err)
+	}
+	var file policyFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse policy file %s: %w", path, err)
+	}
+
+	env, err := policyEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	hook := &PolicyHook{Path: path}
+	for i, rule := range file.Rules {
+		name := rule.Name
+		if name == "" {
+			name = fmt.Sprintf("rule %d", i+1)
+		}
+
+		action := strings.ToLower(rule.Action)
+		switch action {
+		case PolicyAllow, PolicyDeny, PolicyAsk:
+		case PolicyApprove, "":
+			action = PolicyAsk
+		default:
+			return nil, fmt.Errorf("policy %s: unknown action %q (want allow, deny or ask)", name, rule.Action)
+		}
+
+		if strings.TrimSpace(rule.When) == "" {
+			return nil, fmt.Errorf("policy %s: missing when expression", name)
+		}
+		ast, issues := env.Compile(rule.When)
+		if issues != nil && issues.Err() != nil {
+			return nil, fmt.Errorf("policy %s: %w", name, issues.Err())
+		}
+		if ast.OutputType() != cel.BoolType {
+			return nil, fmt.Errorf("policy %s: when must be a boolean expression, not %s", name, ast.OutputType())
+		}
+		program, err := env.Program(ast)
+		if err != nil {
+			return nil, fmt.Errorf("policy %s: %w", name, err)
+		}
+
+		hook.rules = append(hook.rules, hookRule{name: name, action: action, message: rule.Message, program: program})
+	}
+	return hook, nil
+}
+
+// Evaluate returns the decision of the first rule whose condition holds
+// for input, or nil when none does. A rule that fails to evaluate denies
+// the operation, so a mistake in the policy never lets something through.
+func (h *PolicyHook) Evaluate(input PolicyInput) *PolicyDecision {
+	at := input.Time
+	if at.IsZero() {
+		at = time.Now()
+	}
+	paths := input.Paths
+	if paths == nil {
+		paths = []string{}
+	}
+	args := input.Command.Args
+	if args == nil {
+		args = []string{}
+	}
+	flags := []string{}
+	for _, flag := range commandFlags(input.Command) {
+		flags = append(flags, flag.name)
+	}
+
+	vars := map[string]any{
+		"tool": input.Tool,
+		"command": map[string]any{
+			"name":       input.Command.Name,
+			"args":       args,
+			"subcommand": subcommand(input.Command),
+			"flags":      flags,
+			"source":     input.Command.Source,
+		},
+		"line":    input.Line,
+		"paths":   paths,
+		"workdir": input.Workdir,
+		"risk":    input.Risk.String(),
+		"now":     at,
+		"hour":    at.Hour(),
+		"weekday": int(at.Weekday()),
+	}
+
+	for _, rule := range h.rules {
+		out, _, err := rule.program.Eval(vars)
+		if err != nil {
+			return &PolicyDecision{
+				Rule:    rule.name,
+				Action:  PolicyDeny,
+				Message: fmt.Sprintf("failed to evaluate: %v", err),
+			}
+		}
+		if matched, ok := out.Value().(bool); ok && matched {
+			return &PolicyDecision{Rule: rule.name, Action: rule.action, Message: rule.message}
+		}
+	}
+	return nil
+}
+
+// policyPath returns the path a policy rule sees for path: relative to
+// workdir when inside it, with forward slashes.
+func policyPath(path, workdir string) string {
+	if rel, err := filepath.Rel(workdir, path); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		path = rel
+	}
+	return filepath.ToSlash(path)
+}
+
+// PolicyError reports a policy file that could not be loaded. Until it is
+// fixed, every command and file operation is blocked.
+func (v *Validator) PolicyError() error {
+	return v.hookErr
+}
+
+// applyHook lets the policy file decide on a simple command the static
+// checks allowed. The static lists always take precedence: a rule cannot
+// allow what they block, nor lift an approval they require.
+func (v *Validator) applyHook(result *ValidationResult, cmd SimpleCommand, line, workdir string) *ValidationResult {
+	if v.hook == nil {
+		return result
+	}
+
+	decision := v.hook.Evaluate(PolicyInput{
+		Tool:    "execute_command",
+		Command: cmd,
+		Line:    line,
+		Paths:   commandPaths(cmd, workdir),
+		Workdir: workdir,
+		Risk:    result.RiskLevel,
+	})
+	if decision == nil {
+		return result
+	}
+
+	switch decision.Action {
+	case PolicyDeny:
+		return &ValidationResult{
+			Allowed:   false,
+			Reason:    "Denied by " + decision.String(),
+			RiskLevel: max(result.RiskLevel, RiskMedium),
+			Command:   cmd.Source,
+		}
+	case PolicyAsk:
+		result.RiskLevel = max(result.RiskLevel, RiskMedium)
+		result.Reason = decision.String()
+		result.Policy = nil
+		result.NeedsApproval = true
+	case PolicyAllow:
+		result.Reason = "allowed by " + decision.String()
+		result.Policy = nil
+		result.NeedsApproval = v.config.Security.AlwaysApprove || result.required
+	}
+	return result
+}
+
+// commandPaths returns the files a command refers to: its redirect
+// targets, then the arguments that name a file in the working directory.
+func commandPaths(cmd SimpleCommand, workdir string) []string {
+	var paths []string
+	for _, redir := range cmd.Redirects {
+		if discardTargets[redir.Target] {
+			continue
+		}
+		if redir.Dynamic {
+			paths = append(paths, redir.Target)
+			continue
+		}
+		paths = append(paths, policyPath(expandPathFrom(redir.Target, workdir), workdir))
+	}
+	for _, arg := range cmd.Args {
+		if path, ok := argPath(arg, workdir); ok && !contains(paths, path) {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// argPath returns the path a policy rule sees for a command argument that
+// names a file in workdir: one that exists, or a word that looks like a
+// path or a glob, such as secrets.env or src/*.go. Options, words only
+// known at run time and paths outside workdir are left out.
+func argPath(arg, workdir string) (string, bool) {
+	if arg == "" || strings.HasPrefix(arg, "-") || strings.ContainsAny(arg, "$`") {
+		return "", false
+	}
+	path := expandPathFrom(arg, workdir)
+	if !isWithin(path, workdir) || path == filepath.Clean(workdir) {
+		return "", false
+	}
+	if _, err := os.Lstat(path); err != nil && !strings.ContainsAny(arg, "./*?[") {
+		return "", false
+	}
+	return policyPath(path, workdir), true
+}
+
+// CheckFile applies the policy file to a file tool operating on path, as
+// resolved by ResolvePath. File tools cannot ask for approval, so a rule
+// asking for it denies the operation. The policy file itself may be read
+// but never written, whatever its rules say.
+func (v *Validator) CheckFile(tool, path, workdir string) error {
+	if fileRisk(tool) > RiskLow && v.isPolicyFile(path) {
+		return fmt.Errorf("%s cannot change the policy file %s; ask the user to edit it", tool, path)
+	}
+	if v.hookErr != nil {
+		return fmt.Errorf("policy file could not be loaded: %w", v.hookErr)
+	}
+	if v.hook == nil {
+		return nil
+	}
+
+	decision := v.hook.Evaluate(v.fileInput(tool, path, workdir, fileRisk(tool), time.Time{}))
+	if decision == nil {
+		return nil
+	}
+	switch decision.Action {
+	case PolicyDeny:
+		return fmt.Errorf("denied by %s", decision)
+	case PolicyAsk:
+		return fmt.Errorf("%s requires approval, which %s cannot ask for; run a command instead or ask the user", decision, tool)
+	}
+	return nil
+}
+
+func (v *Validator) fileInput(tool, path, workdir string, risk RiskLevel, at time.Time) PolicyInput {
+	root, err := realPath(workdir)
+	if err != nil {
+		root = workdir
+	}
+	return PolicyInput{
+		Tool:    tool,
+		Paths:   []string{policyPath(path, root)},
+		Workdir: workdir,
+		Risk:    risk,
+		Time:    at,
+	}
+}
+
+// isPolicyFile reports whether path, as resolved by ResolvePath, is the
+// policy file.
+func (v *Validator) isPolicyFile(path string) bool {
+	return v.policyFile != "" && path == v.policyFile
+}
+
+// fileRisk is the risk of a file tool: reading is low, writing medium.
+func fileRisk(tool string) RiskLevel {
+	switch tool {
+	case "read_file", "list_directory":
+		return RiskLow
+	default:
+		return RiskMedium
+	}
+}
+
+// PolicyCase is a sample operation to test the policy file with.
+type PolicyCase struct {
+	Name    string   `yaml:"name"`
+	Tool    string   `yaml:"tool"` // Defaults to execute_command
+	Command string   `yaml:"command"`
+	Paths   []string `yaml:"paths"`  // Files, for file tools
+	Risk    string   `yaml:"risk"`   // Overrides the risk the validator assesses
+	Time    string   `yaml:"time"`   // RFC 3339, or HH:MM for today
+	Expect  string   `yaml:"expect"` // allow, deny, ask or none
+}
+
+// PolicyOutcome is the policy file's decision on a PolicyCase.
+type PolicyOutcome struct {
+	// Action is allow, deny or ask, or none when no rule matched.
+	Action   string
+	Decision *PolicyDecision
+	// Subject is the simple command or path that decided the outcome.
+	Subject string
+}
+
+// policyNone is the outcome of a case no rule matched.
+const policyNone = "none"
+
+// policyStrictness orders actions so that the strictest decision over
+// several commands or paths wins.
+var policyStrictness = map[string]int{policyNone: 0, PolicyAllow: 1, PolicyAsk: 2, PolicyDeny: 3}
+
+// TestPolicy evaluates the policy file on a sample case, without the
+// static checks. A shell line is decided by its strictest simple command.
+func (v *Validator) TestPolicy(c PolicyCase, workdir string) (*PolicyOutcome, error) {
+	if v.hookErr != nil {
+		return nil, v.hookErr
+	}
+	if v.hook == nil {
+		return nil, fmt.Errorf("no policy file configured; set security.policy_file")
+	}
+
+	at, err := policyTime(c.Time)
+	if err != nil {
+		return nil, err
+	}
+	var risk *RiskLevel
+	if c.Risk != "" {
+		r := ruleRisk(c.Risk, "")
+		risk = &r
+	}
+
+	tool := c.Tool
+	if tool == "" {
+		tool = "execute_command"
+	}
+
+	outcome := &PolicyOutcome{Action: policyNone}
+	decide := func(input PolicyInput, subject string) {
+		decision := v.hook.Evaluate(input)
+		action := policyNone
+		if decision != nil {
+			action = decision.Action
+		}
+		if outcome.Decision == nil || policyStrictness[action] > policyStrictness[outcome.Action] {
+			outcome.Action, outcome.Decision, outcome.Subject = action, decision, subject
+		}
+	}
+
+	if tool != "execute_command" {
+		if len(c.Paths) == 0 {
+			return nil, fmt.Errorf("a %s case needs paths", tool)
+		}
+		for _, path := range c.Paths {
+			level := fileRisk(tool)
+			if risk != nil {
+				level = *risk
+			}
+			decide(v.fileInput(tool, expandPathFrom(path, workdir), workdir, level, at), path)
+		}
+		return outcome, nil
+	}
+
+	commands, err := ParseCommand(c.Command)
+	if err != nil {
+		return nil, fmt.Errorf("command could not be parsed as shell: %w", err)
+	}
+	if len(commands) == 0 {
+		return nil, fmt.Errorf("a command case needs a command")
+	}
+	for _, cmd := range commands {
+		level := v.validateSimple(cmd, workdir).RiskLevel
+		if risk != nil {
+			level = *risk
+		}
+		decide(PolicyInput{
+			Tool:    tool,
+			Command: cmd,
+			Line:    c.Command,
+			Paths:   commandPaths(cmd, workdir),
+			Workdir: workdir,
+			Risk:    level,
+			Time:    at,
+		}, cmd.Source)
+	}
+	return outcome, nil
+}
+
+// policyTime parses a case's time: RFC 3339, or HH:MM for today. An empty
+// time is now.
+func policyTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Now(), nil
+	}
+	if at, err := time.Parse(time.RFC3339, value); err == nil {
+		return at, nil
+	}
+	clock, err := time.ParseInLocation("15:04", value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: want RFC 3339 or HH:MM", value)
+	}
+	now := time.Now()
+	return time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), 0, 0, time.Local), nil
+}
@@ -0,0 +1,151 @@
+package security
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/niradler/termu/internal/config"
+)
+
+func TestCommandPaths(t *testing.T) {
+	workdir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(workdir, "Makefile"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		command string
+		want    []string
+	}{
+		{"rm secrets.env", []string{"secrets.env"}},
+		{"cp a.txt b/c.txt", []string{"a.txt", "b/c.txt"}},
+		{"cat Makefile", []string{"Makefile"}},
+		{"echo hi > out.txt", []string{"out.txt"}},
+		{"sort data.csv > data.csv", []string{"data.csv"}},
+		{"rm *.env", []string{"*.env"}},
+		{"git commit -m fix", nil},
+		{"rm -f ./x", []string{"x"}},
+		{"cat /etc/hosts ../other.txt", nil},
+		{"rm $FILE", nil},
+		{"ls . 2>/dev/null", nil},
+	}
+	for _, tt := range tests {
+		commands, err := ParseCommand(tt.command)
+		if err != nil || len(commands) != 1 {
+			t.Fatalf("ParseCommand(%q) = %v, %v", tt.command, commands, err)
+		}
+		if got := commandPaths(commands[0], workdir); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("commandPaths(%q) = %q, want %q", tt.command, got, tt.want)
+		}
+	}
+}
+
+func TestPolicyHookSeesArgumentPaths(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	policy := filepath.Join(t.TempDir(), "policy.yaml")
+	rules := `rules:
+  - name: env-files
+    when: paths.exists(p, p.endsWith(".env"))
+    action: deny
+`
+	if err := os.WriteFile(policy, []byte(rules), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.DefaultConfig()
+	cfg.Workdir = t.TempDir()
+	cfg.Security.AllowedFolders = []string{cfg.Workdir}
+	cfg.Security.PolicyFile = policy
+	v := New(cfg)
+
+	for _, command := range []string{"rm secrets.env", "cp secrets.env backup.txt", "cat app.env | head", "echo x > prod.env"} {
+		if result := v.Validate(command, cfg.Workdir); result.Allowed {
+			t.Errorf("Validate(%q) allowed it, want the env-files rule to deny it", command)
+		}
+	}
+	if result := v.Validate("cat README.md", cfg.Workdir); !result.Allowed {
+		t.Errorf("Validate(cat README.md) denied: %s", result.Reason)
+	}
+}
+
+func TestPolicyFileIsReadOnly(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	cfg := config.DefaultConfig()
+	cfg.Workdir = t.TempDir()
+	cfg.Security.AllowedFolders = []string{cfg.Workdir}
+	cfg.Security.PolicyFile = "policy.yaml"
+	rules := "rules:\n  - name: make\n    when: command.name == \"make\"\n    action: allow\n"
+	if err := os.WriteFile(filepath.Join(cfg.Workdir, "policy.yaml"), []byte(rules), 0644); err != nil {
+		t.Fatal(err)
+	}
+	v := New(cfg)
+	if err := v.PolicyError(); err != nil {
+		t.Fatal(err)
+	}
+
+	path, err := v.ResolvePath("policy.yaml", cfg.Workdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := v.CheckFile("read_file", path, cfg.Workdir); err != nil {
+		t.Errorf("read_file of the policy file: %v", err)
+	}
+	for _, tool := range []string{"write_file", "search_replace"} {
+		if err := v.CheckFile(tool, path, cfg.Workdir); err == nil {
+			t.Errorf("%s of the policy file was allowed", tool)
+		}
+	}
+
+	if result := v.Validate("echo 'rules: []' > policy.yaml", cfg.Workdir); result.Allowed {
+		t.Errorf("redirect to the policy file was allowed")
+	}
+	if result := v.Validate("sd make rm ./policy.yaml", cfg.Workdir); !result.Allowed || !result.NeedsApproval {
+		t.Errorf("sd on the policy file ran without approval: %s", result.Reason)
+	}
+	if result := v.Validate("cat policy.yaml", cfg.Workdir); !result.Allowed {
+		t.Errorf("cat policy.yaml denied: %s", result.Reason)
+	}
+}
+
+func TestPolicyAllowKeepsRequiredApproval(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	policy := filepath.Join(t.TempDir(), "policy.yaml")
+	rules := `rules:
+  - name: trusted
+    when: command.name in ["git", "rm", "sd"]
+    action: allow
+`
+	if err := os.WriteFile(policy, []byte(rules), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.DefaultConfig()
+	cfg.Workdir = t.TempDir()
+	cfg.Security.AllowedFolders = []string{cfg.Workdir}
+	cfg.Security.AllowedCommands = append(cfg.Security.AllowedCommands, "rm")
+	cfg.Security.PolicyFile = policy
+	v := New(cfg)
+	if err := v.PolicyError(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		command       string
+		needsApproval bool
+	}{
+		{"rm notes.txt", true},
+		{"git push origin main", true},
+		{"git -c core.pager=less log", true},
+		{"git status", false},
+		{"sd foo bar notes.txt", false},
+	}
+	for _, tt := range tests {
+		result := v.Validate(tt.command, cfg.Workdir)
+		if !result.Allowed || result.NeedsApproval != tt.needsApproval {
+			t.Errorf("Validate(%q) = allowed %v, needs approval %v; want allowed, needs approval %v",
+				tt.command, result.Allowed, result.NeedsApproval, tt.needsApproval)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package security
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ResolvePath turns a tool-supplied path into an absolute, symlink-free path
+// and checks it against the workspace, allowed folders and restricted folders.
+// Paths that do not exist yet are resolved through their nearest existing
+// parent so that new files cannot be created behind a symlink either.
+func (v *Validator) ResolvePath(path, workdir string) (string, error) {
+	if strings.TrimSpace(path) == "" {
+		path = "."
+	}
+
+	lexical := expandPathFrom(path, workdir)
+	real, err := realPath(lexical)
+	if err != nil {
+		return "", fmt.Errorf("cannot resolve path %s: %w", path, err)
+	}
+
+	for _, restricted := range v.config.Security.RestrictedFolders {
+		root := expandPathFrom(restricted, workdir)
+		realRoot, err := realPath(root)
+		if err != nil {
+			realRoot = root
+		}
+		if isWithin(lexical, root) || isWithin(real, realRoot) {
+			return "", fmt.Errorf("path %s is inside restricted folder %s", path, restricted)
+		}
+	}
+
+	for _, root := range v.accessRoots(workdir) {
+		if isWithin(real, root) {
+			return real, nil
+		}
+	}
+
+	return "", fmt.Errorf("path %s is outside the workspace and allowed folders; only files inside them can be used", path)
+}
+
+// accessRoots returns the resolved workspace plus every allowed folder.
+func (v *Validator) accessRoots(workdir string) []string {
+	candidates := append([]string{workdir}, v.config.Security.AllowedFolders...)
+
+	var roots []string
+	for _, candidate := range candidates {
+		root, err := realPath(expandPathFrom(candidate, workdir))
+		if err != nil {
+			continue
+		}
+		roots = append(roots, root)
+	}
+	return roots
+}
+
+// realPath evaluates symlinks for the longest existing prefix of path and
+// appends the remaining, not yet existing, components.
+func realPath(path string) (string, error) {
+	path = filepath.Clean(path)
+
+	var missing []string
+	current := path
+	for {
+		resolved, err := filepath.EvalSymlinks(current)
+		if err == nil {
+			for i := len(missing) - 1; i >= 0; i-- {
+				resolved = filepath.Join(resolved, missing[i])
+			}
+			return resolved, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+
+		parent := filepath.Dir(current)
+		if parent == current {
+			return path, nil
+		}
+		missing = append(missing, filepath.Base(current))
+		current = parent
+	}
+}
+
+func expandPathFrom(path, base string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		home, _ := os.UserHomeDir()
+		return filepath.Join(home, strings.TrimPrefix(path[1:], "/"))
+	}
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	return filepath.Join(base, path)
+}
+
+func isWithin(path, root string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
@@ -0,0 +1,378 @@
+package security
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/niradler/termu/internal/config"
+)
+
+// Policy actions a rule can take.
+const (
+	PolicyAllow   = "allow"
+	PolicyApprove = "approve"
+	PolicyDeny    = "deny"
+)
+
+// PolicyMatch explains how a command policy applied to a command.
+type PolicyMatch struct {
+	// Command is the policy's command name.
+	Command string
+	// Rule is the matching rule's name, or the policy list that matched,
+	// such as "denied_flags".
+	Rule   string
+	Action string
+	Risk   RiskLevel
+	// Matched lists what matched, e.g. "subcommand push", "flag --force".
+	Matched []string
+	Reason  string
+}
+
+// String describes the match for error messages and approval prompts.
+func (m *PolicyMatch) String() string {
+	s := fmt.Sprintf("%s policy %s", m.Command, m.Rule)
+	if len(m.Matched) > 0 {
+		s += " (" + strings.Join(m.Matched, ", ") + ")"
+	}
+	if m.Reason != "" {
+		s += ": " + m.Reason
+	}
+	return s
+}
+
+// evaluatePolicy applies the command policy for cmd, if any. It returns
+// nil when there is no policy or nothing in it matched. Options before the
+// subcommand that could make the program run other commands, or that are
+// not understood and so may hide the real subcommand, require approval
+// unless the policy denies the command anyway.
+func (v *Validator) evaluatePolicy(cmd SimpleCommand) *PolicyMatch {
+	lead := leadingOptions(cmd)
+	policy, ok := v.config.Security.CommandPolicies[cmd.Name]
+
+	var match *PolicyMatch
+	if ok {
+		match = matchPolicy(cmd, policy, lead.sub)
+	}
+	if match != nil && match.Action == PolicyDeny {
+		return match
+	}
+
+	if len(lead.code) > 0 {
+		return &PolicyMatch{
+			Command: cmd.Name,
+			Rule:    "global options",
+			Action:  PolicyApprove,
+			Risk:    RiskHigh,
+			Matched: []string{"option " + lead.code[0]},
+			Reason:  "can make " + cmd.Name + " run other programs",
+		}
+	}
+	if ok && len(lead.unknown) > 0 && usesSubcommands(policy) {
+		return &PolicyMatch{
+			Command: cmd.Name,
+			Rule:    "global options",
+			Action:  PolicyApprove,
+			Risk:    RiskMedium,
+			Matched: []string{"option " + lead.unknown[0]},
+			Reason:  "options before the subcommand are not understood, so the policy cannot tell what runs",
+		}
+	}
+	return match
+}
+
+// matchPolicy applies policy's rules and lists to cmd, whose subcommand is
+// sub.
+func matchPolicy(cmd SimpleCommand, policy config.CommandPolicy, sub string) *PolicyMatch {
+	flags := commandFlags(cmd)
+
+	for i, rule := range policy.Rules {
+		matched, ok := matchRule(rule, sub, flags, cmd.Args)
+		if !ok {
+			continue
+		}
+		name := rule.Name
+		if name == "" {
+			name = fmt.Sprintf("rule %d", i+1)
+		}
+		action := rule.Action
+		if action == "" {
+			action = PolicyApprove
+		}
+		return &PolicyMatch{
+			Command: cmd.Name,
+			Rule:    name,
+			Action:  action,
+			Risk:    ruleRisk(rule.Risk, action),
+			Matched: matched,
+			Reason:  rule.Reason,
+		}
+	}
+
+	deny := func(list, matched string) *PolicyMatch {
+		return &PolicyMatch{
+			Command: cmd.Name,
+			Rule:    list,
+			Action:  PolicyDeny,
+			Risk:    RiskHigh,
+			Matched: []string{matched},
+		}
+	}
+
+	if sub != "" {
+		if containsGlob(policy.DeniedSubcommands, sub) {
+			return deny("denied_subcommands", "subcommand "+sub)
+		}
+		if len(policy.AllowedSubcommands) > 0 && !containsGlob(policy.AllowedSubcommands, sub) {
+			return deny("allowed_subcommands", "subcommand "+sub+" is not listed")
+		}
+	}
+
+	for _, flag := range flags {
+		if name, ok := flag.in(policy.DeniedFlags); ok {
+			return deny("denied_flags", "flag "+name)
+		}
+		if len(policy.AllowedFlags) > 0 && !flag.allIn(policy.AllowedFlags) {
+			return deny("allowed_flags", "flag "+flag.name+" is not listed")
+		}
+	}
+
+	return nil
+}
+
+// usesSubcommands reports whether any part of policy depends on the
+// subcommand.
+func usesSubcommands(policy config.CommandPolicy) bool {
+	if len(policy.AllowedSubcommands) > 0 || len(policy.DeniedSubcommands) > 0 {
+		return true
+	}
+	for _, rule := range policy.Rules {
+		if rule.Subcommand != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// globalOptionSet lists the options a program with subcommands takes before
+// its subcommand.
+type globalOptionSet struct {
+	// withValue take the next word as their value unless written as
+	// --option=value.
+	withValue []string
+	flags     []string
+	// code can make the program run other commands, such as an alias or
+	// pager set with git -c.
+	code []string
+}
+
+var globalOptions = map[string]globalOptionSet{
+	"git": {
+		withValue: []string{"-C", "-c", "--git-dir", "--work-tree", "--namespace", "--config-env", "--super-prefix"},
+		flags: []string{
+			"-p", "--paginate", "-P", "--no-pager", "--bare", "--no-replace-objects",
+			"--literal-pathspecs", "--glob-pathspecs", "--noglob-pathspecs", "--icase-pathspecs",
+			"--no-optional-locks", "--no-lazy-fetch", "--no-advice",
+		},
+		code: []string{"-c", "--config-env", "--exec-path"},
+	},
+}
+
+// leadingOpts is what precedes a command's subcommand.
+type leadingOpts struct {
+	sub string
+	// unknown are options before sub that the program is not known to
+	// take, so sub may be one's value rather than the subcommand.
+	unknown []string
+	code    []string
+}
+
+// leadingOptions finds the subcommand of cmd, skipping the global options
+// its program is known to take.
+func leadingOptions(cmd SimpleCommand) leadingOpts {
+	var lead leadingOpts
+	known := globalOptions[cmd.Name]
+	for i := 0; i < len(cmd.Args); i++ {
+		arg := cmd.Args[i]
+		if !strings.HasPrefix(arg, "-") {
+			lead.sub = arg
+			break
+		}
+		name, _, hasValue := strings.Cut(arg, "=")
+		if containsFlag(known.code, name) {
+			lead.code = append(lead.code, name)
+		}
+		switch {
+		case containsFlag(known.withValue, name):
+			if !hasValue {
+				i++
+			}
+		case containsFlag(known.flags, name) || containsFlag(known.code, name):
+		default:
+			lead.unknown = append(lead.unknown, arg)
+		}
+	}
+	return lead
+}
+
+// matchRule reports whether every condition set on rule matches, and what
+// matched.
+func matchRule(rule config.PolicyRule, sub string, flags []flagArg, args []string) ([]string, bool) {
+	var matched []string
+
+	if rule.Subcommand != "" {
+		if !globMatch(rule.Subcommand, sub) {
+			return nil, false
+		}
+		matched = append(matched, "subcommand "+sub)
+	}
+
+	if len(rule.Flags) > 0 {
+		found := ""
+		for _, flag := range flags {
+			if name, ok := flag.in(rule.Flags); ok {
+				found = name
+				break
+			}
+		}
+		if found == "" {
+			return nil, false
+		}
+		matched = append(matched, "flag "+found)
+	}
+
+	for _, pattern := range rule.Args {
+		found := false
+		for _, arg := range args {
+			if globMatch(pattern, arg) {
+				matched = append(matched, "argument "+arg)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+
+	return matched, true
+}
+
+// flagArg is an option argument. A single-dash argument longer than one
+// letter may be a cluster of short options, like "-fdx", or a long option
+// with one dash, like find's "-delete", so both readings are kept.
+type flagArg struct {
+	name  string
+	short []string
+}
+
+// in reports whether the option, or one of its short options, is listed.
+func (f flagArg) in(list []string) (string, bool) {
+	if containsFlag(list, f.name) {
+		return f.name, true
+	}
+	for _, short := range f.short {
+		if containsFlag(list, short) {
+			return short, true
+		}
+	}
+	return "", false
+}
+
+// allIn reports whether the option, or every one of its short options, is
+// listed.
+func (f flagArg) allIn(list []string) bool {
+	if containsFlag(list, f.name) {
+		return true
+	}
+	if len(f.short) == 0 {
+		return false
+	}
+	for _, short := range f.short {
+		if !containsFlag(list, short) {
+			return false
+		}
+	}
+	return true
+}
+
+// commandFlags returns the options of cmd, with "--opt=value" reduced to
+// "--opt".
+func commandFlags(cmd SimpleCommand) []flagArg {
+	var flags []flagArg
+	for _, arg := range cmd.Args {
+		switch {
+		case arg == "-" || arg == "--" || !strings.HasPrefix(arg, "-"):
+			continue
+		case strings.HasPrefix(arg, "--"):
+			name, _, _ := strings.Cut(arg, "=")
+			flags = append(flags, flagArg{name: name})
+		default:
+			flag := flagArg{name: arg}
+			if len(arg) > 2 {
+				for _, c := range arg[1:] {
+					flag.short = append(flag.short, "-"+string(c))
+				}
+			}
+			flags = append(flags, flag)
+		}
+	}
+	return flags
+}
+
+func containsFlag(list []string, flag string) bool {
+	for _, item := range list {
+		if item == flag {
+			return true
+		}
+	}
+	return false
+}
+
+func containsGlob(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if globMatch(pattern, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// globMatch matches shell-style globs; "*" also matches "/" so patterns
+// like "origin/*" or "*.go" work on any argument.
+func globMatch(pattern, value string) bool {
+	if pattern == value {
+		return true
+	}
+	ok, err := path.Match(pattern, value)
+	if ok || err != nil {
+		return ok
+	}
+	if strings.Contains(pattern, "*") {
+		ok, _ = path.Match(strings.ReplaceAll(pattern, "/", "\x00"), strings.ReplaceAll(value, "/", "\x00"))
+	}
+	return ok
+}
+
+// ruleRisk parses a rule's risk level, defaulting by action.
+func ruleRisk(risk, action string) RiskLevel {
+	switch strings.ToLower(risk) {
+	case "low":
+		return RiskLow
+	case "medium":
+		return RiskMedium
+	case "high":
+		return RiskHigh
+	case "critical":
+		return RiskCritical
+	}
+
+	switch action {
+	case PolicyAllow:
+		return RiskLow
+	case PolicyDeny:
+		return RiskHigh
+	default:
+		return RiskMedium
+	}
+}
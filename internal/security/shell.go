@@ -0,0 +1,337 @@
+package security
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// SimpleCommand is one command a shell line would run, found anywhere in
+// it: in a pipeline or list, a subshell or block, a command substitution,
+// or wrapped by a command such as sudo, xargs or sh -c.
+type SimpleCommand struct {
+	// Name is the base name of the program, or "" for a statement that
+	// only assigns variables or redirects.
+	Name string
+	// Args are the arguments after the name, unquoted. Arguments that are
+	// only known at run time are kept as written.
+	Args []string
+	// Assigns are the NAME=value environment assignments before the name.
+	Assigns []string
+	// Redirects are the statement's redirections.
+	Redirects []Redirect
+	// Source is the command as written in the line.
+	Source string
+	// Dynamic is set when the program name is only known at run time,
+	// as in "$cmd args" or "$(which rm) x".
+	Dynamic bool
+}
+
+// Redirect is a redirection such as "> out.txt".
+type Redirect struct {
+	Op     string
+	Target string
+	// Dynamic is set when Target is only known at run time.
+	Dynamic bool
+	// Write reports whether the redirection can create or change Target.
+	Write bool
+}
+
+// shellInterpreters run their -c argument as a nested script.
+var shellInterpreters = map[string]bool{
+	"sh": true, "bash": true, "zsh": true, "dash": true, "ksh": true,
+}
+
+// commandWrappers run the command given in their arguments. The value
+// lists the wrapper's options that take a separate value, so the wrapped
+// command can be found after them.
+var commandWrappers = map[string][]string{
+	"sudo":    {"-u", "-g", "-C", "-h", "-p", "-U", "-r", "-t"},
+	"doas":    {"-u", "-C"},
+	"env":     {"-u", "-C", "-S"},
+	"nice":    {"-n"},
+	"nohup":   nil,
+	"timeout": {"-s", "-k"},
+	"time":    {"-f", "-o"},
+	"command": nil,
+	"exec":    {"-a"},
+	"builtin": nil,
+	"stdbuf":  {"-i", "-o", "-e"},
+	"xargs":   {"-I", "-n", "-P", "-d", "-L", "-E", "-s", "-a"},
+	"watch":   {"-n", "-d"},
+}
+
+// ParseCommand parses a shell line and returns every simple command it
+// would run, in source order.
+func ParseCommand(command string) ([]SimpleCommand, error) {
+	return parseShell(command, 0)
+}
+
+// maxNesting bounds how deep sh -c and eval scripts are followed.
+const maxNesting = 4
+
+func parseShell(command string, depth int) ([]SimpleCommand, error) {
+	file, err := syntax.NewParser(syntax.Variant(syntax.LangBash)).Parse(strings.NewReader(command), "")
+	if err != nil {
+		return nil, err
+	}
+
+	var commands []SimpleCommand
+	var walkErr error
+	syntax.Walk(file, func(node syntax.Node) bool {
+		stmt, ok := node.(*syntax.Stmt)
+		if !ok || walkErr != nil {
+			return walkErr == nil
+		}
+
+		cmd, ok := simpleCommand(command, stmt)
+		if !ok {
+			return true
+		}
+		expanded, err := unwrap(cmd, depth)
+		if err != nil {
+			walkErr = err
+			return false
+		}
+		commands = append(commands, expanded...)
+		return true
+	})
+	return commands, walkErr
+}
+
+// simpleCommand describes the command run by stmt. Compound statements
+// such as subshells, blocks and loops yield nothing themselves unless they
+// redirect; the walk reaches the commands inside them.
+func simpleCommand(src string, stmt *syntax.Stmt) (SimpleCommand, bool) {
+	cmd := SimpleCommand{Source: source(src, stmt)}
+	for _, redir := range stmt.Redirs {
+		cmd.Redirects = append(cmd.Redirects, redirect(redir))
+	}
+
+	switch node := stmt.Cmd.(type) {
+	case *syntax.CallExpr:
+		cmd.Source = source(src, node)
+		for _, assign := range node.Assigns {
+			cmd.Assigns = append(cmd.Assigns, source(src, assign))
+		}
+		if len(node.Args) > 0 {
+			name, literal := wordLiteral(node.Args[0])
+			cmd.Name = filepath.Base(name)
+			cmd.Dynamic = !literal
+			for _, arg := range node.Args[1:] {
+				value, _ := wordLiteral(arg)
+				cmd.Args = append(cmd.Args, value)
+			}
+		}
+		return cmd, true
+
+	case *syntax.DeclClause:
+		cmd.Source = source(src, node)
+		cmd.Name = node.Variant.Value
+		for _, assign := range node.Args {
+			cmd.Assigns = append(cmd.Assigns, source(src, assign))
+		}
+		return cmd, true
+	}
+
+	return cmd, len(cmd.Redirects) > 0
+}
+
+// unwrap returns cmd followed by the commands it runs on its behalf: the
+// command after a wrapper such as sudo, or the script given to sh -c or
+// eval.
+func unwrap(cmd SimpleCommand, depth int) ([]SimpleCommand, error) {
+	commands := []SimpleCommand{cmd}
+	if depth >= maxNesting {
+		return commands, nil
+	}
+
+	switch {
+	case shellInterpreters[cmd.Name]:
+		for i, arg := range cmd.Args {
+			if strings.HasPrefix(arg, "-") && strings.Contains(arg, "c") && i+1 < len(cmd.Args) {
+				nested, err := parseShell(cmd.Args[i+1], depth+1)
+				if err != nil {
+					return nil, fmt.Errorf("%s -c script: %w", cmd.Name, err)
+				}
+				return append(commands, nested...), nil
+			}
+		}
+
+	case cmd.Name == "eval":
+		nested, err := parseShell(strings.Join(cmd.Args, " "), depth+1)
+		if err != nil {
+			return nil, fmt.Errorf("eval script: %w", err)
+		}
+		return append(commands, nested...), nil
+
+	default:
+		valueOpts, ok := commandWrappers[cmd.Name]
+		if !ok {
+			break
+		}
+		wrapped, found := wrappedCommand(cmd, valueOpts)
+		if !found {
+			break
+		}
+		nested, err := unwrap(wrapped, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		return append(commands, nested...), nil
+	}
+
+	return commands, nil
+}
+
+// wrappedCommand finds the command in a wrapper's arguments, skipping the
+// wrapper's options, env's assignments and timeout's duration.
+func wrappedCommand(cmd SimpleCommand, valueOpts []string) (SimpleCommand, bool) {
+	args := cmd.Args
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			continue
+		case strings.HasPrefix(arg, "-"):
+			for _, opt := range valueOpts {
+				if arg == opt {
+					i++
+					break
+				}
+			}
+			continue
+		case cmd.Name == "env" && strings.Contains(arg, "="):
+			continue
+		case cmd.Name == "timeout" && strings.IndexFunc(arg, isDigit) == 0:
+			continue
+		case cmd.Name == "nice" && strings.IndexFunc(arg, isDigit) == 0:
+			continue
+		}
+
+		return SimpleCommand{
+			Name:   filepath.Base(arg),
+			Args:   append([]string(nil), args[i+1:]...),
+			Source: cmd.Source,
+		}, true
+	}
+	return SimpleCommand{}, false
+}
+
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+func redirect(redir *syntax.Redirect) Redirect {
+	r := Redirect{Op: redir.Op.String()}
+	if redir.Word != nil {
+		var literal bool
+		r.Target, literal = wordLiteral(redir.Word)
+		r.Dynamic = !literal
+	}
+
+	switch redir.Op {
+	case syntax.RdrOut, syntax.AppOut, syntax.ClbOut, syntax.RdrInOut, syntax.RdrAll, syntax.AppAll:
+		r.Write = true
+	case syntax.DplOut:
+		// ">&2" duplicates a descriptor; ">&file" is Bash for "&>file".
+		r.Write = strings.IndexFunc(r.Target, func(r rune) bool { return !isDigit(r) && r != '-' }) >= 0
+	}
+	return r
+}
+
+// wordLiteral returns the value of w after quote removal. For words with
+// parts only known at run time it returns the source text and false;
+// $HOME is expanded since it is common in paths.
+func wordLiteral(w *syntax.Word) (string, bool) {
+	var b strings.Builder
+	literal := true
+	for _, part := range w.Parts {
+		switch p := part.(type) {
+		case *syntax.Lit:
+			b.WriteString(unescape(p.Value, false))
+		case *syntax.SglQuoted:
+			b.WriteString(p.Value)
+		case *syntax.DblQuoted:
+			for _, inner := range p.Parts {
+				switch ip := inner.(type) {
+				case *syntax.Lit:
+					b.WriteString(unescape(ip.Value, true))
+				case *syntax.ParamExp:
+					if !writeHome(&b, ip) {
+						literal = false
+						b.WriteString(printNode(ip))
+					}
+				default:
+					literal = false
+					b.WriteString(printNode(inner))
+				}
+			}
+		case *syntax.ParamExp:
+			if !writeHome(&b, p) {
+				literal = false
+				b.WriteString(printNode(p))
+			}
+		default:
+			literal = false
+			b.WriteString(printNode(part))
+		}
+	}
+	return b.String(), literal
+}
+
+// writeHome writes the home directory for a plain $HOME or ${HOME}.
+func writeHome(b *strings.Builder, p *syntax.ParamExp) bool {
+	if p.Param == nil || p.Param.Value != "HOME" || p.Exp != nil || p.Repl != nil ||
+		p.Slice != nil || p.Index != nil || p.Length || p.Excl || p.Names != 0 {
+		return false
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return false
+	}
+	b.WriteString(home)
+	return true
+}
+
+// unescape removes backslash escapes from a literal. Inside double quotes
+// a backslash only escapes $, `, ", \ and newlines.
+func unescape(s string, quoted bool) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		next := s[i+1]
+		if quoted && !strings.ContainsRune("$`\"\\\n", rune(next)) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		if next != '\n' {
+			b.WriteByte(next)
+		}
+	}
+	return b.String()
+}
+
+func source(src string, node syntax.Node) string {
+	start, end := int(node.Pos().Offset()), int(node.End().Offset())
+	if start < 0 || end > len(src) || start > end {
+		return printNode(node)
+	}
+	return src[start:end]
+}
+
+func printNode(node syntax.Node) string {
+	var b strings.Builder
+	_ = syntax.NewPrinter().Print(&b, node)
+	return b.String()
+}
@@ -5,13 +5,19 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/niradler/termu/internal/config"
 )
 
 type Validator struct {
-	config           *config.Config
-	approvedCommands map[string]bool
+	config    *config.Config
+	approvals *approvalStore
+	hook      *PolicyHook
+	hookErr   error
+	// policyFile is the resolved path of the policy file, which the agent
+	// may read but not change; "" when there is none.
+	policyFile string
 }
 
 type ValidationResult struct {
@@ -19,6 +25,14 @@ type ValidationResult struct {
 	Reason        string
 	RiskLevel     RiskLevel
 	NeedsApproval bool
+	// Command is the sub-command of the shell line that caused the
+	// denial or the highest risk.
+	Command string
+	// Policy explains the command policy that decided the result, if any.
+	Policy *PolicyMatch
+	// required is set when a static list or command policy asks for
+	// approval, which an allow rule in the policy file cannot lift.
+	required bool
 }
 
 type RiskLevel int
@@ -30,27 +44,40 @@ const (
 	RiskCritical
 )
 
-func New(cfg *config.Config) *Validator {
-	return &Validator{
-		config:           cfg,
-		approvedCommands: make(map[string]bool),
+func (r RiskLevel) String() string {
+	switch r {
+	case RiskLow:
+		return "low"
+	case RiskMedium:
+		return "medium"
+	case RiskHigh:
+		return "high"
+	case RiskCritical:
+		return "critical"
+	default:
+		return "unknown"
 	}
 }
 
-func (v *Validator) ApproveCommand(command string) {
-	baseCmd := extractBaseCommand(command)
-	v.approvedCommands[baseCmd] = true
-}
-
-func (v *Validator) IsApproved(command string) bool {
-	baseCmd := extractBaseCommand(command)
-	return v.approvedCommands[baseCmd]
-}
-
-func (v *Validator) ClearApprovals() {
-	v.approvedCommands = make(map[string]bool)
+func New(cfg *config.Config) *Validator {
+	v := &Validator{
+		config:    cfg,
+		approvals: newApprovalStore(cfg.ProjectDir()),
+	}
+	if cfg.Security.PolicyFile != "" {
+		path := expandPathFrom(cfg.Security.PolicyFile, cfg.ProjectDir())
+		v.hook, v.hookErr = LoadPolicyHook(path)
+		v.policyFile = path
+		if real, err := realPath(path); err == nil {
+			v.policyFile = real
+		}
+	}
+	return v
 }
 
+// Validate parses command as a shell line and checks each simple command
+// and redirection in it. The result carries the highest risk found and, in
+// Command, the sub-command responsible for it.
 func (v *Validator) Validate(command string, workdir string) *ValidationResult {
 	command = strings.TrimSpace(command)
 
@@ -58,7 +85,7 @@ func (v *Validator) Validate(command string, workdir string) *ValidationResult {
 		return blocked
 	}
 
-	if restricted := v.checkRestrictedFolders(command, workdir); restricted != nil {
+	if restricted := v.checkRestrictedWorkdir(workdir); restricted != nil {
 		return restricted
 	}
 
@@ -66,28 +93,194 @@ func (v *Validator) Validate(command string, workdir string) *ValidationResult {
 		return allowed
 	}
 
-	baseCmd := extractBaseCommand(command)
+	if v.hookErr != nil {
+		return &ValidationResult{
+			Allowed:   false,
+			Reason:    fmt.Sprintf("Policy file could not be loaded: %v", v.hookErr),
+			RiskLevel: RiskHigh,
+		}
+	}
 
-	if !v.isCommandAllowed(baseCmd) {
+	commands, err := ParseCommand(command)
+	if err != nil {
 		return &ValidationResult{
 			Allowed:   false,
-			Reason:    fmt.Sprintf("Command '%s' is not in the allowed list", baseCmd),
-			RiskLevel: RiskMedium,
+			Reason:    fmt.Sprintf("Command could not be parsed as shell: %v", err),
+			RiskLevel: RiskHigh,
+		}
+	}
+	if len(commands) == 0 {
+		return &ValidationResult{
+			Allowed:   false,
+			Reason:    "Empty command",
+			RiskLevel: RiskLow,
 		}
 	}
 
-	riskLevel := v.assessRisk(command)
-	needsApproval := v.needsApproval(command, riskLevel)
+	// A line approved as a whole must match an exact approval; prefix
+	// approvals are matched against each command on its own, so "git push
+	// *" cannot cover what follows it in the line.
+	lineApproved := v.approvals.matchesExactly(command)
+	result := &ValidationResult{Allowed: true, RiskLevel: RiskLow}
+	for _, cmd := range commands {
+		sub := v.validateSimple(cmd, workdir)
+		if !sub.Allowed {
+			if len(commands) > 1 {
+				sub.Reason = fmt.Sprintf("%s (in: %s)", sub.Reason, cmd.Source)
+			}
+			return sub
+		}
 
-	if needsApproval && v.IsApproved(command) {
-		needsApproval = false
+		if sub = v.applyHook(sub, cmd, command, workdir); !sub.Allowed {
+			if len(commands) > 1 {
+				sub.Reason = fmt.Sprintf("%s (in: %s)", sub.Reason, cmd.Source)
+			}
+			return sub
+		}
+
+		if sub.NeedsApproval && (lineApproved || v.approvals.matches(cmd.Source)) {
+			sub.NeedsApproval = false
+		}
+
+		if sub.RiskLevel > result.RiskLevel || (sub.NeedsApproval && !result.NeedsApproval) {
+			result.RiskLevel = max(result.RiskLevel, sub.RiskLevel)
+			result.Reason = sub.Reason
+			result.Command = sub.Command
+			result.Policy = sub.Policy
+		}
+		result.NeedsApproval = result.NeedsApproval || sub.NeedsApproval
 	}
 
-	return &ValidationResult{
-		Allowed:       true,
-		RiskLevel:     riskLevel,
-		NeedsApproval: needsApproval,
+	return result
+}
+
+// validateSimple checks one simple command and its redirections.
+func (v *Validator) validateSimple(cmd SimpleCommand, workdir string) *ValidationResult {
+	deny := func(risk RiskLevel, format string, args ...any) *ValidationResult {
+		return &ValidationResult{
+			Allowed:   false,
+			Reason:    fmt.Sprintf(format, args...),
+			RiskLevel: risk,
+			Command:   cmd.Source,
+		}
+	}
+
+	if cmd.Name != "" && !cmd.Dynamic && !safeBuiltins[cmd.Name] && !v.isCommandAllowed(cmd.Name) {
+		return deny(RiskMedium, "Command '%s' is not in the allowed list", cmd.Name)
+	}
+
+	for _, arg := range cmd.Args {
+		if folder, ok := v.restrictedFolder(arg, workdir); ok {
+			return deny(RiskCritical, "Access to restricted folder: %s", folder)
+		}
+	}
+
+	match := v.evaluatePolicy(cmd)
+	if match != nil && match.Action == PolicyDeny {
+		denied := deny(match.Risk, "Denied by %s", match)
+		denied.Policy = match
+		return denied
+	}
+
+	result := &ValidationResult{Allowed: true, RiskLevel: RiskLow, Command: cmd.Source, Policy: match}
+	raise := func(risk RiskLevel, reason string) {
+		if risk > result.RiskLevel {
+			result.RiskLevel = risk
+			result.Reason = reason
+		}
+	}
+
+	for _, redir := range cmd.Redirects {
+		if folder, ok := v.restrictedFolder(redir.Target, workdir); ok {
+			return deny(RiskCritical, "Access to restricted folder: %s", folder)
+		}
+		if !redir.Write || discardTargets[redir.Target] {
+			continue
+		}
+		if redir.Dynamic {
+			raise(RiskHigh, fmt.Sprintf("redirects output to %s, which is only known at run time", redir.Target))
+			continue
+		}
+		resolved, err := v.ResolvePath(redir.Target, workdir)
+		if err != nil {
+			return deny(RiskHigh, "Redirect target not allowed: %v", err)
+		}
+		if v.isPolicyFile(resolved) {
+			return deny(RiskCritical, "Redirect to the policy file %s is not allowed", redir.Target)
+		}
+		raise(RiskMedium, fmt.Sprintf("writes to %s", redir.Target))
+	}
+
+	for _, assign := range cmd.Assigns {
+		name, _, _ := strings.Cut(assign, "=")
+		if sensitiveVariables[strings.TrimSuffix(name, "+")] {
+			raise(RiskHigh, fmt.Sprintf("overrides %s", name))
+		}
+	}
+
+	if cmd.Dynamic {
+		raise(RiskHigh, "the program to run is only known at run time")
 	}
+
+	// A matching policy rule replaces the built-in assessment of the
+	// command itself; redirects and assignments are still rated above.
+	switch {
+	case match != nil && match.Action == PolicyApprove:
+		raise(match.Risk, match.String())
+		result.NeedsApproval = true
+		result.required = true
+	case match != nil && match.Action == PolicyAllow:
+		raise(match.Risk, "allowed by "+match.String())
+		result.NeedsApproval = v.config.Security.AlwaysApprove || result.RiskLevel >= RiskMedium
+	default:
+		if risk, reason := v.assessRisk(cmd); risk > RiskLow {
+			raise(risk, reason)
+		}
+		result.NeedsApproval = v.needsApproval(cmd, result.RiskLevel)
+		result.required = v.isHighRisk(cmd)
+	}
+
+	// Any command naming the policy file may change it, so the user
+	// decides on it.
+	if v.policyFile != "" {
+		for _, arg := range cmd.Args {
+			if real, err := realPath(expandPathFrom(arg, workdir)); err == nil && v.isPolicyFile(real) {
+				raise(RiskHigh, fmt.Sprintf("names the policy file %s", arg))
+				result.NeedsApproval = true
+				result.required = true
+				break
+			}
+		}
+	}
+	return result
+}
+
+// safeBuiltins are shell builtins that run no program and change nothing
+// outside the shell, so they need not be in the allowed list. Variable
+// assignments they make are still checked.
+var safeBuiltins = map[string]bool{
+	"cd": true, "pushd": true, "popd": true, "true": true, "false": true, ":": true,
+	"test": true, "[": true, "exit": true, "return": true, "break": true, "continue": true,
+	"shift": true, "wait": true, "export": true, "local": true, "declare": true,
+	"typeset": true, "readonly": true,
+}
+
+// destructiveCommands change or remove files. They are also matched as the
+// subcommand of tools such as git rm or kubectl delete.
+var destructiveCommands = map[string]bool{
+	"rm": true, "rmdir": true, "del": true, "remove": true, "delete": true, "unlink": true,
+	"shred": true, "truncate": true, "mv": true, "move": true, "chmod": true, "chown": true,
+}
+
+// discardTargets are redirection targets that write nothing to disk.
+var discardTargets = map[string]bool{
+	"/dev/null": true, "/dev/stdout": true, "/dev/stderr": true, "/dev/tty": true,
+}
+
+// sensitiveVariables change which programs or libraries a command loads.
+var sensitiveVariables = map[string]bool{
+	"PATH": true, "LD_PRELOAD": true, "LD_LIBRARY_PATH": true, "DYLD_INSERT_LIBRARIES": true,
+	"DYLD_LIBRARY_PATH": true, "IFS": true, "BASH_ENV": true, "ENV": true, "PROMPT_COMMAND": true,
 }
 
 func (v *Validator) checkBlockedPatterns(command string) *ValidationResult {
@@ -103,10 +296,9 @@ func (v *Validator) checkBlockedPatterns(command string) *ValidationResult {
 	return nil
 }
 
-func (v *Validator) checkRestrictedFolders(command, workdir string) *ValidationResult {
+func (v *Validator) checkRestrictedWorkdir(workdir string) *ValidationResult {
 	for _, restricted := range v.config.Security.RestrictedFolders {
-		expanded := expandPath(restricted)
-		if strings.Contains(command, expanded) || strings.HasPrefix(workdir, expanded) {
+		if isWithin(workdir, expandPath(restricted)) {
 			return &ValidationResult{
 				Allowed:   false,
 				Reason:    fmt.Sprintf("Access to restricted folder: %s", restricted),
@@ -117,6 +309,43 @@ func (v *Validator) checkRestrictedFolders(command, workdir string) *ValidationR
 	return nil
 }
 
+// restrictedFolder reports the restricted folder a command word refers
+// to, either as a path, as a glob the shell may expand to a path in it,
+// or embedded in an option such as --file=/etc/shadow.
+func (v *Validator) restrictedFolder(word, workdir string) (string, bool) {
+	if word == "" {
+		return "", false
+	}
+	path := expandPathFrom(word, workdir)
+	glob := strings.ContainsAny(word, "*?[")
+	for _, restricted := range v.config.Security.RestrictedFolders {
+		expanded := expandPath(restricted)
+		if isWithin(path, expanded) || strings.Contains(word, expanded) ||
+			strings.Contains(word, restricted) || (glob && globReaches(path, expanded)) {
+			return restricted, true
+		}
+	}
+	return "", false
+}
+
+// globReaches reports whether the glob pattern can match root or a path
+// inside it: every element of root is matched by the pattern's element at
+// the same depth. A pattern that only reaches a parent of root, such as
+// /e*, does not count, just as the parent itself does not.
+func globReaches(pattern, root string) bool {
+	patterns := strings.Split(filepath.ToSlash(pattern), "/")
+	roots := strings.Split(filepath.ToSlash(root), "/")
+	if len(patterns) < len(roots) {
+		return false
+	}
+	for i, elem := range roots {
+		if ok, err := filepath.Match(patterns[i], elem); !ok || err != nil {
+			return false
+		}
+	}
+	return true
+}
+
 func (v *Validator) checkAllowedFolders(command, workdir string) *ValidationResult {
 	if len(v.config.Security.AllowedFolders) == 0 {
 		return nil
@@ -137,6 +366,38 @@ func (v *Validator) checkAllowedFolders(command, workdir string) *ValidationResu
 	}
 }
 
+// AllowsWorkdir reports whether commands may run in workdir at all under
+// the restricted and allowed folder rules.
+func (v *Validator) AllowsWorkdir(workdir string) bool {
+	return v.checkRestrictedWorkdir(workdir) == nil && v.checkAllowedFolders("", workdir) == nil
+}
+
+// CommandTimeout returns how long command may run. Programs in the line
+// with their own timeout override the default, the longest one winning; a
+// zero timeout means no limit.
+func (v *Validator) CommandTimeout(command string) time.Duration {
+	seconds, overridden := 0, false
+	if commands, err := ParseCommand(command); err == nil {
+		for _, cmd := range commands {
+			timeout, ok := v.config.Security.CommandTimeouts[cmd.Name]
+			if !ok {
+				continue
+			}
+			if timeout <= 0 {
+				return 0
+			}
+			seconds, overridden = max(seconds, timeout), true
+		}
+	}
+	if !overridden {
+		seconds = v.config.Security.CommandTimeout
+	}
+	if seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
 func (v *Validator) isCommandAllowed(cmd string) bool {
 	if len(v.config.Security.AllowedCommands) == 0 {
 		return true
@@ -150,59 +411,60 @@ func (v *Validator) isCommandAllowed(cmd string) bool {
 	return false
 }
 
-func (v *Validator) assessRisk(command string) RiskLevel {
-	baseCmd := extractBaseCommand(command)
-
-	for _, highRisk := range v.config.Security.HighRiskCommands {
-		if strings.HasPrefix(command, highRisk) {
-			return RiskHigh
-		}
+// assessRisk rates a simple command by its program and arguments.
+func (v *Validator) assessRisk(cmd SimpleCommand) (RiskLevel, string) {
+	if v.isHighRisk(cmd) {
+		return RiskHigh, fmt.Sprintf("'%s' is a high-risk command", cmd.Name)
 	}
 
-	destructivePatterns := []string{
-		"rm ", "del ", "remove", "delete",
-		"mv ", "move", "chmod", "chown",
-		">", ">>",
+	if destructiveCommands[cmd.Name] || destructiveCommands[subcommand(cmd)] {
+		return RiskMedium, "may modify or delete files"
 	}
 
-	for _, pattern := range destructivePatterns {
-		if strings.Contains(command, pattern) {
-			return RiskMedium
-		}
+	if shellInterpreters[cmd.Name] && subcommand(cmd) == "" && !hasShellScriptFlag(cmd) {
+		return RiskHigh, fmt.Sprintf("'%s' runs shell code read from its input", cmd.Name)
 	}
 
-	if baseCmd == "curl" && strings.Contains(command, "-X POST") {
-		return RiskMedium
+	if cmd.Name == "curl" && strings.Contains(strings.Join(cmd.Args, " "), "-X POST") {
+		return RiskMedium, "sends data to a remote server"
 	}
 
-	return RiskLow
+	return RiskLow, ""
 }
 
-func (v *Validator) needsApproval(command string, risk RiskLevel) bool {
+func (v *Validator) needsApproval(cmd SimpleCommand, risk RiskLevel) bool {
 	if v.config.Security.AlwaysApprove {
 		return true
 	}
 
-	if risk >= RiskMedium {
-		return true
-	}
+	return risk >= RiskMedium || v.isHighRisk(cmd)
+}
 
-	baseCmd := extractBaseCommand(command)
+// isHighRisk reports whether cmd runs a program in the high-risk list.
+func (v *Validator) isHighRisk(cmd SimpleCommand) bool {
 	for _, highRisk := range v.config.Security.HighRiskCommands {
-		if baseCmd == highRisk {
+		if cmd.Name == highRisk {
 			return true
 		}
 	}
-
 	return false
 }
 
-func extractBaseCommand(command string) string {
-	parts := strings.Fields(command)
-	if len(parts) == 0 {
-		return ""
+// subcommand returns the first argument that is not an option, such as
+// "rm" in "git rm file", skipping the values of known global options as in
+// "git -C dir rm file".
+func subcommand(cmd SimpleCommand) string {
+	return leadingOptions(cmd).sub
+}
+
+// hasShellScriptFlag reports whether a shell is given its script with -c.
+func hasShellScriptFlag(cmd SimpleCommand) bool {
+	for _, arg := range cmd.Args {
+		if strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "--") && strings.Contains(arg, "c") {
+			return true
+		}
 	}
-	return filepath.Base(parts[0])
+	return false
 }
 
 func expandPath(path string) string {
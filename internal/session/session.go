@@ -0,0 +1,241 @@
+package session
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/niradler/termu/internal/config"
+	"github.com/niradler/termu/internal/usage"
+)
+
+// ErrNotFound is returned when no session matches the requested ID.
+var ErrNotFound = errors.New("session not found")
+
+// Session is a saved chat: the transcript shown in the TUI and the model
+// history, including tool requests and responses, needed to continue it.
+type Session struct {
+	ID         string        `json:"id"`
+	Title      string        `json:"title"`
+	Workdir    string        `json:"workdir"`
+	Model      string        `json:"model"`
+	CreatedAt  time.Time     `json:"created_at"`
+	UpdatedAt  time.Time     `json:"updated_at"`
+	Transcript []Entry       `json:"transcript"`
+	History    []*ai.Message `json:"history"`
+	Usage      usage.Tally   `json:"usage,omitempty"` // Tokens used, by model
+}
+
+// Entry is one message of the displayed transcript.
+type Entry struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+// New starts an empty session for workdir.
+func New(workdir, model string) *Session {
+	now := time.Now()
+	return &Session{
+		ID:        newID(now),
+		Workdir:   workdir,
+		Model:     model,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
+
+// SetTitleFrom names the session after its first user message.
+func (s *Session) SetTitleFrom(text string) {
+	if s.Title != "" {
+		return
+	}
+	title := strings.Join(strings.Fields(text), " ")
+	if runes := []rune(title); len(runes) > 60 {
+		title = string(runes[:57]) + "..."
+	}
+	s.Title = title
+}
+
+// Store keeps the sessions of one working directory as JSON files under
+// ~/.termu/sessions/<workdir-key>/.
+type Store struct {
+	dir string
+}
+
+// DefaultDir returns the root directory holding all saved sessions.
+func DefaultDir() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".termu", "sessions")
+}
+
+// NewStore returns the session store for workdir.
+func NewStore(workdir string) *Store {
+	return &Store{dir: filepath.Join(DefaultDir(), config.WorkdirKey(workdir))}
+}
+
+func (s *Store) Save(sess *Session) error {
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return fmt.Errorf("failed to create session directory: %w", err)
+	}
+
+	sess.UpdatedAt = time.Now()
+	data, err := json.MarshalIndent(sess, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode session: %w", err)
+	}
+
+	path := s.path(sess.ID)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write session: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// Load returns the session with the given ID or unique ID prefix.
+func (s *Store) Load(id string) (*Session, error) {
+	sessions, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var match *Session
+	for _, sess := range sessions {
+		if sess.ID == id {
+			return sess, nil
+		}
+		if strings.HasPrefix(sess.ID, id) {
+			if match != nil {
+				return nil, fmt.Errorf("session ID %q is ambiguous", id)
+			}
+			match = sess
+		}
+	}
+	if match == nil {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	return match, nil
+}
+
+// Latest returns the most recently updated session.
+func (s *Store) Latest() (*Session, error) {
+	sessions, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	if len(sessions) == 0 {
+		return nil, ErrNotFound
+	}
+	return sessions[0], nil
+}
+
+// List returns every session of the working directory, newest first.
+func (s *Store) List() ([]*Session, error) {
+	entries, err := os.ReadDir(s.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session directory: %w", err)
+	}
+
+	var sessions []*Session
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		sess, err := readSession(filepath.Join(s.dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		sessions = append(sessions, sess)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].UpdatedAt.After(sessions[j].UpdatedAt)
+	})
+	return sessions, nil
+}
+
+// ListAll returns the sessions of every working directory, newest first.
+func ListAll() ([]*Session, error) {
+	entries, err := os.ReadDir(DefaultDir())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session directory: %w", err)
+	}
+
+	var sessions []*Session
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		store := &Store{dir: filepath.Join(DefaultDir(), entry.Name())}
+		found, err := store.List()
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, found...)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].UpdatedAt.After(sessions[j].UpdatedAt)
+	})
+	return sessions, nil
+}
+
+// Delete removes a session and its file checkpoints.
+func (s *Store) Delete(id string) error {
+	sess, err := s.Load(id)
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(s.CheckpointDir(sess.ID)); err != nil {
+		return fmt.Errorf("failed to delete checkpoints: %w", err)
+	}
+	return os.Remove(s.path(sess.ID))
+}
+
+// CheckpointRoot returns the directory holding the file checkpoints of
+// every session of the working directory.
+func (s *Store) CheckpointRoot() string {
+	return filepath.Join(s.dir, "checkpoints")
+}
+
+// CheckpointDir returns the directory holding one session's checkpoints.
+func (s *Store) CheckpointDir(id string) string {
+	return filepath.Join(s.CheckpointRoot(), id)
+}
+
+func (s *Store) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+func readSession(path string) (*Session, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var sess Session
+	if err := json.Unmarshal(data, &sess); err != nil {
+		return nil, err
+	}
+	return &sess, nil
+}
+
+func newID(now time.Time) string {
+	suffix := make([]byte, 2)
+	_, _ = rand.Read(suffix)
+	return now.Format("20060102-150405") + "-" + hex.EncodeToString(suffix)
+}
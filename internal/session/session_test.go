@@ -0,0 +1,32 @@
+package session
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSetTitleFrom(t *testing.T) {
+	tests := []struct {
+		text, want string
+	}{
+		{"  fix the\n  login   bug ", "fix the login bug"},
+		{strings.Repeat("a", 60), strings.Repeat("a", 60)},
+		{strings.Repeat("a", 61), strings.Repeat("a", 57) + "..."},
+		{strings.Repeat("é", 61), strings.Repeat("é", 57) + "..."},
+		{strings.Repeat("日本", 40), strings.Repeat("日本", 28) + "日..."},
+	}
+	for _, tt := range tests {
+		s := &Session{}
+		s.SetTitleFrom(tt.text)
+		if s.Title != tt.want || !utf8.ValidString(s.Title) {
+			t.Errorf("SetTitleFrom(%q) = %q, want %q", tt.text, s.Title, tt.want)
+		}
+	}
+
+	s := &Session{Title: "kept"}
+	s.SetTitleFrom("another message")
+	if s.Title != "kept" {
+		t.Errorf("title replaced with %q", s.Title)
+	}
+}
@@ -0,0 +1,63 @@
+package shell
+
+import (
+	"os"
+	"path"
+	"strings"
+)
+
+// minSecretLength is the shortest withheld value treated as a secret;
+// shorter values such as "1" would match all over ordinary output.
+const minSecretLength = 8
+
+// SetEnvironment limits the environment commands inherit from termu.
+// Variables are passed when their name matches an allow pattern, or any
+// name when allow is empty, and no deny pattern. Patterns are globs
+// matched case-insensitively, like *_TOKEN.
+func (e *Executor) SetEnvironment(allow, deny []string) {
+	e.envAllow = allow
+	e.envDeny = deny
+}
+
+// environ returns the environment commands run with.
+func (e *Executor) environ() []string {
+	var env []string
+	for _, entry := range os.Environ() {
+		name, _, _ := strings.Cut(entry, "=")
+		if e.passesEnv(name) {
+			env = append(env, entry)
+		}
+	}
+	return env
+}
+
+// WithheldSecrets returns the values of the variables in termu's own
+// environment that the deny list keeps from commands, so they can be
+// masked should they turn up in output anyway.
+func (e *Executor) WithheldSecrets() []string {
+	var secrets []string
+	for _, entry := range os.Environ() {
+		name, value, _ := strings.Cut(entry, "=")
+		if len(value) >= minSecretLength && matchEnv(name, e.envDeny) {
+			secrets = append(secrets, value)
+		}
+	}
+	return secrets
+}
+
+func (e *Executor) passesEnv(name string) bool {
+	if len(e.envAllow) > 0 && !matchEnv(name, e.envAllow) {
+		return false
+	}
+	return !matchEnv(name, e.envDeny)
+}
+
+func matchEnv(name string, patterns []string) bool {
+	name = strings.ToUpper(name)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToUpper(pattern), name); ok {
+			return true
+		}
+	}
+	return false
+}
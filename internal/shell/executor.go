@@ -2,14 +2,43 @@ package shell
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os/exec"
 	"runtime"
+	"sync"
+	"time"
 )
 
+// DefaultMaxOutput is how much of a command's output is kept when no
+// limit is set.
+const DefaultMaxOutput = 32 << 10
+
+// waitDelay is how long a command's output is still read after it exits or
+// is killed, in case something it started keeps the output open.
+const waitDelay = 2 * time.Second
+
 type Executor struct {
-	workdir string
-	sandbox bool
+	workdir  string
+	sandbox  bool
+	recorder CommandRecorder
+	// isolator runs commands isolated from the system; see isolation.go.
+	isolator  *isolator
+	maxOutput int
+	// envAllow and envDeny filter the environment commands inherit; see
+	// environment.go.
+	envAllow []string
+	envDeny  []string
+
+	// mu guards the saved output of truncated commands; see output.go.
+	mu       sync.Mutex
+	spillDir string
+	spills   int
+}
+
+// CommandRecorder receives the commands sandbox mode declined to run.
+type CommandRecorder interface {
+	RecordCommand(command string)
 }
 
 type ExecutionResult struct {
@@ -18,34 +47,93 @@ type ExecutionResult struct {
 	Error    string
 	ExitCode int
 	Executed bool
+	// TimedOut and Cancelled report that the command was killed, along
+	// with everything it started, because ctx expired or was cancelled.
+	TimedOut  bool
+	Cancelled bool
+	// Truncated reports that Output only holds the start and end of the
+	// TotalBytes written. When OutputID is set, the full output can be read
+	// with ReadOutput.
+	Truncated  bool
+	TotalBytes int64
+	OutputID   int
 }
 
 func New(workdir string, sandbox bool) *Executor {
 	return &Executor{
-		workdir: workdir,
-		sandbox: sandbox,
+		workdir:   workdir,
+		sandbox:   sandbox,
+		maxOutput: DefaultMaxOutput,
 	}
 }
 
+// SetOutputLimit sets how many bytes of a command's output are returned.
+// Longer output is cut to its start and end; 0 keeps all of it.
+func (e *Executor) SetOutputLimit(maxBytes int) {
+	e.maxOutput = maxBytes
+}
+
+// SetRecorder registers where sandbox mode reports skipped commands.
+func (e *Executor) SetRecorder(recorder CommandRecorder) {
+	e.recorder = recorder
+}
+
+func (e *Executor) Sandboxed() bool {
+	return e.sandbox
+}
+
 func (e *Executor) Execute(ctx context.Context, command string) (*ExecutionResult, error) {
 	result := &ExecutionResult{
 		Command:  command,
-		Executed: !e.sandbox,
+		Executed: !e.sandbox || e.isolator != nil,
 	}
 
-	if e.sandbox {
+	if !result.Executed {
+		if e.recorder != nil {
+			e.recorder.RecordCommand(command)
+		}
 		result.Output = "[SANDBOX MODE] Command would be executed: " + command
 		return result, nil
 	}
 
-	shell, shellArg := getShell()
-	cmd := exec.CommandContext(ctx, shell, shellArg, command)
+	var cmd *exec.Cmd
+	if e.isolator != nil {
+		isolated, cleanup, err := e.isolator.command(ctx, command, e.workdir, e.environ(), !e.sandbox)
+		if err != nil {
+			return nil, fmt.Errorf("failed to isolate command: %w", err)
+		}
+		defer cleanup()
+		cmd = isolated
+	} else {
+		shell, shellArg := getShell()
+		cmd = exec.CommandContext(ctx, shell, shellArg, command)
+		cmd.Env = e.environ()
+	}
 	cmd.Dir = e.workdir
+	setProcessGroup(cmd)
+	cmd.Cancel = func() error { return killProcessGroup(cmd) }
+	cmd.WaitDelay = waitDelay
 
-	output, err := cmd.CombinedOutput()
-	result.Output = string(output)
+	output := &outputBuffer{limit: e.maxOutput, open: e.openSpill}
+	cmd.Stdout = output
+	cmd.Stderr = output
+	err := cmd.Run()
+	output.close()
 
+	result.Output = output.String()
+	result.TotalBytes = output.total
+	result.Truncated = output.truncated()
+	result.OutputID = output.spillID
 	if err != nil {
+		switch ctx.Err() {
+		case context.DeadlineExceeded:
+			result.TimedOut = true
+		case context.Canceled:
+			result.Cancelled = true
+		}
+	}
+
+	if err != nil && !errors.Is(err, exec.ErrWaitDelay) {
 		if exitErr, ok := err.(*exec.ExitError); ok {
 			result.ExitCode = exitErr.ExitCode()
 			result.Error = err.Error()
@@ -57,6 +145,12 @@ func (e *Executor) Execute(ctx context.Context, command string) (*ExecutionResul
 	return result, nil
 }
 
+// Name returns the shell commands are run with.
+func Name() string {
+	name, _ := getShell()
+	return name
+}
+
 func getShell() (string, string) {
 	if runtime.GOOS == "windows" {
 		return "powershell.exe", "-Command"
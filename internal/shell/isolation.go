@@ -0,0 +1,51 @@
+package shell
+
+import "fmt"
+
+// Isolation modes for the security.isolation setting.
+const (
+	// IsolationNone runs commands directly.
+	IsolationNone = "none"
+	// IsolationLinux runs commands with a read-only view of the filesystem
+	// outside the working directory, no network and no way to gain
+	// privileges. It is an error when the system cannot provide it.
+	IsolationLinux = "linux"
+	// IsolationAuto uses IsolationLinux where available and runs commands
+	// directly elsewhere.
+	IsolationAuto = "auto"
+)
+
+// Isolate selects how commands are isolated from the rest of the system.
+// In sandbox mode isolated commands really run, with the working directory
+// read-only as well, instead of only being recorded.
+func (e *Executor) Isolate(mode string) error {
+	switch mode {
+	case "", IsolationNone:
+		e.isolator = nil
+		return nil
+
+	case IsolationLinux, IsolationAuto:
+		isolator, err := newIsolator()
+		if err != nil {
+			if mode == IsolationAuto {
+				e.isolator = nil
+				return nil
+			}
+			return fmt.Errorf("command isolation is not available: %w", err)
+		}
+		e.isolator = isolator
+		return nil
+
+	default:
+		return fmt.Errorf("unknown isolation mode %q (want none, auto or linux)", mode)
+	}
+}
+
+// Isolation describes the isolation commands run under, or returns "" when
+// they run directly.
+func (e *Executor) Isolation() string {
+	if e.isolator == nil {
+		return ""
+	}
+	return e.isolator.String()
+}
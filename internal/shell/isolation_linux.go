@@ -0,0 +1,272 @@
+//go:build linux
+
+package shell
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Isolated commands are started through termu itself: the helper process
+// is created in new user and network namespaces, restricts itself with
+// no_new_privs, landlock and seccomp, and then executes the shell. Landlock
+// and seccomp only apply to the thread that installs them and what it
+// executes, so they cannot be set up from the multi-threaded parent.
+const isolationHelperArg = "__termu_isolate"
+
+// isolationFailedExit is the helper's exit code when it cannot set up the
+// isolation, so the command never ran.
+const isolationFailedExit = 126
+
+// devices stay writable so redirections such as 2>/dev/null work.
+var devices = []string{"/dev/null", "/dev/zero", "/dev/full", "/dev/tty"}
+
+type isolator struct {
+	self        string
+	landlockABI int
+	namespaces  bool
+}
+
+// newIsolator checks what the kernel supports and verifies the helper by
+// running true through it.
+func newIsolator() (*isolator, error) {
+	abi, err := landlockABI()
+	if err != nil {
+		return nil, fmt.Errorf("landlock is not available: %w", err)
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	i := &isolator{self: self, landlockABI: abi, namespaces: true}
+	if err := i.probe(); err != nil {
+		// User namespaces may be disabled; landlock can still block TCP.
+		i.namespaces = false
+		if abi < 4 {
+			return nil, fmt.Errorf("cannot block network access: %w", err)
+		}
+		if err := i.probe(); err != nil {
+			return nil, err
+		}
+	}
+	return i, nil
+}
+
+func (i *isolator) probe() error {
+	cmd, cleanup, err := i.command(context.Background(), "true", os.TempDir(), os.Environ(), false)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	if output, err := cmd.CombinedOutput(); err != nil {
+		if msg := strings.TrimSpace(string(output)); msg != "" {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
+
+// command prepares an isolated run of command in workdir with env. Besides
+// the working directory, when writeWorkdir is set, only a private temporary
+// directory is writable; cleanup removes it.
+func (i *isolator) command(ctx context.Context, command, workdir string, env []string, writeWorkdir bool) (*exec.Cmd, func(), error) {
+	tmp, err := os.MkdirTemp("", "termu-isolated-")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	cleanup := func() { os.RemoveAll(tmp) }
+
+	args := []string{isolationHelperArg, "-w", tmp}
+	if writeWorkdir {
+		args = append(args, "-w", workdir)
+	}
+	for _, device := range devices {
+		args = append(args, "-w", device)
+	}
+	shell, shellArg := getShell()
+	args = append(args, "--", shell, shellArg, command)
+
+	cmd := exec.CommandContext(ctx, i.self, args...)
+	cmd.Dir = workdir
+	cmd.Env = append(env, "TMPDIR="+tmp)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Pdeathsig: syscall.SIGKILL}
+	if i.namespaces {
+		uid, gid := os.Getuid(), os.Getgid()
+		cmd.SysProcAttr.Cloneflags = syscall.CLONE_NEWUSER | syscall.CLONE_NEWNET | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS
+		cmd.SysProcAttr.UidMappings = []syscall.SysProcIDMap{{ContainerID: uid, HostID: uid, Size: 1}}
+		cmd.SysProcAttr.GidMappings = []syscall.SysProcIDMap{{ContainerID: gid, HostID: gid, Size: 1}}
+		cmd.SysProcAttr.GidMappingsEnableSetgroups = false
+	}
+	return cmd, cleanup, nil
+}
+
+func (i *isolator) String() string {
+	parts := []string{fmt.Sprintf("landlock v%d", i.landlockABI)}
+	if i.namespaces {
+		parts = append(parts, "network namespace")
+	} else {
+		parts = append(parts, "landlock TCP filter")
+	}
+	if seccompSupported() {
+		parts = append(parts, "seccomp")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// RunIsolationHelper turns the process into the isolation helper when
+// termu was started as one: it restricts itself and executes the command,
+// never returning. Otherwise it returns at once. main must call it before
+// doing anything else.
+func RunIsolationHelper() {
+	if len(os.Args) < 2 || os.Args[1] != isolationHelperArg {
+		return
+	}
+
+	err := runIsolated(os.Args[2:])
+	fmt.Fprintf(os.Stderr, "termu: isolation: %v\n", err)
+	os.Exit(isolationFailedExit)
+}
+
+// runIsolated parses "-w <path>... -- <argv>", restricts the current
+// thread and executes argv. It only returns on failure.
+func runIsolated(args []string) error {
+	var writable []string
+	for len(args) > 0 && args[0] != "--" {
+		if args[0] != "-w" || len(args) < 2 {
+			return fmt.Errorf("unexpected argument %q", args[0])
+		}
+		writable = append(writable, args[1])
+		args = args[2:]
+	}
+	if len(args) < 2 {
+		return errors.New("no command given")
+	}
+	argv := args[1:]
+
+	path, err := exec.LookPath(argv[0])
+	if err != nil {
+		return err
+	}
+
+	runtime.LockOSThread()
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("failed to set no_new_privs: %w", err)
+	}
+	dropCapabilities()
+	if err := restrictFilesystem(writable); err != nil {
+		return err
+	}
+	if err := restrictSyscalls(); err != nil {
+		return err
+	}
+	return syscall.Exec(path, argv, os.Environ())
+}
+
+// dropCapabilities empties the bounding set so the command starts without
+// capabilities even when run as root. Without CAP_SETPCAP the process has
+// none to drop.
+func dropCapabilities() {
+	for c := 0; c <= 63; c++ {
+		if err := unix.Prctl(unix.PR_CAPBSET_DROP, uintptr(c), 0, 0, 0); err == unix.EINVAL {
+			return
+		}
+	}
+}
+
+func landlockABI() (int, error) {
+	abi, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, 0, 0, unix.LANDLOCK_CREATE_RULESET_VERSION)
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(abi), nil
+}
+
+// landlockWriteAccess is every right that changes the filesystem in the
+// given landlock ABI version. Reading and executing stay unrestricted.
+func landlockWriteAccess(abi int) uint64 {
+	access := uint64(unix.LANDLOCK_ACCESS_FS_WRITE_FILE |
+		unix.LANDLOCK_ACCESS_FS_REMOVE_DIR |
+		unix.LANDLOCK_ACCESS_FS_REMOVE_FILE |
+		unix.LANDLOCK_ACCESS_FS_MAKE_CHAR |
+		unix.LANDLOCK_ACCESS_FS_MAKE_DIR |
+		unix.LANDLOCK_ACCESS_FS_MAKE_REG |
+		unix.LANDLOCK_ACCESS_FS_MAKE_SOCK |
+		unix.LANDLOCK_ACCESS_FS_MAKE_FIFO |
+		unix.LANDLOCK_ACCESS_FS_MAKE_BLOCK |
+		unix.LANDLOCK_ACCESS_FS_MAKE_SYM)
+	if abi >= 2 {
+		access |= unix.LANDLOCK_ACCESS_FS_REFER
+	}
+	if abi >= 3 {
+		access |= unix.LANDLOCK_ACCESS_FS_TRUNCATE
+	}
+	return access
+}
+
+// restrictFilesystem makes everything read-only except the writable paths,
+// and with landlock ABI 4 or later also blocks TCP.
+func restrictFilesystem(writable []string) error {
+	abi, err := landlockABI()
+	if err != nil {
+		return fmt.Errorf("landlock is not available: %w", err)
+	}
+
+	access := landlockWriteAccess(abi)
+	attr := unix.LandlockRulesetAttr{Access_fs: access}
+	if abi >= 4 {
+		attr.Access_net = unix.LANDLOCK_ACCESS_NET_BIND_TCP | unix.LANDLOCK_ACCESS_NET_CONNECT_TCP
+	}
+	fd, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return fmt.Errorf("failed to create landlock ruleset: %w", errno)
+	}
+	ruleset := int(fd)
+	defer unix.Close(ruleset)
+
+	for _, path := range writable {
+		if err := allowWrite(ruleset, path, access); err != nil {
+			return err
+		}
+	}
+
+	if _, _, errno := unix.Syscall(unix.SYS_LANDLOCK_RESTRICT_SELF, uintptr(ruleset), 0, 0); errno != 0 {
+		return fmt.Errorf("failed to enforce landlock ruleset: %w", errno)
+	}
+	return nil
+}
+
+// allowWrite grants access beneath path. Paths that do not exist are
+// skipped; files only get the rights that apply to files.
+func allowWrite(ruleset int, path string, access uint64) error {
+	fd, err := unix.Open(path, unix.O_PATH|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil
+	}
+	defer unix.Close(fd)
+
+	var st unix.Stat_t
+	if err := unix.Fstat(fd, &st); err != nil {
+		return nil
+	}
+	if st.Mode&unix.S_IFMT != unix.S_IFDIR {
+		access &= unix.LANDLOCK_ACCESS_FS_WRITE_FILE | unix.LANDLOCK_ACCESS_FS_TRUNCATE
+	}
+
+	rule := unix.LandlockPathBeneathAttr{Allowed_access: access, Parent_fd: int32(fd)}
+	_, _, errno := unix.Syscall6(unix.SYS_LANDLOCK_ADD_RULE, uintptr(ruleset), unix.LANDLOCK_RULE_PATH_BENEATH, uintptr(unsafe.Pointer(&rule)), 0, 0, 0)
+	if errno != 0 {
+		return fmt.Errorf("failed to allow writes to %s: %w", path, errno)
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+//go:build !linux
+
+package shell
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+)
+
+type isolator struct{}
+
+func newIsolator() (*isolator, error) {
+	return nil, errors.New("isolation is only supported on Linux")
+}
+
+func (i *isolator) command(ctx context.Context, command, workdir string, env []string, writeWorkdir bool) (*exec.Cmd, func(), error) {
+	return nil, nil, errors.New("isolation is only supported on Linux")
+}
+
+func (i *isolator) String() string {
+	return ""
+}
+
+// RunIsolationHelper is a no-op outside Linux.
+func RunIsolationHelper() {}
@@ -0,0 +1,224 @@
+package shell
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxSpillBytes bounds the spill file of a single command.
+const maxSpillBytes = 64 << 20
+
+// maxSpillLine bounds each line returned when paging a spill file.
+const maxSpillLine = 2000
+
+// outputBuffer collects a command's combined output. It keeps the first
+// and last limit/2 bytes in memory; once the output exceeds limit, all of
+// it is also written to a spill file, up to maxSpillBytes.
+type outputBuffer struct {
+	limit int
+	open  func() (*os.File, int, error)
+
+	head  []byte
+	tail  []byte
+	total int64
+
+	spill    *os.File
+	spillID  int
+	spillErr error
+	spilled  int64
+}
+
+func (b *outputBuffer) Write(p []byte) (int, error) {
+	b.total += int64(len(p))
+	if b.limit <= 0 {
+		b.head = append(b.head, p...)
+		return len(p), nil
+	}
+
+	half := b.limit / 2
+	rest := p
+	if room := half - len(b.head); room > 0 {
+		n := min(room, len(rest))
+		b.head = append(b.head, rest[:n]...)
+		rest = rest[n:]
+	}
+	b.tail = append(b.tail, rest...)
+
+	if b.total > int64(b.limit) {
+		if b.spill == nil && b.spillErr == nil {
+			// Nothing has been dropped yet, so head and tail hold it all.
+			b.spill, b.spillID, b.spillErr = b.open()
+			b.writeSpill(b.head)
+			b.writeSpill(b.tail)
+		} else {
+			b.writeSpill(p)
+		}
+		if keep := b.limit - half; len(b.tail) > 2*keep {
+			b.tail = append(b.tail[:0], b.tail[len(b.tail)-keep:]...)
+		}
+	}
+	return len(p), nil
+}
+
+func (b *outputBuffer) writeSpill(p []byte) {
+	if b.spill == nil || b.spilled >= maxSpillBytes {
+		return
+	}
+	if room := maxSpillBytes - b.spilled; int64(len(p)) > room {
+		p = p[:room]
+	}
+	n, err := b.spill.Write(p)
+	b.spilled += int64(n)
+	if err != nil {
+		b.spillErr = err
+		b.spill.Close()
+		b.spill = nil
+	}
+}
+
+// close finishes the spill file, if any.
+func (b *outputBuffer) close() {
+	if b.spill != nil {
+		b.spill.Close()
+	}
+}
+
+// truncated reports whether the output exceeded the limit.
+func (b *outputBuffer) truncated() bool {
+	return b.limit > 0 && b.total > int64(b.limit)
+}
+
+// String returns the whole output, or when it was truncated its start and
+// end cut at line boundaries around a note of how much was left out.
+func (b *outputBuffer) String() string {
+	if !b.truncated() {
+		return string(b.head) + string(b.tail)
+	}
+
+	head := b.head
+	if i := bytes.LastIndexByte(head, '\n'); i > 0 {
+		head = head[:i+1]
+	}
+	tail := b.tail
+	if keep := b.limit - b.limit/2; len(tail) > keep {
+		tail = tail[len(tail)-keep:]
+	}
+	if i := bytes.IndexByte(tail, '\n'); i >= 0 && i < len(tail)-1 {
+		tail = tail[i+1:]
+	}
+
+	omitted := b.total - int64(len(head)) - int64(len(tail))
+	return fmt.Sprintf("%s\n[... %d bytes omitted ...]\n\n%s", strings.ToValidUTF8(string(head), ""), omitted, strings.ToValidUTF8(string(tail), ""))
+}
+
+// openSpill creates the spill file for the next truncated command.
+func (e *Executor) openSpill() (*os.File, int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.spillDir == "" {
+		dir, err := os.MkdirTemp("", "termu-output-")
+		if err != nil {
+			return nil, 0, err
+		}
+		e.spillDir = dir
+	}
+	e.spills++
+	file, err := os.Create(e.spillPath(e.spills))
+	if err != nil {
+		return nil, 0, err
+	}
+	return file, e.spills, nil
+}
+
+func (e *Executor) spillPath(id int) string {
+	return filepath.Join(e.spillDir, fmt.Sprintf("%d.log", id))
+}
+
+// ReadOutput returns lines offset to offset+limit-1, counted from 1, of
+// the full output saved for a truncated command, with a header saying
+// which lines they are.
+func (e *Executor) ReadOutput(id, offset, limit int) (string, error) {
+	e.mu.Lock()
+	known := e.spillDir != "" && id >= 1 && id <= e.spills
+	path := ""
+	if known {
+		path = e.spillPath(id)
+	}
+	e.mu.Unlock()
+	if !known {
+		return "", fmt.Errorf("no saved output %d", id)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read saved output %d: %w", id, err)
+	}
+	defer file.Close()
+
+	if offset < 1 {
+		offset = 1
+	}
+	var b strings.Builder
+	reader := bufio.NewReader(file)
+	lines, shown := 0, 0
+	for {
+		line, err := readLine(reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		lines++
+		if lines >= offset && shown < limit {
+			b.WriteString(line)
+			b.WriteString("\n")
+			shown++
+		}
+	}
+
+	if shown == 0 {
+		return fmt.Sprintf("[saved output %d has %d lines; nothing at line %d]", id, lines, offset), nil
+	}
+	return fmt.Sprintf("[saved output %d: lines %d-%d of %d]\n%s", id, offset, offset+shown-1, lines, b.String()), nil
+}
+
+// readLine reads one line without its newline, cut to maxSpillLine.
+func readLine(reader *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if len(line) < maxSpillLine {
+			line = append(line, chunk[:min(len(chunk), maxSpillLine-len(line))]...)
+		}
+		switch {
+		case errors.Is(err, bufio.ErrBufferFull):
+			continue
+		case err == io.EOF && len(chunk) > 0:
+			err = nil
+		}
+		if err != nil {
+			return "", err
+		}
+		return strings.ToValidUTF8(strings.TrimRight(string(line), "\r\n"), ""), nil
+	}
+}
+
+// Close removes the saved output of truncated commands.
+func (e *Executor) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.spillDir == "" {
+		return nil
+	}
+	err := os.RemoveAll(e.spillDir)
+	e.spillDir, e.spills = "", 0
+	return err
+}
@@ -0,0 +1,137 @@
+package shell
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+// numberedLines returns the lines "line <from>" to "line <to>", numbered
+// with three digits so each is nine bytes with its newline.
+func numberedLines(from, to int) string {
+	var b strings.Builder
+	for i := from; i <= to; i++ {
+		fmt.Fprintf(&b, "line %03d\n", i)
+	}
+	return b.String()
+}
+
+func newTestExecutor(t *testing.T) *Executor {
+	e := New(t.TempDir(), false)
+	t.Cleanup(func() { e.Close() })
+	return e
+}
+
+func TestOutputBuffer(t *testing.T) {
+	tests := []struct {
+		name   string
+		limit  int
+		output string
+		chunk  int
+		want   string
+	}{
+		{name: "unlimited", limit: 0, output: numberedLines(1, 20), want: numberedLines(1, 20)},
+		{name: "under the limit", limit: 100, output: numberedLines(1, 11), want: numberedLines(1, 11)},
+		{name: "at the limit", limit: 99, output: numberedLines(1, 11), want: numberedLines(1, 11)},
+		{
+			name:   "one byte over",
+			limit:  98,
+			output: numberedLines(1, 11),
+			want:   numberedLines(1, 5) + "\n[... 9 bytes omitted ...]\n\n" + numberedLines(7, 11),
+		},
+		{
+			name:   "well over",
+			limit:  100,
+			output: numberedLines(1, 20),
+			want:   numberedLines(1, 5) + "\n[... 90 bytes omitted ...]\n\n" + numberedLines(16, 20),
+		},
+		{
+			name:   "written a byte at a time",
+			limit:  100,
+			output: numberedLines(1, 20),
+			chunk:  1,
+			want:   numberedLines(1, 5) + "\n[... 90 bytes omitted ...]\n\n" + numberedLines(16, 20),
+		},
+		{
+			name:   "written a line at a time",
+			limit:  100,
+			output: numberedLines(1, 20),
+			chunk:  9,
+			want:   numberedLines(1, 5) + "\n[... 90 bytes omitted ...]\n\n" + numberedLines(16, 20),
+		},
+	}
+	for _, tt := range tests {
+		e := newTestExecutor(t)
+		b := &outputBuffer{limit: tt.limit, open: e.openSpill}
+		chunk := tt.chunk
+		if chunk == 0 {
+			chunk = len(tt.output)
+		}
+		for rest := tt.output; rest != ""; {
+			n := min(chunk, len(rest))
+			b.Write([]byte(rest[:n]))
+			rest = rest[n:]
+		}
+		b.close()
+
+		if got := b.String(); got != tt.want {
+			t.Errorf("%s: String() = %q, want %q", tt.name, got, tt.want)
+		}
+		if b.total != int64(len(tt.output)) {
+			t.Errorf("%s: total = %d, want %d", tt.name, b.total, len(tt.output))
+		}
+
+		truncated := tt.want != tt.output
+		if b.truncated() != truncated || (b.spillID != 0) != truncated {
+			t.Errorf("%s: truncated %v with spill %d, want truncated %v", tt.name, b.truncated(), b.spillID, truncated)
+			continue
+		}
+		if truncated {
+			saved, err := os.ReadFile(e.spillPath(b.spillID))
+			if err != nil || string(saved) != tt.output {
+				t.Errorf("%s: spill file = %q, %v; want the whole output", tt.name, saved, err)
+			}
+		}
+	}
+}
+
+func TestReadOutput(t *testing.T) {
+	e := newTestExecutor(t)
+	long := strings.Repeat("x", maxSpillLine+100)
+	b := &outputBuffer{limit: 100, open: e.openSpill}
+	b.Write([]byte(numberedLines(1, 20) + long + "\nlast line without newline"))
+	b.close()
+	if b.spillID != 1 {
+		t.Fatalf("spill ID = %d, want 1", b.spillID)
+	}
+
+	tests := []struct {
+		offset, limit int
+		want          string
+	}{
+		{1, 3, "[saved output 1: lines 1-3 of 22]\n" + numberedLines(1, 3)},
+		{0, 2, "[saved output 1: lines 1-2 of 22]\n" + numberedLines(1, 2)},
+		{19, 2, "[saved output 1: lines 19-20 of 22]\n" + numberedLines(19, 20)},
+		{21, 1, "[saved output 1: lines 21-21 of 22]\n" + long[:maxSpillLine] + "\n"},
+		{22, 10, "[saved output 1: lines 22-22 of 22]\nlast line without newline\n"},
+		{23, 10, "[saved output 1 has 22 lines; nothing at line 23]"},
+		{1, 0, "[saved output 1 has 22 lines; nothing at line 1]"},
+	}
+	for _, tt := range tests {
+		got, err := e.ReadOutput(1, tt.offset, tt.limit)
+		if err != nil || got != tt.want {
+			t.Errorf("ReadOutput(1, %d, %d) = %q, %v; want %q", tt.offset, tt.limit, got, err, tt.want)
+		}
+	}
+
+	for _, id := range []int{0, 2} {
+		if _, err := e.ReadOutput(id, 1, 10); err == nil {
+			t.Errorf("ReadOutput(%d) found output that was never saved", id)
+		}
+	}
+	e.Close()
+	if _, err := e.ReadOutput(1, 1, 10); err == nil {
+		t.Error("ReadOutput found output after Close")
+	}
+}
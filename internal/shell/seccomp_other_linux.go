@@ -0,0 +1,14 @@
+//go:build linux && !amd64 && !arm64
+
+package shell
+
+// The seccomp filter's system call numbers are only listed for amd64 and
+// arm64; elsewhere isolation relies on namespaces and landlock alone.
+
+func seccompSupported() bool {
+	return false
+}
+
+func restrictSyscalls() error {
+	return nil
+}
@@ -0,0 +1,174 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// ToolCall records one completed execution of a tool.
+type ToolCall struct {
+	Name     string
+	Input    any
+	Output   string
+	Error    string
+	Duration time.Duration
+	// Redactions counts the secrets masked in Output.
+	Redactions int
+	// ExitCode is the exit code of the command execute_command ran, if it
+	// ran one.
+	ExitCode *int
+	// Files are the files the call modified.
+	Files []string
+}
+
+// CallRecorder receives every tool call made under a context. Tools may run
+// concurrently, so implementations must be safe for concurrent use.
+type CallRecorder func(ToolCall)
+
+// Summary describes the call on one line: the tool with its arguments, or
+// the command for execute_command, followed by its duration.
+func (c ToolCall) Summary() string {
+	var b strings.Builder
+	if input, ok := c.Input.(ExecuteCommandInput); ok {
+		b.WriteString("$ " + firstLine(input.Command, 100))
+	} else {
+		b.WriteString(c.Name)
+		if args, err := json.Marshal(c.Input); err == nil && string(args) != "{}" {
+			b.WriteString(" " + firstLine(string(args), 100))
+		}
+	}
+
+	fmt.Fprintf(&b, " · %s", c.Duration.Round(time.Millisecond))
+	if c.Error != "" {
+		b.WriteString(" · failed")
+	}
+	if c.Redactions > 0 {
+		fmt.Fprintf(&b, " · 🔒 %d secret(s) redacted", c.Redactions)
+	}
+	return b.String()
+}
+
+// Detail returns the call's arguments and result, each cut to maxLines.
+func (c ToolCall) Detail(maxLines int) string {
+	var b strings.Builder
+
+	if args, err := json.MarshalIndent(c.Input, "", "  "); err == nil && string(args) != "{}" {
+		b.WriteString("Arguments:\n")
+		b.WriteString(truncateLines(string(args), maxLines))
+		b.WriteString("\n\n")
+	}
+
+	if c.Error != "" {
+		b.WriteString("Error:\n")
+		b.WriteString(c.Error)
+		return b.String()
+	}
+
+	b.WriteString("Result:\n")
+	if strings.TrimSpace(c.Output) == "" {
+		b.WriteString("(no output)")
+	} else {
+		b.WriteString(truncateLines(strings.TrimRight(c.Output, "\n"), maxLines))
+	}
+	return b.String()
+}
+
+func firstLine(s string, maxLen int) string {
+	line, _, multiline := strings.Cut(s, "\n")
+	if runes := []rune(line); len(runes) > maxLen {
+		return string(runes[:maxLen-1]) + "…"
+	}
+	if multiline {
+		return line + " …"
+	}
+	return line
+}
+
+func truncateLines(s string, maxLines int) string {
+	lines := strings.Split(s, "\n")
+	if len(lines) <= maxLines {
+		return s
+	}
+	return strings.Join(lines[:maxLines], "\n") + fmt.Sprintf("\n… (%d more lines)", len(lines)-maxLines)
+}
+
+// callInfo collects what a tool reports about its call beyond its output.
+type callInfo struct {
+	exitCode *int
+	files    []string
+}
+
+type callInfoKey struct{}
+
+// reportExitCode records the exit code of the command a call ran.
+func reportExitCode(ctx context.Context, code int) {
+	if info, ok := ctx.Value(callInfoKey{}).(*callInfo); ok {
+		info.exitCode = &code
+	}
+}
+
+// reportFileChange records a file a call modified.
+func reportFileChange(ctx context.Context, path string) {
+	if info, ok := ctx.Value(callInfoKey{}).(*callInfo); ok {
+		info.files = append(info.files, path)
+	}
+}
+
+type callRecorderKey struct{}
+
+// WithCallRecorder returns a context whose tool calls are reported to record.
+func WithCallRecorder(ctx context.Context, record CallRecorder) context.Context {
+	return context.WithValue(ctx, callRecorderKey{}, record)
+}
+
+// defineTool defines a genkit tool that reports each call to the context's
+// CallRecorder. Calls that pause for approval are not reported; their
+// resumed execution is. Output is masked by the context's Redactor, if
+// any, before the model or the recorder sees it.
+func defineTool[In any](g *genkit.Genkit, name, description string, fn ai.ToolFunc[In, string]) ai.Tool {
+	return genkit.DefineTool(g, name, description,
+		func(ctx *ai.ToolContext, input In) (string, error) {
+			interrupted := false
+			info := &callInfo{}
+			wrapped := *ctx
+			wrapped.Context = context.WithValue(ctx.Context, callInfoKey{}, info)
+			wrapped.Interrupt = func(opts *ai.InterruptOptions) error {
+				interrupted = true
+				return ctx.Interrupt(opts)
+			}
+
+			start := time.Now()
+			output, err := fn(&wrapped, input)
+
+			redactions := 0
+			if redactor := redactorFrom(ctx); redactor != nil {
+				output, redactions = redactor.Redact(output)
+			}
+
+			record, ok := ctx.Value(callRecorderKey{}).(CallRecorder)
+			if ok && !interrupted {
+				call := ToolCall{
+					Name:       name,
+					Input:      input,
+					Output:     output,
+					Duration:   time.Since(start),
+					Redactions: redactions,
+					ExitCode:   info.exitCode,
+					Files:      info.files,
+				}
+				if err != nil {
+					call.Error = err.Error()
+				}
+				record(call)
+			}
+
+			return output, err
+		},
+	)
+}
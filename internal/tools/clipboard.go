@@ -13,7 +13,7 @@ type WriteClipboardInput struct {
 }
 
 func DefineClipboardTools(g *genkit.Genkit) []ai.Tool {
-	readClipboardTool := genkit.DefineTool(g, "read_clipboard",
+	readClipboardTool := defineTool(g, "read_clipboard",
 		"Reads the current text content from the system clipboard",
 		func(ctx *ai.ToolContext, input struct{}) (string, error) {
 			content, err := clipboard.ReadAll()
@@ -29,7 +29,7 @@ func DefineClipboardTools(g *genkit.Genkit) []ai.Tool {
 		},
 	)
 
-	writeClipboardTool := genkit.DefineTool(g, "write_clipboard",
+	writeClipboardTool := defineTool(g, "write_clipboard",
 		"Writes text content to the system clipboard",
 		func(ctx *ai.ToolContext, input WriteClipboardInput) (string, error) {
 			err := clipboard.WriteAll(input.Content)
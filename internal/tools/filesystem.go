@@ -31,13 +31,42 @@ type ListDirectoryInput struct {
 	Recursive bool   `json:"recursive,omitempty" jsonschema:"description=List recursively (default: false)"`
 }
 
-func DefineFilesystemTools(g *genkit.Genkit, workdir string) []ai.Tool {
-	readFileTool := genkit.DefineTool(g, "read_file",
+// FileStore reads and writes the files behind the filesystem tools. Sandbox
+// mode swaps in a store that records writes instead of performing them.
+type FileStore interface {
+	ReadFile(path string) ([]byte, error)
+	WriteFile(path string, data []byte) error
+}
+
+// DiskStore is the FileStore that reads and writes files directly.
+type DiskStore struct{}
+
+func (DiskStore) ReadFile(path string) ([]byte, error) {
+	return os.ReadFile(path)
+}
+
+func (DiskStore) WriteFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// PathResolver maps a path supplied to tool to the real path it refers to,
+// returning an error when the path is outside the accessible folders or
+// the tool may not use it.
+type PathResolver func(tool, path string) (string, error)
+
+func DefineFilesystemTools(g *genkit.Genkit, resolve PathResolver, store FileStore) []ai.Tool {
+	readFileTool := defineTool(g, "read_file",
 		"Reads the complete contents of a file from the filesystem",
 		func(ctx *ai.ToolContext, input ReadFileInput) (string, error) {
-			fullPath := filepath.Join(workdir, input.Path)
+			fullPath, err := resolve("read_file", input.Path)
+			if err != nil {
+				return accessDenied(err), nil
+			}
 
-			content, err := os.ReadFile(fullPath)
+			content, err := store.ReadFile(fullPath)
 			if err != nil {
 				return "", fmt.Errorf("failed to read file %s: %w", input.Path, err)
 			}
@@ -46,35 +75,46 @@ func DefineFilesystemTools(g *genkit.Genkit, workdir string) []ai.Tool {
 		},
 	)
 
-	writeFileTool := genkit.DefineTool(g, "write_file",
+	writeFileTool := defineTool(g, "write_file",
 		"Writes content to a file, creating or overwriting it",
 		func(ctx *ai.ToolContext, input WriteFileInput) (string, error) {
-			fullPath := filepath.Join(workdir, input.Path)
+			fullPath, err := resolve("write_file", input.Path)
+			if err != nil {
+				return accessDenied(err), nil
+			}
 
-			dir := filepath.Dir(fullPath)
-			if err := os.MkdirAll(dir, 0755); err != nil {
-				return "", fmt.Errorf("failed to create directory: %w", err)
+			existing, _ := store.ReadFile(fullPath)
+			if writesRedacted(ctx, string(existing), input.Content) {
+				return redactedWrite(input.Path), nil
 			}
 
-			if err := os.WriteFile(fullPath, []byte(input.Content), 0644); err != nil {
+			if err := store.WriteFile(fullPath, []byte(input.Content)); err != nil {
 				return "", fmt.Errorf("failed to write file %s: %w", input.Path, err)
 			}
+			reportFileChange(ctx, fullPath)
 
 			return fmt.Sprintf("Successfully wrote %d bytes to %s", len(input.Content), input.Path), nil
 		},
 	)
 
-	searchReplaceTool := genkit.DefineTool(g, "search_replace",
+	searchReplaceTool := defineTool(g, "search_replace",
 		"Performs exact string search and replace in a file",
 		func(ctx *ai.ToolContext, input SearchReplaceInput) (string, error) {
-			fullPath := filepath.Join(workdir, input.Path)
+			fullPath, err := resolve("search_replace", input.Path)
+			if err != nil {
+				return accessDenied(err), nil
+			}
 
-			content, err := os.ReadFile(fullPath)
+			content, err := store.ReadFile(fullPath)
 			if err != nil {
 				return "", fmt.Errorf("failed to read file %s: %w", input.Path, err)
 			}
 
 			originalContent := string(content)
+			if writesRedacted(ctx, input.OldText, input.NewText) {
+				return redactedWrite(input.Path), nil
+			}
+
 			var newContent string
 			var count int
 
@@ -89,18 +129,22 @@ func DefineFilesystemTools(g *genkit.Genkit, workdir string) []ai.Tool {
 				count = 1
 			}
 
-			if err := os.WriteFile(fullPath, []byte(newContent), 0644); err != nil {
+			if err := store.WriteFile(fullPath, []byte(newContent)); err != nil {
 				return "", fmt.Errorf("failed to write file %s: %w", input.Path, err)
 			}
+			reportFileChange(ctx, fullPath)
 
 			return fmt.Sprintf("Replaced %d occurrence(s) in %s", count, input.Path), nil
 		},
 	)
 
-	listDirectoryTool := genkit.DefineTool(g, "list_directory",
+	listDirectoryTool := defineTool(g, "list_directory",
 		"Lists files and directories in a path",
 		func(ctx *ai.ToolContext, input ListDirectoryInput) (string, error) {
-			fullPath := filepath.Join(workdir, input.Path)
+			fullPath, err := resolve("list_directory", input.Path)
+			if err != nil {
+				return accessDenied(err), nil
+			}
 
 			if input.Recursive {
 				var files []string
@@ -108,7 +152,8 @@ func DefineFilesystemTools(g *genkit.Genkit, workdir string) []ai.Tool {
 					if err != nil {
 						return err
 					}
-					relPath, _ := filepath.Rel(workdir, path)
+					relPath, _ := filepath.Rel(fullPath, path)
+					relPath = filepath.Join(input.Path, relPath)
 					if info.IsDir() {
 						files = append(files, relPath+"/")
 					} else {
@@ -142,3 +187,9 @@ func DefineFilesystemTools(g *genkit.Genkit, workdir string) []ai.Tool {
 
 	return []ai.Tool{readFileTool, writeFileTool, searchReplaceTool, listDirectoryTool}
 }
+
+// accessDenied reports a rejected path as tool output rather than an error,
+// so the model sees why the call failed and can choose another path.
+func accessDenied(err error) string {
+	return fmt.Sprintf("Access denied: %v.", err)
+}
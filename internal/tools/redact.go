@@ -0,0 +1,153 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Redacted replaces a secret in tool output.
+const Redacted = "[REDACTED]"
+
+// secretPattern finds one kind of secret. Matches are replaced with
+// replace, a regexp template, or Redacted when it is empty.
+type secretPattern struct {
+	re      *regexp.Regexp
+	replace string
+}
+
+var secretPatterns = []secretPattern{
+	// Private keys, including one cut off by output truncation.
+	{re: regexp.MustCompile(`-----BEGIN [A-Z0-9 ]*PRIVATE KEY( BLOCK)?-----[\s\S]*?(-----END [A-Z0-9 ]*PRIVATE KEY( BLOCK)?-----|\z)`)},
+	// Provider tokens with recognisable prefixes.
+	{re: regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{re: regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36,}\b`)},
+	{re: regexp.MustCompile(`\bgithub_pat_[A-Za-z0-9_]{22,}\b`)},
+	{re: regexp.MustCompile(`\bglpat-[A-Za-z0-9_-]{20,}`)},
+	{re: regexp.MustCompile(`\bsk-[A-Za-z0-9_-]{20,}`)},
+	{re: regexp.MustCompile(`\b[rs]k_(live|test)_[A-Za-z0-9]{16,}\b`)},
+	{re: regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}`)},
+	{re: regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}`)},
+	{re: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{10,}\.eyJ[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}`)},
+	// Credentials in headers and URLs.
+	{re: regexp.MustCompile(`(?i)(\b(?:bearer|basic|token)\s+)[A-Za-z0-9._~+/-]{16,}=*`), replace: "${1}" + Redacted},
+	{re: regexp.MustCompile(`(\b[a-zA-Z][a-zA-Z0-9+.-]*://[^:/\s@]+:)[^@\s/]+@`), replace: "${1}" + Redacted + "@"},
+}
+
+// secretAssignment finds values assigned to names that suggest a secret,
+// as in .env files, env output and JSON or YAML config.
+var secretAssignment = regexp.MustCompile(`(?i)([A-Z0-9_.-]*(?:api[_-]?key|secret|token|passw(?:or)?d|credential|private[_-]?key|access[_-]?key)[A-Z0-9_.-]*["']?\s*[=:]\s*["']?)([^\s"'` + "`" + `,;#]+)`)
+
+// Redactor masks secrets in tool output before it reaches the model.
+type Redactor struct {
+	patterns []secretPattern
+	literals []string
+}
+
+// NewRedactor returns a redactor for the built-in secret patterns, the
+// extra regular expressions and the literal secret values, such as the
+// values of environment variables withheld from commands.
+func NewRedactor(extra []string, literals []string) (*Redactor, error) {
+	r := &Redactor{patterns: secretPatterns}
+	for _, pattern := range extra {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redact pattern %q: %w", pattern, err)
+		}
+		r.patterns = append(r.patterns, secretPattern{re: re})
+	}
+
+	for _, literal := range literals {
+		if literal != "" {
+			r.literals = append(r.literals, literal)
+		}
+	}
+	// Longer values first, so one containing another is masked whole.
+	sort.Slice(r.literals, func(i, j int) bool { return len(r.literals[i]) > len(r.literals[j]) })
+	return r, nil
+}
+
+// Redact masks the secrets in s and returns how many it masked.
+func (r *Redactor) Redact(s string) (string, int) {
+	count := 0
+	for _, literal := range r.literals {
+		if n := strings.Count(s, literal); n > 0 {
+			s = strings.ReplaceAll(s, literal, Redacted)
+			count += n
+		}
+	}
+
+	for _, pattern := range r.patterns {
+		replace := pattern.replace
+		if replace == "" {
+			replace = Redacted
+		}
+		// A match already masked, such as a URL password, is not counted
+		// again.
+		s = pattern.re.ReplaceAllStringFunc(s, func(match string) string {
+			masked := pattern.re.ReplaceAllString(match, replace)
+			if masked != match {
+				count++
+			}
+			return masked
+		})
+	}
+
+	s = secretAssignment.ReplaceAllStringFunc(s, func(match string) string {
+		groups := secretAssignment.FindStringSubmatch(match)
+		if !looksSecret(groups[2]) {
+			return match
+		}
+		count++
+		return groups[1] + Redacted
+	})
+	return s, count
+}
+
+// looksSecret tells a secret value from code and placeholders on the
+// right of an assignment: secrets are long runs of token characters
+// mixing letters and digits.
+func looksSecret(value string) bool {
+	if len(value) < 8 || value == Redacted {
+		return false
+	}
+	letters, digits := false, false
+	for _, c := range value {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+			letters = true
+		case c >= '0' && c <= '9':
+			digits = true
+		case strings.ContainsRune("_-+/=.~", c):
+		default:
+			return false
+		}
+	}
+	return letters && digits
+}
+
+type redactorKey struct{}
+
+// WithRedactor returns a context whose tool output is masked by redactor
+// before it is returned to the model.
+func WithRedactor(ctx context.Context, redactor *Redactor) context.Context {
+	return context.WithValue(ctx, redactorKey{}, redactor)
+}
+
+func redactorFrom(ctx context.Context) *Redactor {
+	redactor, _ := ctx.Value(redactorKey{}).(*Redactor)
+	return redactor
+}
+
+// writesRedacted reports whether replacing before with after would put a
+// redaction placeholder into a file, overwriting the secret the model
+// never saw.
+func writesRedacted(ctx context.Context, before, after string) bool {
+	return redactorFrom(ctx) != nil && strings.Count(after, Redacted) > strings.Count(before, Redacted)
+}
+
+func redactedWrite(path string) string {
+	return fmt.Sprintf("Refused to write %s: the content contains %s, which stands for a secret masked in tool output, and writing it would replace the real secret. Edit only the text around it with search_replace.", path, Redacted)
+}
@@ -3,19 +3,54 @@ package tools
 import (
 	"context"
 	"fmt"
-	"os/exec"
-	"runtime"
+	"time"
 
 	"github.com/firebase/genkit/go/ai"
 	"github.com/firebase/genkit/go/genkit"
+	"github.com/niradler/termu/internal/shell"
 )
 
 type ExecuteCommandInput struct {
 	Command string `json:"command" jsonschema:"description=Shell command to execute in the working directory"`
 }
 
-func DefineShellTool(g *genkit.Genkit, workdir string) ai.Tool {
-	return genkit.DefineTool(g, "execute_command",
+type ReadCommandOutputInput struct {
+	ID     int `json:"id" jsonschema:"description=ID of the saved output, as given when a command's output was truncated"`
+	Offset int `json:"offset,omitempty" jsonschema:"description=First line to return, counting from 1 (default: 1)"`
+	Limit  int `json:"limit,omitempty" jsonschema:"description=Number of lines to return (default: 200)"`
+}
+
+// defaultOutputLines is how many lines read_command_output returns when no
+// limit is given.
+const defaultOutputLines = 200
+
+// CommandCheck is the outcome of validating a command before it is executed.
+type CommandCheck struct {
+	Allowed       bool
+	NeedsApproval bool
+	Reason        string
+	Risk          string
+	// Timeout bounds how long the command may run; 0 means no limit.
+	Timeout time.Duration
+}
+
+// CommandChecker validates a command before execute_command runs it.
+type CommandChecker func(command string) CommandCheck
+
+// Interrupt metadata keys used when execute_command pauses for user approval.
+const (
+	InterruptCommand = "command"
+	InterruptRisk    = "risk"
+	InterruptReason  = "reason"
+	ResumeApproved   = "approved"
+	// ResumeCommand holds the command as edited by the user, when it was.
+	ResumeCommand = "command"
+	// ResumeReason holds the user's explanation for a rejection.
+	ResumeReason = "reason"
+)
+
+func DefineShellTool(g *genkit.Genkit, executor *shell.Executor, check CommandChecker) ai.Tool {
+	return defineTool(g, "execute_command",
 		`Executes shell commands for exploration, searching, and information gathering.
 
 Use this tool for:
@@ -27,28 +62,121 @@ Use this tool for:
 - Disk usage: dua, dua aggregate
 - JSON/YAML: jq '.' data.json, yq '.key' config.yaml
 
-The command runs in the current working directory context. Output includes both stdout and stderr.`,
+The command runs in the current working directory context. Output includes both stdout and stderr.
+Commands that run too long are killed, so avoid commands that never exit (tail -f, watch, servers).
+Long output is cut to its start and end; the full output can then be read with read_command_output.
+Commands are validated against the security policy; risky commands require user approval.`,
 		func(ctx *ai.ToolContext, input ExecuteCommandInput) (string, error) {
-			shell, shellArg := getShell()
-			cmd := exec.CommandContext(context.Background(), shell, shellArg, input.Command)
-			cmd.Dir = workdir
+			if ctx.Resumed != nil {
+				return resumeCommand(ctx, executor, check, input.Command)
+			}
 
-			output, err := cmd.CombinedOutput()
-			if err != nil {
-				if exitErr, ok := err.(*exec.ExitError); ok {
-					return fmt.Sprintf("Command failed with exit code %d:\n%s", exitErr.ExitCode(), string(output)), nil
-				}
-				return "", fmt.Errorf("failed to execute command: %w", err)
+			result := check(input.Command)
+			if !result.Allowed {
+				return fmt.Sprintf("Command blocked by security policy: %s\nChoose a different command.", result.Reason), nil
+			}
+
+			if result.NeedsApproval && !executor.Sandboxed() {
+				return "", ctx.Interrupt(&ai.InterruptOptions{
+					Metadata: map[string]any{
+						InterruptCommand: input.Command,
+						InterruptRisk:    result.Risk,
+						InterruptReason:  result.Reason,
+					},
+				})
 			}
 
-			return string(output), nil
+			return runCommand(ctx, executor, input.Command, result.Timeout)
+		},
+	)
+}
+
+// DefineCommandOutputTool lets the model page through the full output of
+// commands whose output execute_command truncated.
+func DefineCommandOutputTool(g *genkit.Genkit, executor *shell.Executor) ai.Tool {
+	return defineTool(g, "read_command_output",
+		`Reads lines from the full output of a command whose output was truncated by execute_command.
+Use the id given in the truncation notice, and page through with offset and limit.`,
+		func(ctx *ai.ToolContext, input ReadCommandOutputInput) (string, error) {
+			limit := input.Limit
+			if limit <= 0 {
+				limit = defaultOutputLines
+			}
+			output, err := executor.ReadOutput(input.ID, input.Offset, limit)
+			if err != nil {
+				return fmt.Sprintf("Error: %v", err), nil
+			}
+			return output, nil
 		},
 	)
 }
 
-func getShell() (string, string) {
-	if runtime.GOOS == "windows" {
-		return "powershell.exe", "-Command"
+// resumeCommand carries out the user's decision on a command that was
+// waiting for approval. An edited command is validated again, since only
+// the original was checked, and the model is told what actually ran.
+func resumeCommand(ctx *ai.ToolContext, executor *shell.Executor, check CommandChecker, command string) (string, error) {
+	if approved, _ := ctx.Resumed[ResumeApproved].(bool); !approved {
+		msg := fmt.Sprintf("Command rejected by user: %s\n", command)
+		if reason, _ := ctx.Resumed[ResumeReason].(string); reason != "" {
+			msg += fmt.Sprintf("The user's reason: %s\nTake this into account before continuing.", reason)
+		} else {
+			msg += "Do not retry it; ask the user how to proceed or try a different approach."
+		}
+		return msg, nil
+	}
+
+	edited, _ := ctx.Resumed[ResumeCommand].(string)
+	if edited == "" || edited == command {
+		return runCommand(ctx, executor, command, check(command).Timeout)
+	}
+
+	result := check(edited)
+	if !result.Allowed {
+		return fmt.Sprintf("The user edited the command to: %s\nThe edited command was blocked by security policy: %s\nAsk the user how to proceed.", edited, result.Reason), nil
+	}
+
+	output, err := runCommand(ctx, executor, edited, result.Timeout)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("The user edited the command before running it.\nProposed: %s\nRan: %s\n\n%s", command, edited, output), nil
+}
+
+// runCommand executes command, killing it after timeout when that is set,
+// and describes the outcome for the model.
+func runCommand(ctx *ai.ToolContext, executor *shell.Executor, command string, timeout time.Duration) (string, error) {
+	var runCtx context.Context = ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	result, err := executor.Execute(runCtx, command)
+	if err != nil {
+		return "", err
 	}
-	return "/bin/sh", "-c"
+	if result.Executed {
+		reportExitCode(ctx, result.ExitCode)
+	}
+
+	output := result.Output
+	if result.Truncated {
+		output += fmt.Sprintf("\n[Output truncated: %d bytes in total, only the start and end are shown.", result.TotalBytes)
+		if result.OutputID != 0 {
+			output += fmt.Sprintf(" Read the rest with read_command_output using id %d.", result.OutputID)
+		}
+		output += "]"
+	}
+
+	switch {
+	case result.TimedOut:
+		return fmt.Sprintf("Command timed out after %s and was killed, along with any processes it started. Output so far:\n%s", timeout, output), nil
+	case result.Cancelled:
+		return fmt.Sprintf("Command cancelled by the user and killed. Output so far:\n%s", output), nil
+	case result.ExitCode != 0:
+		return fmt.Sprintf("Command failed with exit code %d:\n%s", result.ExitCode, output), nil
+	}
+
+	return output, nil
 }
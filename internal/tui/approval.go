@@ -0,0 +1,257 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/textarea"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/niradler/termu/internal/agent"
+	"github.com/niradler/termu/internal/security"
+)
+
+// updateApproval handles keys while a command awaits approval. It reports
+// whether the key was handled; other keys fall through to the global ones.
+func (m Model) updateApproval(msg tea.KeyMsg) (tea.Model, tea.Cmd, bool) {
+	switch msg.String() {
+	case "enter", "y":
+		model, cmd := m.approve(security.ApproveOnce)
+		return model, cmd, true
+	case "s":
+		model, cmd := m.approve(security.ApproveSession)
+		return model, cmd, true
+	case "p":
+		model, cmd := m.approve(security.ApproveProject)
+		return model, cmd, true
+	case "e":
+		m.editor.SetValue(m.command())
+		m.openEditor(StateEditCommand, "")
+		return m, textarea.Blink, true
+	case "r":
+		m.editor.Reset()
+		m.openEditor(StateRejectReason, "Why should termu not run this?")
+		return m, textarea.Blink, true
+	case "esc", "n":
+		model, cmd := m.reject("")
+		return model, cmd, true
+	}
+	return m, nil, false
+}
+
+func (m *Model) openEditor(state SessionState, placeholder string) {
+	m.editor.Placeholder = placeholder
+	m.editor.Focus()
+	m.editError = ""
+	m.state = state
+}
+
+// updateApprovalInput handles keys while editing the command or typing a
+// rejection reason. Enter submits and Esc returns to the approval prompt;
+// Ctrl+J inserts a newline.
+func (m Model) updateApprovalInput(msg tea.KeyMsg) (tea.Model, tea.Cmd, bool) {
+	switch msg.Type {
+	case tea.KeyEsc:
+		m.editor.Blur()
+		m.state = StateApproval
+		return m, nil, true
+
+	case tea.KeyEnter:
+		value := strings.TrimSpace(m.editor.Value())
+		if m.state == StateRejectReason {
+			m.editor.Blur()
+			model, cmd := m.reject(value)
+			return model, cmd, true
+		}
+		if value == "" {
+			m.editError = "The command is empty; press Esc to go back"
+			return m, nil, true
+		}
+		m.setEditedCommand(value)
+		return m, nil, true
+
+	case tea.KeyCtrlC, tea.KeyCtrlD:
+		return m, nil, false
+	}
+
+	var cmd tea.Cmd
+	m.editor, cmd = m.editor.Update(msg)
+	return m, cmd, true
+}
+
+// setEditedCommand validates the edited command and, unless it is blocked,
+// returns to the approval prompt with its new risk assessment.
+func (m *Model) setEditedCommand(command string) {
+	result := m.validator.Validate(command, m.workdir)
+	if !result.Allowed {
+		m.editError = "Blocked: " + result.Reason
+		return
+	}
+
+	if command == m.currentCmd {
+		m.editedCmd = ""
+	} else {
+		m.editedCmd = command
+	}
+	m.currentRisk = result.RiskLevel.String()
+	m.currentReason = result.Reason
+	if !result.NeedsApproval {
+		m.currentReason = "no approval needed after the edit"
+	}
+	m.currentPattern = m.validator.SuggestPattern(command)
+	m.editor.Blur()
+	m.state = StateApproval
+}
+
+// command is the command that runs when approved: the edited one, if any.
+func (m Model) command() string {
+	if m.editedCmd != "" {
+		return m.editedCmd
+	}
+	return m.currentCmd
+}
+
+// approve runs the pending command, first remembering the suggested
+// pattern for the session or the project when asked to.
+func (m Model) approve(scope security.ApprovalScope) (tea.Model, tea.Cmd) {
+	if err := m.validator.Approve(m.command(), scope, m.currentPattern); err != nil {
+		m.messages = append(m.messages, Message{
+			Role:    "error",
+			Content: fmt.Sprintf("Failed to save approval: %v", err),
+		})
+	} else if scope != security.ApproveOnce {
+		m.messages = append(m.messages, Message{
+			Role:    "system",
+			Content: fmt.Sprintf("✅ Approved `%s` for the %s", m.currentPattern, scope),
+		})
+	}
+
+	if m.editedCmd != "" {
+		m.messages = append(m.messages, Message{
+			Role:    "system",
+			Content: fmt.Sprintf("✏️  Command edited to: %s", m.editedCmd),
+		})
+	}
+
+	decision := agent.Decision{Approved: true, Command: m.editedCmd}
+	m.editedCmd = ""
+	m.iterationCount++
+	m.state = StateExecuting
+	m.updateViewport()
+	return m, m.resumeAgent(decision)
+}
+
+// reject declines the pending command, passing reason on to the model.
+func (m Model) reject(reason string) (tea.Model, tea.Cmd) {
+	content := "❌ Command rejected by user"
+	if reason != "" {
+		content += ": " + reason
+	}
+	m.messages = append(m.messages, Message{
+		Role:    "system",
+		Content: content,
+	})
+	m.state = StateIterating
+	m.currentCmd = ""
+	m.editedCmd = ""
+	m.updateViewport()
+	return m, m.resumeAgent(agent.Decision{Reason: reason})
+}
+
+func (m Model) renderApproval() string {
+	var b strings.Builder
+
+	b.WriteString(ApprovalStyle.Render("⚠️  Command Approval Required"))
+	b.WriteString("\n\n")
+	b.WriteString(CommandStyle.Render(m.command()))
+	if m.editedCmd != "" {
+		b.WriteString("\n")
+		b.WriteString(HelpStyle.Render("edited; proposed: " + m.currentCmd))
+	}
+	if m.currentRisk != "" {
+		b.WriteString("\n")
+		risk := "Risk: " + m.currentRisk
+		if m.currentReason != "" {
+			risk += " — " + m.currentReason
+		}
+		b.WriteString(WarningStyle.Render(risk))
+	}
+	b.WriteString("\n\n")
+	b.WriteString(SuccessStyle.Render("Enter/y: run once"))
+	b.WriteString(" • ")
+	b.WriteString(SuccessStyle.Render(fmt.Sprintf("s: allow `%s` this session", m.currentPattern)))
+	b.WriteString(" • ")
+	b.WriteString(SuccessStyle.Render("p: always allow in this project"))
+	b.WriteString(" • ")
+	b.WriteString(InfoStyle.Render("e: edit"))
+	b.WriteString(" • ")
+	b.WriteString(ErrorStyle.Render("Esc/n: reject"))
+	b.WriteString(" • ")
+	b.WriteString(ErrorStyle.Render("r: reject with reason"))
+
+	return b.String()
+}
+
+func (m Model) renderApprovalInput() string {
+	var b strings.Builder
+
+	if m.state == StateEditCommand {
+		b.WriteString(ApprovalStyle.Render("✏️  Edit Command"))
+	} else {
+		b.WriteString(ApprovalStyle.Render("❌ Reject Command"))
+		b.WriteString("\n")
+		b.WriteString(CommandStyle.Render(m.command()))
+	}
+	b.WriteString("\n")
+	b.WriteString(m.editor.View())
+	if m.editError != "" {
+		b.WriteString("\n")
+		b.WriteString(ErrorStyle.Render(m.editError))
+	}
+	b.WriteString("\n")
+	if m.state == StateEditCommand {
+		b.WriteString(HelpStyle.Render("Enter: check the edited command • Ctrl+J: newline • Esc: back"))
+	} else {
+		b.WriteString(HelpStyle.Render("Enter: reject and tell termu why • Esc: back"))
+	}
+
+	return b.String()
+}
+
+// listApprovals shows the remembered approvals, numbered for /approvals
+// revoke.
+func (m *Model) listApprovals() {
+	approvals := m.validator.Approvals()
+	if len(approvals) == 0 {
+		m.messages = append(m.messages, Message{Role: "system", Content: "🔐 No remembered approvals"})
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString("🔐 Approvals (use /approvals revoke <n> to remove one):")
+	for i, approval := range approvals {
+		fmt.Fprintf(&b, "\n  %d. [%s] %s", i+1, approval.Scope, approval.Pattern)
+	}
+	m.messages = append(m.messages, Message{Role: "system", Content: b.String()})
+}
+
+// revokeApproval removes approval n as numbered by listApprovals.
+func (m *Model) revokeApproval(n int) {
+	approvals := m.validator.Approvals()
+	if n < 1 || n > len(approvals) {
+		m.messages = append(m.messages, Message{
+			Role:    "error",
+			Content: fmt.Sprintf("No approval %d; use /approvals to list them", n),
+		})
+		return
+	}
+
+	approval := approvals[n-1]
+	if _, err := m.validator.Revoke(approval.Pattern, approval.Scope); err != nil {
+		m.messages = append(m.messages, Message{Role: "error", Content: fmt.Sprintf("Failed to revoke approval: %v", err)})
+		return
+	}
+	m.messages = append(m.messages, Message{
+		Role:    "system",
+		Content: fmt.Sprintf("🔐 Revoked [%s] %s", approval.Scope, approval.Pattern),
+	})
+}
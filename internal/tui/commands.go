@@ -0,0 +1,243 @@
+package tui
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/niradler/termu/internal/agent"
+	"github.com/niradler/termu/internal/checkpoint"
+	"github.com/niradler/termu/internal/config"
+)
+
+// CompactDoneMsg reports the result of /compact.
+type CompactDoneMsg struct {
+	Compaction *agent.Compaction
+	Error      error
+}
+
+// isSlashCommand reports whether input is a chat command such as /compact
+// rather than a message for the model.
+func isSlashCommand(input string) bool {
+	return strings.HasPrefix(strings.TrimSpace(input), "/")
+}
+
+// runSlashCommand handles a chat command typed at the prompt.
+func (m Model) runSlashCommand(input string) (tea.Model, tea.Cmd) {
+	fields := strings.Fields(input)
+	m.textarea.Reset()
+
+	switch fields[0] {
+	case "/compact":
+		m.messages = append(m.messages, Message{
+			Role:    "system",
+			Content: "🗜️ Compacting conversation...",
+		})
+		m.state = StateThinking
+		m.streamStatus = "🗜️ Summarizing earlier turns..."
+		m.updateViewport()
+		return m, m.compactAgent()
+
+	case "/undo":
+		m.undo()
+
+	case "/rewind":
+		if len(fields) < 2 {
+			m.listCheckpoints()
+			break
+		}
+		id, err := strconv.Atoi(fields[1])
+		if err != nil {
+			m.messages = append(m.messages, Message{
+				Role:    "error",
+				Content: fmt.Sprintf("Invalid checkpoint %q: usage is /rewind <n>", fields[1]),
+			})
+			break
+		}
+		m.rewind(id)
+
+	case "/approvals":
+		if len(fields) < 3 || fields[1] != "revoke" {
+			m.listApprovals()
+			break
+		}
+		n, err := strconv.Atoi(fields[2])
+		if err != nil {
+			m.messages = append(m.messages, Message{
+				Role:    "error",
+				Content: fmt.Sprintf("Invalid approval %q: usage is /approvals revoke <n>", fields[2]),
+			})
+			break
+		}
+		m.revokeApproval(n)
+
+	case "/model":
+		if len(fields) < 2 {
+			m.listModels()
+			break
+		}
+		m.switchModel(fields[1])
+
+	default:
+		m.messages = append(m.messages, Message{
+			Role:    "error",
+			Content: fmt.Sprintf("Unknown command: %s (available: /compact, /undo, /rewind, /approvals, /model)", fields[0]),
+		})
+	}
+
+	m.updateViewport()
+	return m, nil
+}
+
+// listModels shows the model profiles, marking the one in use.
+func (m *Model) listModels() {
+	current := m.config.Profile
+	if current == "" {
+		current = config.DefaultProfile
+	}
+
+	var b strings.Builder
+	b.WriteString("Model profiles:\n")
+	for _, name := range m.config.ModelProfiles() {
+		profile, err := m.config.ModelProfile(name)
+		if err != nil {
+			fmt.Fprintf(&b, "\n  %s: %v", name, err)
+			continue
+		}
+		marker := " "
+		if name == current {
+			marker = "▶"
+		}
+		fmt.Fprintf(&b, "\n%s %s: %s/%s", marker, name, profile.Provider, profile.Name)
+	}
+	b.WriteString("\n\nSwitch with /model <name>; the conversation carries over.")
+	m.messages = append(m.messages, Message{Role: "system", Content: b.String()})
+}
+
+// switchModel moves the conversation to the named model profile.
+func (m *Model) switchModel(name string) {
+	profile, err := m.config.ModelProfile(name)
+	if err == nil {
+		err = m.agent.SetModel(m.ctx, profile)
+	}
+	if err != nil {
+		m.messages = append(m.messages, Message{
+			Role:    "error",
+			Content: fmt.Sprintf("Failed to switch model: %v", err),
+		})
+		return
+	}
+
+	m.config.UseModel(name)
+	m.session.Model = m.agent.ModelName()
+	m.messages = append(m.messages, Message{
+		Role:    "system",
+		Content: fmt.Sprintf("🔀 Switched to %s (%s); the conversation continues with it", name, m.agent.ModelName()),
+	})
+}
+
+// undo restores the files changed during the latest turn that changed any.
+func (m *Model) undo() {
+	if m.checkpoints == nil {
+		m.messages = append(m.messages, m.checkpointsUnavailable())
+		return
+	}
+
+	cp, restored, err := m.checkpoints.Undo()
+	if errors.Is(err, checkpoint.ErrNoCheckpoints) {
+		m.messages = append(m.messages, Message{Role: "system", Content: "↩️ No file changes to undo"})
+		return
+	}
+	if err != nil {
+		m.messages = append(m.messages, Message{Role: "error", Content: fmt.Sprintf("Undo failed: %v", err)})
+		return
+	}
+	m.messages = append(m.messages, Message{
+		Role:    "system",
+		Content: fmt.Sprintf("↩️ Undid checkpoint %d (%s)\n%s", cp.ID, agent.Clip(cp.Label, 60), m.restoredList(restored)),
+	})
+}
+
+// rewind restores the files to their state before checkpoint id.
+func (m *Model) rewind(id int) {
+	if m.checkpoints == nil {
+		m.messages = append(m.messages, m.checkpointsUnavailable())
+		return
+	}
+
+	restored, err := m.checkpoints.Restore(id)
+	if err != nil {
+		m.messages = append(m.messages, Message{Role: "error", Content: fmt.Sprintf("Rewind failed: %v", err)})
+		return
+	}
+	m.messages = append(m.messages, Message{
+		Role:    "system",
+		Content: fmt.Sprintf("⏪ Rewound to before checkpoint %d\n%s", id, m.restoredList(restored)),
+	})
+}
+
+func (m *Model) listCheckpoints() {
+	if m.checkpoints == nil {
+		m.messages = append(m.messages, m.checkpointsUnavailable())
+		return
+	}
+
+	checkpoints := m.checkpoints.List()
+	if len(checkpoints) == 0 {
+		m.messages = append(m.messages, Message{Role: "system", Content: "⏪ No checkpoints yet"})
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString("⏪ Checkpoints (use /rewind <n> to restore files to before checkpoint n):")
+	for _, cp := range checkpoints {
+		fmt.Fprintf(&b, "\n  %d. %s — %s (%d files)", cp.ID, cp.CreatedAt.Format("15:04:05"), agent.Clip(cp.Label, 50), len(cp.Files))
+	}
+	m.messages = append(m.messages, Message{Role: "system", Content: b.String()})
+}
+
+func (m *Model) checkpointsUnavailable() Message {
+	reason := "session saving is disabled"
+	if m.sandboxMode {
+		reason = "sandbox mode does not change files"
+	}
+	return Message{Role: "error", Content: "Checkpoints are not available: " + reason}
+}
+
+// restoredList lists restored files relative to the working directory.
+func (m *Model) restoredList(paths []string) string {
+	lines := make([]string, len(paths))
+	for i, path := range paths {
+		if rel, err := filepath.Rel(m.workdir, path); err == nil && !strings.HasPrefix(rel, "..") {
+			path = rel
+		}
+		lines[i] = "  " + path
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (m Model) compactAgent() tea.Cmd {
+	return func() tea.Msg {
+		compaction, err := m.agent.Compact(m.ctx)
+		return CompactDoneMsg{Compaction: compaction, Error: err}
+	}
+}
+
+// compactionNote describes a compaction for the transcript.
+func compactionNote(c *agent.Compaction) string {
+	if !c.Changed() {
+		return "🗜️ Nothing to compact yet"
+	}
+
+	var parts []string
+	if c.Summarized > 0 {
+		parts = append(parts, fmt.Sprintf("summarized %d earlier messages", c.Summarized))
+	}
+	if c.Elided > 0 {
+		parts = append(parts, fmt.Sprintf("elided %d old tool outputs", c.Elided))
+	}
+	return fmt.Sprintf("🗜️ Compacted conversation: %s (~%d → ~%d tokens)", strings.Join(parts, ", "), c.Before, c.After)
+}
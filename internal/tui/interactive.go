@@ -2,21 +2,27 @@ package tui
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"os"
 	"strings"
 
 	"github.com/atotto/clipboard"
+	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/textarea"
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/glamour"
 	"github.com/charmbracelet/lipgloss"
-	"github.com/firebase/genkit/go/ai"
 	"github.com/niradler/termu/internal/agent"
+	"github.com/niradler/termu/internal/audit"
+	"github.com/niradler/termu/internal/checkpoint"
 	"github.com/niradler/termu/internal/config"
+	"github.com/niradler/termu/internal/sandbox"
 	"github.com/niradler/termu/internal/security"
+	"github.com/niradler/termu/internal/session"
 	"github.com/niradler/termu/internal/shell"
+	"github.com/niradler/termu/internal/tools"
+	"github.com/niradler/termu/internal/usage"
 )
 
 type SessionState int
@@ -27,34 +33,91 @@ const (
 	StateApproval
 	StateExecuting
 	StateIterating
+	StateReview
+	// StateEditCommand edits a command awaiting approval before running it.
+	StateEditCommand
+	// StateRejectReason asks why a command awaiting approval is rejected.
+	StateRejectReason
 )
 
 type Message struct {
 	Role    string
 	Content string
+	// Detail is the collapsible body of a "tool" entry.
+	Detail string
 }
 
+// toolDetailLines bounds how much of a tool's arguments and result the
+// transcript keeps.
+const toolDetailLines = 40
+
 type Model struct {
 	ctx            context.Context
 	state          SessionState
 	textarea       textarea.Model
 	viewport       viewport.Model
 	messages       []Message
-	aiHistory      []ai.Message
 	currentCmd     string
+	currentRisk    string
+	currentReason  string
+	currentPattern string
+	// editedCmd replaces currentCmd when the user edited it, and editor
+	// holds the edited command or rejection reason being typed.
+	editedCmd      string
+	editor         textarea.Model
+	editError      string
 	currentInput   string
 	iterationCount int
 	maxIterations  int
-	retryCount     int
-	maxRetries     int
 	width          int
 	height         int
 	sandboxMode    bool
+	isolation      string
 	mdRenderer     *glamour.TermRenderer
 	agent          *agent.Agent
+	config         *config.Config
 	validator      *security.Validator
 	executor       *shell.Executor
+	audit          *audit.Logger
+	changeset      *sandbox.Changeset
+	// scratch is the copy of the working directory the session works in
+	// with --scratch; scratchChanges and selected hold its review.
+	scratch        *sandbox.Scratch
+	scratchChanges []sandbox.FileChange
+	selected       []bool
+	reviewCursor   int
+	checkpoints    *checkpoint.Store
+	exitSummary    string
+	session        *session.Session
+	store          *session.Store
+	saveFailed     bool
+	streamText     string
+	streamThinking string
+	streamStatus   string
+	showThinking   bool
+	showToolOutput bool
+	transcriptView string
 	workdir        string
+	// generation holds how to cancel the generation in progress. It is a
+	// pointer so the copy of the model that started it and the one handling
+	// keys share it.
+	generation *generation
+}
+
+type generation struct {
+	cancel context.CancelFunc
+}
+
+// Options configures a new chat Model.
+type Options struct {
+	Sandbox bool
+	// Session is a saved conversation to continue; nil starts a new one.
+	Session *session.Session
+	// Store persists the session after every turn; nil disables saving.
+	Store *session.Store
+	// Scratch runs the session in a temporary copy of the working
+	// directory, reviewed against the real tree on exit.
+	Scratch bool
 }
 
 type AgentResponseMsg struct {
@@ -62,10 +125,11 @@ type AgentResponseMsg struct {
 	Error    error
 }
 
-type CommandExecutedMsg struct {
-	Command string
-	Output  string
-	Error   error
+// StreamChunkMsg carries one streamed event of the generation in progress;
+// next yields the following message of the same generation.
+type StreamChunkMsg struct {
+	Event agent.StreamEvent
+	next  <-chan tea.Msg
 }
 
 type ApprovalRequestMsg struct {
@@ -76,7 +140,9 @@ type IterationCompleteMsg struct {
 	FinalText string
 }
 
-func NewModel(ctx context.Context, cfg *config.Config, sandboxMode bool) (Model, error) {
+func NewModel(ctx context.Context, cfg *config.Config, options Options) (Model, error) {
+	sandboxMode := options.Sandbox
+
 	ta := textarea.New()
 	ta.Placeholder = "Describe what you want to do..."
 	ta.Focus()
@@ -85,6 +151,13 @@ func NewModel(ctx context.Context, cfg *config.Config, sandboxMode bool) (Model,
 	ta.SetHeight(3)
 	ta.ShowLineNumbers = false
 
+	editor := textarea.New()
+	editor.CharLimit = 0
+	editor.SetWidth(80)
+	editor.SetHeight(3)
+	editor.ShowLineNumbers = false
+	editor.KeyMap.InsertNewline = key.NewBinding(key.WithKeys("ctrl+j"))
+
 	vp := viewport.New(80, 20)
 	vp.Style = lipgloss.NewStyle().
 		BorderStyle(lipgloss.RoundedBorder()).
@@ -97,76 +170,140 @@ func NewModel(ctx context.Context, cfg *config.Config, sandboxMode bool) (Model,
 		glamour.WithWordWrap(78),
 	)
 
-	ag, err := agent.New(ctx, cfg)
+	sess := options.Session
+	if sess == nil {
+		sess = session.New(cfg.Workdir, cfg.Model.Provider+"/"+cfg.Model.Name)
+	}
+
+	// In scratch mode the tools below work on the copy; approvals, the
+	// policy file and the audit log stay with the real directory.
+	var scratch *sandbox.Scratch
+	if options.Scratch {
+		if sandboxMode {
+			return Model{}, fmt.Errorf("sandbox and scratch modes cannot be combined")
+		}
+		var err error
+		scratch, err = sandbox.NewScratch(cfg.Workdir)
+		if err != nil {
+			return Model{}, fmt.Errorf("failed to create scratch workspace: %w", err)
+		}
+		cfg = scratch.Config(cfg)
+	}
+	auditLog, auditErr := audit.Open(cfg.Logging, cfg.ProjectDir())
+	auditLog.SetSession(sess.ID)
+	fail := func(err error) (Model, error) {
+		if scratch != nil {
+			scratch.Discard()
+		}
+		auditLog.Close()
+		return Model{}, err
+	}
+
+	validator := security.New(cfg)
+	executor := shell.New(cfg.Workdir, sandboxMode)
+	executor.SetOutputLimit(cfg.Security.MaxOutputBytes)
+	executor.SetEnvironment(cfg.Security.Environment.Allow, cfg.Security.Environment.Deny)
+	if err := executor.Isolate(cfg.Security.Isolation); err != nil {
+		return fail(err)
+	}
+	opts := agent.Options{Validator: validator, Executor: executor, Audit: auditLog}
+
+	var changeset *sandbox.Changeset
+	var checkpoints *checkpoint.Store
+	if sandboxMode {
+		changeset = sandbox.New(cfg.Workdir)
+		executor.SetRecorder(changeset)
+		opts.Files = changeset
+	} else if options.Store != nil && scratch == nil {
+		var err error
+		checkpoints, err = checkpoint.Open(options.Store.CheckpointDir(sess.ID))
+		if err != nil {
+			return fail(err)
+		}
+		opts.Files = checkpoints.Track(tools.DiskStore{})
+	}
+
+	ag, err := agent.New(ctx, cfg, opts)
 	if err != nil {
-		return Model{}, fmt.Errorf("failed to create agent: %w", err)
+		return fail(fmt.Errorf("failed to create agent: %w", err))
 	}
 
-	workdir, _ := os.Getwd()
+	messages := []Message{}
+	for _, entry := range sess.Transcript {
+		messages = append(messages, Message{Role: entry.Role, Content: entry.Content, Detail: entry.Detail})
+	}
+	ag.SetHistory(sess.History)
+	ag.SetUsage(sess.Usage)
 
-	return Model{
+	m := Model{
 		ctx:            ctx,
 		state:          StateInput,
 		textarea:       ta,
+		editor:         editor,
 		viewport:       vp,
-		messages:       []Message{},
-		aiHistory:      []ai.Message{},
+		messages:       messages,
 		iterationCount: 0,
 		maxIterations:  cfg.Security.MaxToolIterations,
-		retryCount:     0,
-		maxRetries:     2,
 		width:          80,
 		height:         24,
 		sandboxMode:    sandboxMode,
+		isolation:      executor.Isolation(),
 		mdRenderer:     renderer,
 		agent:          ag,
-		validator:      security.New(cfg),
-		executor:       shell.New(workdir, sandboxMode),
-		workdir:        workdir,
-	}, nil
-}
-
-func (m Model) Init() tea.Cmd {
-	return textarea.Blink
-}
+		config:         cfg,
+		validator:      validator,
+		executor:       executor,
+		audit:          auditLog,
+		changeset:      changeset,
+		scratch:        scratch,
+		checkpoints:    checkpoints,
+		session:        sess,
+		store:          options.Store,
+		workdir:        cfg.Workdir,
+		generation:     &generation{},
+	}
 
-func stripThinking(content string) string {
-	result := content
-	for {
-		start := strings.Index(result, "<think>")
-		if start == -1 {
-			break
-		}
-		end := strings.Index(result[start:], "</think>")
-		if end == -1 {
-			break
-		}
-		end += start + len("</think>")
-		result = result[:start] + result[end:]
+	if options.Session != nil {
+		m.messages = append(m.messages, Message{
+			Role:    "system",
+			Content: fmt.Sprintf("📂 Resumed session %s", sess.ID),
+		})
+		m.updateViewport()
 	}
-	return strings.TrimSpace(result)
+	if auditErr != nil {
+		m.messages = append(m.messages, Message{
+			Role:    "error",
+			Content: fmt.Sprintf("Audit log disabled: %v", auditErr),
+		})
+		m.updateViewport()
+	}
+	if scratch != nil {
+		m.messages = append(m.messages, Message{
+			Role:    "system",
+			Content: fmt.Sprintf("🧪 Working in a scratch copy at %s; review the changes against your tree when you exit", scratch.Workdir()),
+		})
+		m.updateViewport()
+	}
+	if err := validator.ProjectApprovalsError(); err != nil {
+		m.messages = append(m.messages, Message{
+			Role:    "error",
+			Content: fmt.Sprintf("Ignoring project approvals: %v", err),
+		})
+		m.updateViewport()
+	}
+	if err := validator.PolicyError(); err != nil {
+		m.messages = append(m.messages, Message{
+			Role:    "error",
+			Content: fmt.Sprintf("Commands and file operations are blocked until the policy file is fixed: %v", err),
+		})
+		m.updateViewport()
+	}
+
+	return m, nil
 }
 
-func extractThinking(content string) string {
-	var thinking strings.Builder
-	temp := content
-	for {
-		start := strings.Index(temp, "<think>")
-		if start == -1 {
-			break
-		}
-		end := strings.Index(temp[start:], "</think>")
-		if end == -1 {
-			break
-		}
-		thinkContent := temp[start+len("<think>") : start+end]
-		if thinking.Len() > 0 {
-			thinking.WriteString("\n\n")
-		}
-		thinking.WriteString(strings.TrimSpace(thinkContent))
-		temp = temp[start+end+len("</think>"):]
-	}
-	return thinking.String()
+func (m Model) Init() tea.Cmd {
+	return textarea.Blink
 }
 
 func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
@@ -175,43 +312,63 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
+		if m.state == StateReview && m.scratch != nil {
+			return m.updateScratchReview(msg)
+		}
+		if m.state == StateReview {
+			return m.updateReview(msg)
+		}
+		if m.state == StateApproval {
+			if model, cmd, handled := m.updateApproval(msg); handled {
+				return model, cmd
+			}
+		}
+		if m.state == StateEditCommand || m.state == StateRejectReason {
+			if model, cmd, handled := m.updateApprovalInput(msg); handled {
+				return model, cmd
+			}
+		}
+
 		switch msg.Type {
-		case tea.KeyCtrlC:
+		case tea.KeyCtrlC, tea.KeyCtrlD:
+			if m.scratch != nil {
+				return m.reviewScratch()
+			}
+			if m.changeset != nil && !m.changeset.Empty() {
+				m.state = StateReview
+				m.updateViewport()
+				return m, nil
+			}
 			return m, tea.Quit
 
-		case tea.KeyCtrlD:
-			return m, tea.Quit
+		case tea.KeyEsc:
+			if m.busy() && m.generation.cancel != nil {
+				m.generation.cancel()
+				m.streamStatus = "⏹️  Cancelling..."
+				m.refreshStream()
+				return m, nil
+			}
 
 		case tea.KeyEnter:
-			if m.state == StateInput && m.textarea.Value() != "" {
+			if m.state == StateInput && isSlashCommand(m.textarea.Value()) {
+				return m.runSlashCommand(m.textarea.Value())
+			} else if m.state == StateInput && m.textarea.Value() != "" {
 				userInput := m.textarea.Value()
 				m.messages = append(m.messages, Message{
 					Role:    "user",
 					Content: userInput,
 				})
 				m.textarea.Reset()
+				m.session.SetTitleFrom(userInput)
+				if m.checkpoints != nil {
+					m.checkpoints.Begin(userInput)
+				}
+				m.saveSession()
 				m.currentInput = userInput
 				m.iterationCount = 0
-				m.retryCount = 0
 				m.state = StateThinking
 				m.updateViewport()
 				return m, m.callAgent()
-			} else if m.state == StateApproval {
-				m.validator.ApproveCommand(m.currentCmd)
-				m.state = StateExecuting
-				m.updateViewport()
-				return m, m.executeCommand()
-			}
-
-		case tea.KeyEsc:
-			if m.state == StateApproval {
-				m.messages = append(m.messages, Message{
-					Role:    "system",
-					Content: "❌ Command rejected by user",
-				})
-				m.state = StateInput
-				m.currentCmd = ""
-				m.updateViewport()
 			}
 
 		case tea.KeyCtrlL:
@@ -219,29 +376,12 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.updateViewport()
 
 		case tea.KeyCtrlT:
-			lastAssistantMsg := ""
-			for i := len(m.messages) - 1; i >= 0; i-- {
-				if m.messages[i].Role == "assistant" {
-					lastAssistantMsg = m.messages[i].Content
-					break
-				}
-			}
+			m.showThinking = !m.showThinking
+			m.updateViewport()
 
-			if lastAssistantMsg != "" {
-				thinkingText := extractThinking(lastAssistantMsg)
-				if thinkingText != "" {
-					m.messages = append(m.messages, Message{
-						Role:    "thinking",
-						Content: thinkingText,
-					})
-				} else {
-					m.messages = append(m.messages, Message{
-						Role:    "system",
-						Content: "💭 No thinking process found in last response",
-					})
-				}
-				m.updateViewport()
-			}
+		case tea.KeyCtrlO:
+			m.showToolOutput = !m.showToolOutput
+			m.updateViewport()
 
 		case tea.KeyCtrlY:
 			lastAssistantMsg := ""
@@ -253,7 +393,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 
 			if lastAssistantMsg != "" {
-				cleanMsg := stripThinking(lastAssistantMsg)
+				cleanMsg := agent.StripThinking(lastAssistantMsg)
 				if err := clipboard.WriteAll(cleanMsg); err == nil {
 					m.messages = append(m.messages, Message{
 						Role:    "system",
@@ -275,125 +415,114 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.viewport.Width = msg.Width - 4
 		m.viewport.Height = msg.Height - 10
 		m.textarea.SetWidth(msg.Width - 4)
+		m.editor.SetWidth(msg.Width - 4)
 		m.updateViewport()
 
-	case AgentResponseMsg:
+	case StreamChunkMsg:
+		m.applyStreamEvent(msg.Event)
+		m.refreshStream()
+		return m, waitForStream(msg.next)
+
+	case CompactDoneMsg:
+		m.streamStatus = ""
+		m.state = StateInput
 		if msg.Error != nil {
 			m.messages = append(m.messages, Message{
 				Role:    "error",
-				Content: fmt.Sprintf("Agent error: %v", msg.Error),
+				Content: fmt.Sprintf("Failed to compact conversation: %v", msg.Error),
 			})
-			m.state = StateInput
-			m.updateViewport()
-			return m, nil
+		} else {
+			m.messages = append(m.messages, Message{
+				Role:    "system",
+				Content: compactionNote(msg.Compaction),
+			})
+			m.saveSession()
 		}
+		m.updateViewport()
+		return m, nil
 
-		m.messages = append(m.messages, Message{
-			Role:    "assistant",
-			Content: msg.Response.Text,
-		})
-
-		m.aiHistory = append(m.aiHistory, ai.Message{
-			Role:    ai.RoleModel,
-			Content: []*ai.Part{ai.NewTextPart(msg.Response.Text)},
-		})
+	case AgentResponseMsg:
+		m.streamText, m.streamThinking, m.streamStatus = "", "", ""
+		m.generation.cancel = nil
 
-		if msg.Response.Command == "" {
+		if errors.Is(msg.Error, context.Canceled) && m.ctx.Err() == nil {
+			m.messages = append(m.messages, Message{
+				Role:    "system",
+				Content: "⏹️  Cancelled; running commands were stopped",
+			})
 			m.state = StateInput
+			m.saveSession()
 			m.updateViewport()
 			return m, nil
 		}
-
-		m.messages = append(m.messages, Message{
-			Role:    "command",
-			Content: msg.Response.Command,
-		})
-
-		validation := m.validator.Validate(msg.Response.Command, m.workdir)
-		if !validation.Allowed {
+		if msg.Error != nil {
 			m.messages = append(m.messages, Message{
 				Role:    "error",
-				Content: fmt.Sprintf("Command blocked: %s", validation.Reason),
+				Content: fmt.Sprintf("Agent error: %v", msg.Error),
 			})
 			m.state = StateInput
+			m.saveSession()
 			m.updateViewport()
 			return m, nil
 		}
 
-		m.currentCmd = msg.Response.Command
-		m.retryCount = 0
-
-		if validation.NeedsApproval {
-			m.state = StateApproval
-			m.updateViewport()
-			return m, nil
+		if msg.Response.Compaction.Changed() {
+			m.messages = append(m.messages, Message{
+				Role:    "system",
+				Content: compactionNote(msg.Response.Compaction),
+			})
 		}
 
-		m.state = StateExecuting
-		m.updateViewport()
-		return m, m.executeCommand()
-
-	case CommandExecutedMsg:
-		if msg.Error != nil {
+		if model := msg.Response.Model; model != "" && model != m.agent.ModelName() {
 			m.messages = append(m.messages, Message{
-				Role:    "error",
-				Content: fmt.Sprintf("❌ Error: %v", msg.Error),
+				Role:    "system",
+				Content: fmt.Sprintf("↪️ %s was unavailable; fallback model %s answered", m.agent.ModelName(), model),
 			})
+		}
 
-			if m.retryCount < m.maxRetries {
-				m.retryCount++
-				m.messages = append(m.messages, Message{
-					Role:    "system",
-					Content: fmt.Sprintf("🔄 Retry %d/%d - letting termu try to fix it...", m.retryCount, m.maxRetries),
-				})
-
-				errorFeedback := fmt.Sprintf("Command failed: %s\n\nError:\n%s\n\nPlease try a different approach.", msg.Command, msg.Output)
-				m.aiHistory = append(m.aiHistory, ai.Message{
-					Role:    ai.RoleUser,
-					Content: []*ai.Part{ai.NewTextPart(errorFeedback)},
-				})
-
-				m.state = StateIterating
-				m.updateViewport()
-				return m, m.callAgent()
+		for _, call := range msg.Response.ToolCalls {
+			role := "tool"
+			if call.Error != "" {
+				role = "tool_error"
 			}
-
 			m.messages = append(m.messages, Message{
-				Role:    "system",
-				Content: fmt.Sprintf("❌ Failed after %d retries. Task incomplete.", m.maxRetries),
+				Role:    role,
+				Content: call.Summary(),
+				Detail:  call.Detail(toolDetailLines),
 			})
-			m.state = StateInput
-			m.updateViewport()
-			return m, nil
 		}
 
-		m.iterationCount++
-		m.retryCount = 0
-
-		m.messages = append(m.messages, Message{
-			Role:    "output",
-			Content: msg.Output,
-		})
-
-		toolOutput := fmt.Sprintf("Command executed successfully: %s\n\nOutput:\n%s", msg.Command, msg.Output)
-		m.aiHistory = append(m.aiHistory, ai.Message{
-			Role:    ai.RoleUser,
-			Content: []*ai.Part{ai.NewTextPart(toolOutput)},
-		})
-
-		if m.iterationCount >= m.maxIterations {
+		if msg.Response.Text != "" || msg.Response.Reasoning != "" {
+			content := msg.Response.Text
+			if msg.Response.Reasoning != "" {
+				content = "<think>" + msg.Response.Reasoning + "</think>\n" + content
+			}
 			m.messages = append(m.messages, Message{
-				Role:    "system",
-				Content: fmt.Sprintf("⚠️ Max iterations (%d) reached", m.maxIterations),
+				Role:    "assistant",
+				Content: content,
 			})
+		}
+
+		if msg.Response.Command == "" {
 			m.state = StateInput
+			m.saveSession()
 			m.updateViewport()
 			return m, nil
 		}
 
-		m.state = StateIterating
+		m.messages = append(m.messages, Message{
+			Role:    "command",
+			Content: msg.Response.Command,
+		})
+
+		m.currentCmd = msg.Response.Command
+		m.editedCmd = ""
+		m.currentRisk = msg.Response.Risk
+		m.currentReason = msg.Response.Reason
+		m.currentPattern = m.validator.SuggestPattern(msg.Response.Command)
+		m.state = StateApproval
 		m.updateViewport()
-		return m, m.callAgent()
+		return m, nil
 
 	case ApprovalRequestMsg:
 		m.currentCmd = msg.Command
@@ -431,19 +560,37 @@ func (m Model) View() string {
 		if m.iterationCount > 0 {
 			status = fmt.Sprintf("🤔 termu is thinking... [%d/%d]", m.iterationCount+1, m.maxIterations)
 		}
+		if m.streamStatus != "" {
+			status = m.streamStatus
+		}
 		b.WriteString(InfoStyle.Render(status))
 	} else if m.state == StateIterating {
-		b.WriteString(InfoStyle.Render(
-			fmt.Sprintf("🔄 termu analyzing results... [%d/%d]", m.iterationCount+1, m.maxIterations)))
+		status := fmt.Sprintf("🔄 termu analyzing results... [%d/%d]", m.iterationCount+1, m.maxIterations)
+		if m.streamStatus != "" {
+			status = m.streamStatus
+		}
+		b.WriteString(InfoStyle.Render(status))
 	} else if m.state == StateApproval {
 		b.WriteString(m.renderApproval())
+	} else if m.state == StateEditCommand || m.state == StateRejectReason {
+		b.WriteString(m.renderApprovalInput())
+	} else if m.state == StateReview && m.scratch != nil {
+		b.WriteString(m.renderScratchReview())
+	} else if m.state == StateReview {
+		b.WriteString(m.renderReview())
 	} else if m.state == StateExecuting {
 		status := "⚡ Executing command..."
 		if m.iterationCount > 0 {
 			status = fmt.Sprintf("⚡ Executing... [%d/%d]", m.iterationCount, m.maxIterations)
 		}
+		if m.streamStatus != "" {
+			status = m.streamStatus
+		}
 		b.WriteString(InfoStyle.Render(status))
 	}
+	if m.busy() {
+		b.WriteString(HelpStyle.Render(" • Esc: cancel"))
+	}
 
 	b.WriteString("\n\n")
 	b.WriteString(m.renderFooter())
@@ -461,6 +608,22 @@ func (m Model) renderHeader() string {
 		mode = StatusBarStyle.Render(" SESSION ")
 	}
 
+	if m.scratch != nil {
+		mode += " " + SandboxStyle.Render(" SCRATCH ")
+	}
+	if m.isolation != "" {
+		mode += " " + StatusBarStyle.Render(" ISOLATED ")
+	}
+
+	model := m.agent.ModelName()
+	if m.config.Profile != "" {
+		model = m.config.Profile + " · " + model
+	}
+	mode += " " + HelpStyle.Render(model)
+	if status := m.usageStatus(); status != "" {
+		mode += " " + HelpStyle.Render("· "+status)
+	}
+
 	var status string
 	if m.state == StateIterating || m.state == StateExecuting {
 		status = HelpStyle.Render(fmt.Sprintf(" [%d/%d]", m.iterationCount, m.maxIterations))
@@ -469,28 +632,126 @@ func (m Model) renderHeader() string {
 	return lipgloss.JoinHorizontal(lipgloss.Top, title, " ", mode, status)
 }
 
+// usageStatus summarizes the tokens the session has used, what they cost
+// when the models have prices, and how full the context window is.
+func (m Model) usageStatus() string {
+	var parts []string
+
+	tally := m.agent.Usage()
+	if total := tally.Total(); total.Requests > 0 {
+		tokens := fmt.Sprintf("↑%s ↓%s", usage.FormatTokens(total.Input), usage.FormatTokens(total.Output))
+		if total.Estimated {
+			tokens = "~" + tokens
+		}
+		parts = append(parts, tokens)
+		if cost, priced := tally.Cost(m.config); cost > 0 || priced {
+			parts = append(parts, usage.FormatCost(cost))
+		}
+	}
+
+	if used, size := m.agent.ContextUsage(); size > 0 {
+		parts = append(parts, fmt.Sprintf("ctx %d%%", used*100/size))
+	}
+	return strings.Join(parts, " · ")
+}
+
 func (m Model) renderFooter() string {
 	help := HelpStyle.Render(
-		"Enter: Send/Approve • Esc: Reject • Ctrl+Y: Copy Last Response • Ctrl+T: Show Thinking • Ctrl+L: Clear • Ctrl+D: Exit",
+		"Enter: Send/Approve • Esc: Reject • Ctrl+Y: Copy Last Response • Ctrl+T: Toggle Thinking • Ctrl+O: Toggle Tool Output • /compact • /undo • /rewind • /approvals • /model • Ctrl+L: Clear • Ctrl+D: Exit",
 	)
 	return help
 }
 
-func (m Model) renderApproval() string {
+func (m Model) renderReview() string {
 	var b strings.Builder
 
-	b.WriteString(ApprovalStyle.Render("⚠️  Command Approval Required"))
-	b.WriteString("\n\n")
-	b.WriteString(CommandStyle.Render(m.currentCmd))
+	b.WriteString(ApprovalStyle.Render("🧪 Review Sandbox Changeset"))
 	b.WriteString("\n\n")
-	b.WriteString(SuccessStyle.Render("Press Enter to approve"))
+	b.WriteString(SuccessStyle.Render("a: apply file changes and exit"))
 	b.WriteString(" • ")
-	b.WriteString(ErrorStyle.Render("Press Esc to reject"))
+	b.WriteString(ErrorStyle.Render("d: discard and exit"))
+	b.WriteString(" • ")
+	b.WriteString(HelpStyle.Render("Esc: back to chat"))
+
+	return b.String()
+}
+
+// updateReview handles keys while the sandbox changeset is under review.
+func (m Model) updateReview(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "a":
+		if err := m.changeset.Apply(); err != nil {
+			m.messages = append(m.messages, Message{
+				Role:    "error",
+				Content: fmt.Sprintf("Failed to apply changeset: %v", err),
+			})
+			m.state = StateInput
+			m.updateViewport()
+			return m, nil
+		}
+		m.exitSummary = "✅ Sandbox changeset applied"
+		return m, tea.Quit
+
+	case "d", "ctrl+c", "ctrl+d":
+		m.changeset.Discard()
+		m.exitSummary = "🗑️  Sandbox changeset discarded"
+		return m, tea.Quit
+
+	case "esc":
+		m.state = StateInput
+		m.updateViewport()
+		return m, nil
+	}
+
+	var cmd tea.Cmd
+	m.viewport, cmd = m.viewport.Update(msg)
+	return m, cmd
+}
+
+// ExitSummary describes what happened to the sandbox changeset when the
+// session ended, or is empty when there was nothing to review.
+func (m Model) ExitSummary() string {
+	return m.exitSummary
+}
 
+// Close releases what the session kept on disk, such as the full output of
+// truncated commands, and closes the audit log.
+func (m Model) Close() {
+	m.executor.Close()
+	m.audit.Close()
+}
+
+func renderChangeset(summary string) string {
+	var b strings.Builder
+	for _, line := range strings.Split(summary, "\n") {
+		switch {
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+			b.WriteString(PromptStyle.Render(line))
+		case strings.HasPrefix(line, "@@"):
+			b.WriteString(InfoStyle.Render(line))
+		case strings.HasPrefix(line, "+"):
+			b.WriteString(SuccessStyle.Render(line))
+		case strings.HasPrefix(line, "-"):
+			b.WriteString(ErrorStyle.Render(line))
+		default:
+			b.WriteString(line)
+		}
+		b.WriteString("\n")
+	}
 	return b.String()
 }
 
 func (m *Model) updateViewport() {
+	if m.state == StateReview && m.scratch != nil {
+		m.viewport.SetContent(m.scratchReviewContent())
+		return
+	}
+	if m.state == StateReview {
+		m.viewport.SetContent(renderChangeset(m.changeset.Summary()))
+		m.viewport.GotoTop()
+		return
+	}
+
 	var b strings.Builder
 
 	for _, msg := range m.messages {
@@ -503,13 +764,21 @@ func (m *Model) updateViewport() {
 		case "assistant":
 			b.WriteString(InfoStyle.Render("🤖 termu: "))
 			b.WriteString("\n")
-			displayContent := stripThinking(msg.Content)
-			if rendered, err := m.mdRenderer.Render(displayContent); err == nil {
-				b.WriteString(rendered)
+			b.WriteString(m.renderThinking(agent.ExtractThinking(msg.Content), false))
+			b.WriteString(m.renderMarkdown(agent.StripThinking(msg.Content)))
+			b.WriteString("\n")
+
+		case "tool", "tool_error":
+			if msg.Role == "tool_error" {
+				b.WriteString(ErrorStyle.Render("🔧 " + msg.Content))
 			} else {
-				b.WriteString(displayContent)
+				b.WriteString(InfoStyle.Render("🔧 " + msg.Content))
 			}
 			b.WriteString("\n")
+			if m.showToolOutput && msg.Detail != "" {
+				b.WriteString(OutputStyle.Render(msg.Detail))
+				b.WriteString("\n")
+			}
 
 		case "command":
 			b.WriteString(WarningStyle.Render("📝 Generated Command:"))
@@ -541,40 +810,150 @@ func (m *Model) updateViewport() {
 		}
 	}
 
+	m.transcriptView = b.String()
+	m.refreshStream()
+}
+
+// refreshStream redraws the viewport from the cached transcript plus the
+// partial answer being streamed, so each chunk only renders the new tail.
+func (m *Model) refreshStream() {
+	var b strings.Builder
+	b.WriteString(m.transcriptView)
+
+	if m.streamText != "" || m.streamThinking != "" {
+		thinking, answer := agent.SplitThinking(m.streamText)
+		if m.streamThinking != "" {
+			thinking = strings.TrimSpace(m.streamThinking + "\n\n" + thinking)
+		}
+		b.WriteString(InfoStyle.Render("🤖 termu: "))
+		b.WriteString("\n")
+		b.WriteString(m.renderThinking(thinking, answer == ""))
+		b.WriteString(m.renderMarkdown(answer))
+		b.WriteString("\n")
+	}
+
 	m.viewport.SetContent(b.String())
 	m.viewport.GotoBottom()
 }
 
-func (m Model) callAgent() tea.Cmd {
-	return func() tea.Msg {
-		resp, err := m.agent.Generate(m.ctx, m.currentInput, m.aiHistory)
-		return AgentResponseMsg{
-			Response: resp,
-			Error:    err,
-		}
+func (m *Model) renderMarkdown(content string) string {
+	if rendered, err := m.mdRenderer.Render(content); err == nil {
+		return rendered
 	}
+	return content
 }
 
-func (m Model) executeCommand() tea.Cmd {
-	return func() tea.Msg {
-		result, err := m.executor.Execute(m.ctx, m.currentCmd)
+// renderThinking renders a reasoning section, collapsed to a one-line
+// summary unless expanded with Ctrl+T. While the model is still reasoning
+// the collapsed form previews the latest line.
+func (m *Model) renderThinking(thinking string, inProgress bool) string {
+	if thinking == "" {
+		return ""
+	}
 
-		var cmdErr error
-		if err != nil {
-			cmdErr = fmt.Errorf("execution failed: %w", err)
-		} else if result.ExitCode != 0 {
-			cmdErr = fmt.Errorf("command exited with code %d", result.ExitCode)
-		}
+	var b strings.Builder
+	if m.showThinking {
+		b.WriteString(HelpStyle.Render("💭 Thinking (Ctrl+T to collapse):"))
+		b.WriteString("\n")
+		b.WriteString(HelpStyle.Render(thinking))
+		b.WriteString("\n")
+		return b.String()
+	}
+
+	lines := strings.Split(thinking, "\n")
+	summary := fmt.Sprintf("💭 Thought for %d lines (Ctrl+T to expand)", len(lines))
+	if inProgress {
+		summary = "💭 Thinking… " + agent.Clip(strings.TrimSpace(lines[len(lines)-1]), 80)
+	}
+	b.WriteString(HelpStyle.Render(summary))
+	b.WriteString("\n")
+	return b.String()
+}
 
-		output := result.Output
-		if result.Error != "" && cmdErr != nil {
-			output = result.Output + "\nError: " + result.Error
+func (m *Model) applyStreamEvent(event agent.StreamEvent) {
+	switch event.Kind {
+	case agent.StreamText:
+		m.streamText += event.Text
+		m.streamStatus = ""
+	case agent.StreamReasoning:
+		m.streamThinking += event.Text
+	case agent.StreamToolStart:
+		m.streamStatus = fmt.Sprintf("🔧 Running %s...", event.ToolName)
+		if m.streamText != "" && !strings.HasSuffix(m.streamText, "\n\n") {
+			m.streamText += "\n\n"
 		}
+	case agent.StreamToolEnd:
+		m.streamStatus = fmt.Sprintf("✔ %s finished", event.ToolName)
+	case agent.StreamCompact:
+		m.streamStatus = "🗜️ Summarizing earlier turns to fit the context window..."
+	case agent.StreamRetry:
+		m.streamStatus = "🔁 " + agent.Clip(event.Text, 160)
+	}
+}
+
+// saveSession persists the transcript and model history. A failure is
+// reported once in the transcript rather than on every turn.
+func (m *Model) saveSession() {
+	if m.store == nil {
+		return
+	}
+
+	m.session.Transcript = m.session.Transcript[:0]
+	for _, msg := range m.messages {
+		m.session.Transcript = append(m.session.Transcript, session.Entry{Role: msg.Role, Content: msg.Content, Detail: msg.Detail})
+	}
+	m.session.History = m.agent.History()
+	m.session.Usage = m.agent.Usage()
+
+	if err := m.store.Save(m.session); err != nil && !m.saveFailed {
+		m.saveFailed = true
+		m.messages = append(m.messages, Message{
+			Role:    "error",
+			Content: fmt.Sprintf("Failed to save session: %v", err),
+		})
+	}
+}
+
+func (m Model) callAgent() tea.Cmd {
+	return m.streamAgent(func(ctx context.Context, onEvent agent.StreamFunc) (*agent.Response, error) {
+		return m.agent.GenerateStream(ctx, m.currentInput, onEvent)
+	})
+}
+
+func (m Model) resumeAgent(decision agent.Decision) tea.Cmd {
+	return m.streamAgent(func(ctx context.Context, onEvent agent.StreamFunc) (*agent.Response, error) {
+		return m.agent.ResumeStream(ctx, decision, onEvent)
+	})
+}
 
-		return CommandExecutedMsg{
-			Command: m.currentCmd,
-			Output:  output,
-			Error:   cmdErr,
+// busy reports whether a generation is in progress and can be cancelled.
+func (m Model) busy() bool {
+	return m.state == StateThinking || m.state == StateExecuting || m.state == StateIterating
+}
+
+// streamAgent runs a generation in the background, delivering its stream
+// events and then its final AgentResponseMsg through a single channel so
+// they reach Update in order. Esc cancels it, killing running commands.
+func (m Model) streamAgent(run func(context.Context, agent.StreamFunc) (*agent.Response, error)) tea.Cmd {
+	ctx, cancel := context.WithCancel(m.ctx)
+	m.generation.cancel = cancel
+	ch := make(chan tea.Msg, 64)
+	go func() {
+		defer close(ch)
+		defer cancel()
+		resp, err := run(ctx, func(event agent.StreamEvent) {
+			ch <- StreamChunkMsg{Event: event, next: ch}
+		})
+		ch <- AgentResponseMsg{
+			Response: resp,
+			Error:    err,
 		}
+	}()
+	return waitForStream(ch)
+}
+
+func waitForStream(ch <-chan tea.Msg) tea.Cmd {
+	return func() tea.Msg {
+		return <-ch
 	}
 }
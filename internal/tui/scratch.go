@@ -0,0 +1,152 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/niradler/termu/internal/sandbox"
+)
+
+// reviewScratch ends a scratch session: with no changes the copy is
+// removed at once, otherwise its diff against the real tree is reviewed.
+func (m Model) reviewScratch() (tea.Model, tea.Cmd) {
+	changes, err := m.scratch.Changes()
+	if err != nil {
+		m.messages = append(m.messages, Message{
+			Role:    "error",
+			Content: fmt.Sprintf("Failed to compare the scratch workspace: %v (Ctrl+C again keeps it at %s)", err, m.scratch.Workdir()),
+		})
+		m.scratch = nil
+		m.updateViewport()
+		return m, nil
+	}
+	if len(changes) == 0 {
+		m.scratch.Discard()
+		m.exitSummary = "🗑️  Scratch workspace removed; it had no changes"
+		return m, tea.Quit
+	}
+
+	m.scratchChanges = changes
+	m.selected = make([]bool, len(changes))
+	m.reviewCursor = 0
+	m.state = StateReview
+	m.updateViewport()
+	m.viewport.GotoTop()
+	return m, nil
+}
+
+// updateScratchReview handles keys while the scratch workspace is under
+// review: moving between files, selecting them, and applying or
+// discarding.
+func (m Model) updateScratchReview(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "up", "k":
+		if m.reviewCursor > 0 {
+			m.reviewCursor--
+		}
+		m.updateViewport()
+		return m, nil
+
+	case "down", "j":
+		if m.reviewCursor < len(m.scratchChanges)-1 {
+			m.reviewCursor++
+		}
+		m.updateViewport()
+		return m, nil
+
+	case " ", "x":
+		m.selected[m.reviewCursor] = !m.selected[m.reviewCursor]
+		m.updateViewport()
+		return m, nil
+
+	case "a":
+		return m.applyScratch(m.scratchChanges)
+
+	case "s":
+		var selected []sandbox.FileChange
+		for i, change := range m.scratchChanges {
+			if m.selected[i] {
+				selected = append(selected, change)
+			}
+		}
+		if len(selected) == 0 {
+			return m, nil
+		}
+		return m.applyScratch(selected)
+
+	case "d", "ctrl+c", "ctrl+d":
+		m.scratch.Discard()
+		m.exitSummary = "🗑️  Scratch workspace discarded"
+		return m, tea.Quit
+
+	case "esc":
+		m.state = StateInput
+		m.updateViewport()
+		return m, nil
+	}
+
+	var cmd tea.Cmd
+	m.viewport, cmd = m.viewport.Update(msg)
+	return m, cmd
+}
+
+func (m Model) applyScratch(changes []sandbox.FileChange) (tea.Model, tea.Cmd) {
+	if err := m.scratch.Apply(changes); err != nil {
+		m.messages = append(m.messages, Message{
+			Role:    "error",
+			Content: fmt.Sprintf("Failed to apply scratch changes: %v", err),
+		})
+		m.state = StateInput
+		m.updateViewport()
+		return m, nil
+	}
+
+	m.scratch.Discard()
+	m.exitSummary = fmt.Sprintf("✅ Applied %d of %d changed file(s) from the scratch workspace", len(changes), len(m.scratchChanges))
+	return m, tea.Quit
+}
+
+// scratchReviewContent lists the changed files with their selection,
+// followed by the aggregate diff.
+func (m Model) scratchReviewContent() string {
+	var b strings.Builder
+	for i, change := range m.scratchChanges {
+		cursor := "  "
+		if i == m.reviewCursor {
+			cursor = "▸ "
+		}
+		mark := "[ ]"
+		if m.selected[i] {
+			mark = "[x]"
+		}
+		added, removed := change.Stats()
+		line := fmt.Sprintf("%s%s %s (%s, +%d -%d)", cursor, mark, m.scratch.RelPath(change.Path), change.Status(), added, removed)
+		if i == m.reviewCursor {
+			line = PromptStyle.Render(line)
+		}
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+	b.WriteString("\n")
+	b.WriteString(renderChangeset(m.scratch.Diff(m.scratchChanges)))
+	return b.String()
+}
+
+func (m Model) renderScratchReview() string {
+	var b strings.Builder
+
+	b.WriteString(ApprovalStyle.Render("🧪 Review Scratch Workspace"))
+	b.WriteString("\n\n")
+	b.WriteString(SuccessStyle.Render("a: apply all"))
+	b.WriteString(" • ")
+	b.WriteString(SuccessStyle.Render("s: apply selected"))
+	b.WriteString(" • ")
+	b.WriteString(InfoStyle.Render("↑/↓: move • space: select"))
+	b.WriteString(" • ")
+	b.WriteString(ErrorStyle.Render("d: discard and exit"))
+	b.WriteString(" • ")
+	b.WriteString(HelpStyle.Render("Esc: back to chat"))
+
+	return b.String()
+}
@@ -0,0 +1,115 @@
+// Package usage tallies the tokens sent to and received from models and
+// what they cost.
+package usage
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/niradler/termu/internal/config"
+)
+
+// Tokens counts the tokens of one or more model requests.
+type Tokens struct {
+	Requests int `json:"requests"`
+	Input    int `json:"input_tokens"`
+	Output   int `json:"output_tokens"`
+	// Estimated is set when some of the counts were estimated because the
+	// provider did not report them.
+	Estimated bool `json:"estimated,omitempty"`
+}
+
+// Add adds other to t.
+func (t *Tokens) Add(other Tokens) {
+	t.Requests += other.Requests
+	t.Input += other.Input
+	t.Output += other.Output
+	t.Estimated = t.Estimated || other.Estimated
+}
+
+// Cost returns what the tokens cost at price, in dollars.
+func (t Tokens) Cost(price config.Price) float64 {
+	return (float64(t.Input)*price.Input + float64(t.Output)*price.Output) / 1e6
+}
+
+// Tally is token usage by model, keyed by provider/name.
+type Tally map[string]Tokens
+
+// Add records tokens used by model.
+func (t Tally) Add(model string, tokens Tokens) {
+	total := t[model]
+	total.Add(tokens)
+	t[model] = total
+}
+
+// Merge adds every model's usage in other to t.
+func (t Tally) Merge(other Tally) {
+	for model, tokens := range other {
+		t.Add(model, tokens)
+	}
+}
+
+// Total returns the usage of all models together.
+func (t Tally) Total() Tokens {
+	var total Tokens
+	for _, tokens := range t {
+		total.Add(tokens)
+	}
+	return total
+}
+
+// Models returns the models in t, sorted.
+func (t Tally) Models() []string {
+	models := make([]string, 0, len(t))
+	for model := range t {
+		models = append(models, model)
+	}
+	sort.Strings(models)
+	return models
+}
+
+// Cost returns what the usage cost with the prices in cfg, and whether
+// every model used has a price. Models without one count as free.
+func (t Tally) Cost(cfg *config.Config) (float64, bool) {
+	cost, priced := 0.0, true
+	for model, tokens := range t {
+		price, ok := cfg.PriceOf(model)
+		if !ok {
+			priced = false
+			continue
+		}
+		cost += tokens.Cost(price)
+	}
+	return cost, priced
+}
+
+// Clone returns a copy of t.
+func (t Tally) Clone() Tally {
+	clone := make(Tally, len(t))
+	for model, tokens := range t {
+		clone[model] = tokens
+	}
+	return clone
+}
+
+// FormatTokens shortens a token count for display: 950, 12.3k, 1.2M.
+func FormatTokens(n int) string {
+	switch {
+	case n >= 1e6:
+		return fmt.Sprintf("%.1fM", float64(n)/1e6)
+	case n >= 1e4:
+		return fmt.Sprintf("%.0fk", float64(n)/1e3)
+	case n >= 1e3:
+		return fmt.Sprintf("%.1fk", float64(n)/1e3)
+	}
+	return fmt.Sprint(n)
+}
+
+// FormatCost renders a cost in dollars with enough precision for small
+// amounts.
+func FormatCost(cost float64) string {
+	if cost > 0 && cost < 0.01 {
+		return fmt.Sprintf("$%.4f", cost)
+	}
+	return fmt.Sprintf("$%.2f", cost)
+}